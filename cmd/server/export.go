@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/config"
+	"chat-service/internal/encryption"
+)
+
+// runExport implements the export subcommand, which writes conversations
+// from a history directory without starting the server:
+//
+//	chat-service export -owner key-1a2b3c4d5e6f [-id ID] [-format json|markdown|jsonl] [-o FILE] [-key-file FILE]
+func runExport(args []string, stdout io.Writer) error {
+	// The configuration is optional here; when it loads, it supplies the
+	// history directory, encryption keys and persona prompts for JSONL
+	// system messages.
+	cfg, cfgErr := config.Load(nil)
+
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("dir", os.Getenv("HISTORY_DIR"), "history directory to read (HISTORY_DIR)")
+	keyFile := fs.String("key-file", os.Getenv("ENCRYPTION_KEY_FILE"), "encryption key file for encrypted history (ENCRYPTION_KEY_FILE)")
+	owner := fs.String("owner", "", "identity whose conversations to export, e.g. key-1a2b3c4d5e6f or anonymous")
+	id := fs.String("id", "", "export only this conversation")
+	formatName := fs.String("format", "json", "output format: json, markdown or jsonl")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var keyEntries []string
+	if cfgErr == nil {
+		if *dir == "" {
+			*dir = cfg.HistoryDir
+		}
+		if *keyFile == "" {
+			*keyFile = cfg.EncryptionKeyFile
+		}
+		keyEntries = cfg.EncryptionKeys
+	} else {
+		keyEntries = config.EnvList("ENCRYPTION_KEYS")
+	}
+	if *dir == "" {
+		return errors.New("export: a history directory is required (-dir or HISTORY_DIR)")
+	}
+	if *owner == "" {
+		return errors.New("export: -owner is required")
+	}
+	format, err := chat.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	// NewFileStore creates missing directories; a typo should fail instead.
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+	if *keyFile != "" {
+		keyEntries = nil // an explicit key file wins
+	}
+	keys, err := encryption.LoadKeyring(keyEntries, *keyFile)
+	if err != nil {
+		return err
+	}
+	var storeOpts []chat.FileStoreOption
+	if keys != nil {
+		storeOpts = append(storeOpts, chat.WithEncryption(keys))
+	}
+	store, err := chat.NewFileStore(*dir, storeOpts...)
+	if err != nil {
+		return err
+	}
+	var opts []chat.Option
+	if cfgErr == nil {
+		opts = append(opts, chat.WithPersonas(personaLookup(config.NewHolder(cfg, nil))))
+	}
+	svc := chat.NewService(chat.NewHistoryManagerWithStore(store), nil, opts...)
+
+	ctx := context.Background()
+	var ts []chat.Transcript
+	if *id != "" {
+		t, err := svc.ExportConversation(ctx, *owner, *id)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", *id, err)
+		}
+		ts = append(ts, t)
+	} else if ts, err = svc.ExportConversations(ctx, *owner); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return chat.WriteTranscripts(stdout, format, ts...)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := chat.WriteTranscripts(f, format, ts...); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -2,37 +2,158 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 
 	"chat-service/internal/api"
+	"chat-service/internal/cache"
 	"chat-service/internal/chat"
 	"chat-service/internal/config"
+	"chat-service/internal/encryption"
+	"chat-service/internal/injection"
 	"chat-service/internal/llm"
+	"chat-service/internal/mcp"
+	"chat-service/internal/moderation"
+	"chat-service/internal/rag"
+	"chat-service/internal/redact"
+	"chat-service/internal/requestid"
+	"chat-service/internal/telemetry"
+	"chat-service/internal/templates"
+	"chat-service/internal/tools"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	logger := slog.New(requestid.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))
+	slog.SetDefault(logger)
+
+	// .env is a local convenience; in containers the variables are usually
+	// set directly, so a missing file is not an error.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		slog.Error("Error loading .env file", "error", err)
 		os.Exit(1)
 	}
 
-	cfg := config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err := runExport(os.Args[2:], os.Stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		if err != nil {
+			slog.Error("Export failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
+	}
 
-	history := chat.NewHistoryManager()
-	llmClient := llm.NewClient(cfg)
-	chatService := chat.NewService(history, llmClient)
-	apiHandler := api.NewHandler(chatService)
-	router := api.NewRouter(apiHandler, cfg)
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	holder := config.NewHolder(cfg, os.Args[1:])
+
+	history, err := newHistoryManager(cfg)
+	if err != nil {
+		slog.Error("Failed to open history storage", "error", err)
+		os.Exit(1)
+	}
+	llmClient := llm.NewClient(holder)
+	// Tools offered to the model; register server-side tools here.
+	toolRegistry := chat.NewToolRegistry()
+	err = tools.Register(toolRegistry, cfg.BuiltinTools, tools.FetchOptions{
+		Allowlist: cfg.FetchAllowlist,
+		MaxBytes:  int64(cfg.FetchMaxBytes),
+		Timeout:   cfg.FetchTimeout,
+	})
+	if err != nil {
+		slog.Error("Failed to register tools", "error", err)
+		os.Exit(1)
+	}
+	mcpClients := connectMCP(cfg, toolRegistry)
+	defer func() {
+		for _, c := range mcpClients {
+			c.Close()
+		}
+	}()
+	embedder, err := llm.NewEmbedder(cfg)
+	if err != nil {
+		slog.Error("Failed to set up embeddings", "error", err)
+		os.Exit(1)
+	}
+	documents, err := rag.Open(context.Background(), rag.Options{
+		Dir:           cfg.DocumentsDir,
+		ChunkSize:     cfg.DocumentChunkSize,
+		ChunkOverlap:  cfg.DocumentChunkOverlap,
+		Embedder:      embedder,
+		MinSimilarity: cfg.RetrievalMinSimilarity,
+	})
+	if err != nil {
+		slog.Error("Failed to open document index", "error", err)
+		os.Exit(1)
+	}
+	templateLibrary, err := templates.Open(cfg.TemplatesDir, func() []chat.Template {
+		return holder.Current().PromptTemplates()
+	})
+	if err != nil {
+		slog.Error("Failed to open template library", "error", err)
+		os.Exit(1)
+	}
+	// Left a nil interface when off; a nil *cache.Cache would not be.
+	var responseCache chat.ResponseCache
+	if cfg.ResponseCacheTTL > 0 {
+		responseCache = cache.New(cache.Options{
+			TTL:           cfg.ResponseCacheTTL,
+			MaxEntries:    cfg.ResponseCacheMaxEntries,
+			MinSimilarity: cfg.ResponseCacheSimilarity,
+		})
+	}
+	chatService := chat.NewService(history, llmClient,
+		chat.WithPersonas(personaLookup(holder)),
+		chat.WithRedactor(redactorFor(holder)),
+		chat.WithModerator(moderatorFor(holder, llmClient), cfg.ModerationWindow),
+		chat.WithInjectionGuard(injectionPolicy(holder, llmClient)),
+		chat.WithTools(toolRegistry, cfg.ToolMaxRounds),
+		chat.WithDocuments(documents, cfg.RetrievalTopK),
+		chat.WithEmbedder(embedder),
+		chat.WithResponseCache(responseCache),
+		chat.WithOutputRetries(cfg.StructuredOutputRetries),
+		chat.WithTemplates(templateLibrary),
+	)
+	apiHandler := api.NewHandler(chatService,
+		api.HealthCheck{Name: "config", Check: func(context.Context) error { return holder.Current().Validate() }},
+		api.HealthCheck{Name: "storage", Check: history.CheckHealth},
+		api.HealthCheck{Name: "upstream", Check: llmClient.CheckHealth},
+	)
+	router := api.NewRouter(apiHandler, holder)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go watchReloads(reloadCtx, holder, hup)
+	go history.RunRetention(reloadCtx, retentionPolicy(holder), logSweep)
 
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
@@ -51,7 +172,16 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	<-stop
-	slog.Info("Shutting down server...")
+	stopReload()
+	shutdownTimeout := holder.Current().ShutdownTimeout
+	slog.Info("Shutting down server...", "drain_timeout", shutdownTimeout)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+
+	if err := apiHandler.Drain(drainCtx); err != nil {
+		slog.Warn("Drain deadline reached, interrupted remaining streams", "error", err)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -60,5 +190,204 @@ func main() {
 		slog.Error("Server forced to shutdown", "error", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
 	slog.Info("Server exited")
 }
+
+// watchReloads reloads the configuration on SIGHUP and whenever the config
+// file changes on disk.
+func watchReloads(ctx context.Context, holder *config.Holder, hup <-chan os.Signal) {
+	logResult := func(err error) {
+		if err != nil {
+			slog.Error("Configuration reload failed", "error", err)
+			return
+		}
+		slog.Info("Configuration reloaded")
+	}
+
+	go holder.Watch(ctx, 2*time.Second, logResult)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logResult(holder.Reload())
+		}
+	}
+}
+
+// retentionPolicy reads the retention limits from the current configuration.
+func retentionPolicy(holder *config.Holder) func() chat.RetentionPolicy {
+	return func() chat.RetentionPolicy {
+		cfg := holder.Current()
+		return chat.RetentionPolicy{
+			MaxAge:           cfg.RetentionMaxAge,
+			MaxMessages:      cfg.RetentionMaxMessages,
+			MaxConversations: cfg.RetentionMaxConversations,
+			SweepInterval:    cfg.RetentionSweepInterval,
+		}
+	}
+}
+
+func logSweep(res chat.SweepResult, err error) {
+	if err != nil {
+		slog.Error("Retention sweep failed", "error", err)
+		return
+	}
+	slog.Info("Retention sweep removed expired history", "conversations", res.Conversations, "messages", res.Messages)
+}
+
+// personaLookup resolves personas from the current configuration.
+func personaLookup(holder *config.Holder) chat.PersonaLookup {
+	return func(name string) (chat.Persona, bool) {
+		cfg := holder.Current()
+		if name == "" {
+			name = cfg.DefaultPersona
+		}
+		p, ok := cfg.Personas[name]
+		if !ok {
+			return chat.Persona{}, false
+		}
+		return chat.Persona{Name: name, SystemPrompt: p.SystemPrompt, ToolSources: p.MCPServers}, true
+	}
+}
+
+// mcpConnectTimeout bounds connecting to one MCP server and listing what it
+// offers.
+const mcpConnectTimeout = 30 * time.Second
+
+// connectMCP connects to the configured MCP servers and registers their
+// tools and prompts. A server that cannot be reached is logged and left
+// out, so one broken server does not keep the service from starting.
+func connectMCP(cfg *config.Config, registry *chat.ToolRegistry) []*mcp.Client {
+	var clients []*mcp.Client
+	for _, name := range slices.Sorted(maps.Keys(cfg.MCPServers)) {
+		srv := cfg.MCPServers[name]
+		ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+		c, err := mcp.Connect(ctx, name, mcp.Server{
+			Command: srv.Command,
+			Args:    srv.Args,
+			Env:     srv.Env,
+			URL:     srv.URL,
+			Headers: srv.Headers,
+		})
+		if err == nil {
+			if err = mcp.Register(ctx, registry, c); err != nil {
+				c.Close()
+			}
+		}
+		cancel()
+		if err != nil {
+			slog.Error("Failed to connect MCP server", "server", name, "error", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// redactorFor returns the redactor for the current configuration, or nil
+// when redaction is off. It is rebuilt only when the configuration is
+// reloaded, so hashes stay stable in between.
+func redactorFor(holder *config.Holder) func() *redact.Redactor {
+	var (
+		mu       sync.Mutex
+		built    *config.Config
+		redactor *redact.Redactor
+	)
+	return func() *redact.Redactor {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cfg := holder.Current()
+		if cfg != built {
+			built, redactor = cfg, nil
+			if cfg.RedactionMode != "" {
+				// Validated when the configuration was loaded.
+				redactor, _ = redact.New(cfg.RedactionOptions())
+			}
+		}
+		return redactor
+	}
+}
+
+// moderatorFor returns the moderator for the current configuration, or nil
+// when no moderation is configured. Like the redactor, it is rebuilt only
+// when the configuration is reloaded, from rules checked by that load.
+func moderatorFor(holder *config.Holder, llmClient chat.LLMClient) func() chat.Moderator {
+	var (
+		mu        sync.Mutex
+		built     *config.Config
+		moderator chat.Moderator
+	)
+	return func() chat.Moderator {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cfg := holder.Current()
+		if cfg != built {
+			built, moderator = cfg, nil
+			var ms chat.Moderators
+			if len(cfg.ModerationRules) > 0 {
+				policy, _ := cfg.ModerationPolicy()
+				ms = append(ms, policy)
+			}
+			if len(cfg.ModerationClassifier) > 0 {
+				stages := make([]chat.ModerationStage, len(cfg.ModerationClassifier))
+				for i, st := range cfg.ModerationClassifier {
+					stages[i] = chat.ModerationStage(st)
+				}
+				ms = append(ms, moderation.NewClassifier(llmClient, stages...))
+			}
+			if len(ms) > 0 {
+				moderator = ms
+			}
+		}
+		return moderator
+	}
+}
+
+// injectionPolicy returns the prompt injection policy for the current
+// configuration, or nil when detection is off.
+func injectionPolicy(holder *config.Holder, llmClient chat.LLMClient) func() *chat.InjectionPolicy {
+	return func() *chat.InjectionPolicy {
+		cfg := holder.Current()
+		if cfg.InjectionAction == "" {
+			return nil
+		}
+		detectors := chat.InjectionDetectors{injection.Heuristics{}}
+		if cfg.InjectionClassifier {
+			detectors = append(detectors, injection.NewClassifier(llmClient))
+		}
+		return &chat.InjectionPolicy{
+			Detector:  detectors,
+			Threshold: cfg.InjectionThreshold,
+			Action:    chat.InjectionAction(cfg.InjectionAction),
+		}
+	}
+}
+
+// newHistoryManager persists history to HistoryDir when set, in memory
+// otherwise. Stored history is encrypted when encryption keys are configured.
+func newHistoryManager(cfg *config.Config) (*chat.HistoryManager, error) {
+	if cfg.HistoryDir == "" {
+		return chat.NewHistoryManager(), nil
+	}
+	keys, err := encryption.LoadKeyring(cfg.EncryptionKeys, cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var opts []chat.FileStoreOption
+	if keys != nil {
+		opts = append(opts, chat.WithEncryption(keys))
+	}
+	store, err := chat.NewFileStore(cfg.HistoryDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return chat.NewHistoryManagerWithStore(store), nil
+}
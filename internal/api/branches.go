@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"chat-service/internal/chat"
+)
+
+type conversationTree struct {
+	CurrentLeaf string         `json:"current_leaf"`
+	Messages    []chat.Message `json:"messages"`
+}
+
+// HandleRegenerate handles POST /conversations/{id}/regenerate. The body is
+// optional and may name a persona and a response format for the new answer.
+func (h *Handler) HandleRegenerate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Persona        string               `json:"persona"`
+		ResponseFormat *chat.ResponseFormat `json:"response_format"`
+	}
+	if err := decodeOptional(r, &req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	h.serveStream(w, r, true, func(ctx context.Context) (<-chan chat.Event, error) {
+		return h.chatService.Regenerate(ctx, chat.Prompt{
+			Owner:          identityFromContext(r.Context()),
+			ConversationID: r.PathValue("id"),
+			Persona:        req.Persona,
+			Format:         req.ResponseFormat,
+		})
+	})
+}
+
+// HandleEditMessage handles POST /conversations/{id}/messages/{messageID}/edit.
+func (h *Handler) HandleEditMessage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content        string               `json:"content"`
+		Persona        string               `json:"persona"`
+		ResponseFormat *chat.ResponseFormat `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Content == "" {
+		http.Error(w, "content is required", http.StatusBadRequest)
+		return
+	}
+
+	h.serveStream(w, r, true, func(ctx context.Context) (<-chan chat.Event, error) {
+		return h.chatService.EditMessage(ctx, chat.Prompt{
+			Content:        req.Content,
+			Owner:          identityFromContext(r.Context()),
+			ConversationID: r.PathValue("id"),
+			Persona:        req.Persona,
+			Format:         req.ResponseFormat,
+		}, r.PathValue("messageID"))
+	})
+}
+
+// HandleSwitchBranch handles PUT /conversations/{id}/branch.
+func (h *Handler) HandleSwitchBranch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		writeError(w, http.StatusBadRequest, "message_id is required")
+		return
+	}
+
+	c, msgs, err := h.chatService.SwitchBranch(r.Context(), identityFromContext(r.Context()), r.PathValue("id"), req.MessageID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conversationDetail{Conversation: c, Messages: msgs})
+}
+
+// HandleGetTree handles GET /conversations/{id}/tree.
+func (h *Handler) HandleGetTree(w http.ResponseWriter, r *http.Request) {
+	c, msgs, err := h.chatService.GetTree(r.Context(), identityFromContext(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conversationTree{CurrentLeaf: c.CurrentLeaf, Messages: msgs})
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"chat-service/internal/chat"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type conversationList struct {
+	Conversations []chat.Conversation `json:"conversations"`
+	Total         int                 `json:"total"`
+	NextOffset    *int                `json:"next_offset,omitempty"`
+}
+
+type conversationDetail struct {
+	chat.Conversation
+	Messages []chat.Message `json:"messages"`
+}
+
+// HandleCreateConversation handles POST /conversations.
+func (h *Handler) HandleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title    string            `json:"title"`
+		Persona  string            `json:"persona"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := decodeOptional(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	c, err := h.chatService.CreateConversation(r.Context(), identityFromContext(r.Context()), chat.Conversation{
+		Title:    req.Title,
+		Persona:  req.Persona,
+		Metadata: req.Metadata,
+	})
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// HandleListConversations handles GET /conversations?offset=&limit=.
+func (h *Handler) HandleListConversations(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+		return
+	}
+
+	page, total, err := h.chatService.ListConversations(r.Context(), identityFromContext(r.Context()), offset, limit)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	resp := conversationList{Conversations: page, Total: total}
+	if next := offset + len(page); next < total {
+		resp.NextOffset = &next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleGetConversation handles GET /conversations/{id}.
+func (h *Handler) HandleGetConversation(w http.ResponseWriter, r *http.Request) {
+	c, msgs, err := h.chatService.GetConversation(r.Context(), identityFromContext(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conversationDetail{Conversation: c, Messages: msgs})
+}
+
+// HandleUpdateConversation handles PATCH /conversations/{id}.
+func (h *Handler) HandleUpdateConversation(w http.ResponseWriter, r *http.Request) {
+	var patch chat.ConversationPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	c, err := h.chatService.UpdateConversation(r.Context(), identityFromContext(r.Context()), r.PathValue("id"), patch)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// HandleForkConversation handles POST /conversations/{id}/fork. The body is
+// optional: message_id limits the copy to the branch ending there, and
+// title, persona and metadata override the inherited values. The fork
+// belongs to the caller, or to owner when an admin sets it; only the
+// caller's own conversations can be forked either way.
+func (h *Handler) HandleForkConversation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MessageID string            `json:"message_id"`
+		Title     string            `json:"title"`
+		Persona   string            `json:"persona"`
+		Metadata  map[string]string `json:"metadata"`
+		Owner     string            `json:"owner"`
+	}
+	if err := decodeOptional(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	if req.Owner != "" && !isAdmin(r.Context()) {
+		writeError(w, http.StatusForbidden, "Only admin keys may fork for another owner")
+		return
+	}
+
+	owner := identityFromContext(r.Context())
+	fork, err := h.chatService.ForkConversation(r.Context(), owner, r.PathValue("id"), req.MessageID, chat.Conversation{
+		Owner:    req.Owner,
+		Title:    req.Title,
+		Persona:  req.Persona,
+		Metadata: req.Metadata,
+	})
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	c, msgs, err := h.chatService.GetConversation(r.Context(), fork.Owner, fork.ID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, conversationDetail{Conversation: c, Messages: msgs})
+}
+
+// HandleDeleteConversation handles DELETE /conversations/{id}.
+func (h *Handler) HandleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	if err := h.chatService.DeleteConversation(r.Context(), identityFromContext(r.Context()), r.PathValue("id")); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeConversationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, chat.ErrConversationNotFound):
+		writeError(w, http.StatusNotFound, "Conversation not found")
+	case errors.Is(err, chat.ErrMessageNotFound):
+		writeError(w, http.StatusNotFound, "Message not found")
+	case errors.Is(err, chat.ErrUnknownPersona):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Conversation storage error")
+	}
+}
+
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// decodeOptional decodes r's JSON body into v, leaving v untouched when
+// there is no body. An empty chunked body has no Content-Length, so it is
+// recognised by the decoder reaching the end before any value.
+func decodeOptional(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
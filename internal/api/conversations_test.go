@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/moderation"
+)
+
+type echoLLM struct{}
+
+func (echoLLM) StreamChat(ctx context.Context, messages []chat.Message) (<-chan chat.Chunk, error) {
+	ch := make(chan chat.Chunk, 1)
+	ch <- chat.Chunk{Content: "reply"}
+	close(ch)
+	return ch, nil
+}
+
+func TestConversationEndpoints(t *testing.T) {
+	srv := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{}))
+	do := srv.do
+
+	rr := do("alice-key", "POST", "/conversations", `{"title":"Runbooks","metadata":{"team":"ops"}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+	var created chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	rr = do("alice-key", "POST", "/chat", `{"conversation_id":"`+created.ID+`","messages":[{"role":"user","content":"hi"}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("chat: expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	rr = do("alice-key", "GET", "/conversations/"+created.ID, "")
+	var detail conversationDetail
+	json.NewDecoder(rr.Body).Decode(&detail)
+	if rr.Code != http.StatusOK || len(detail.Messages) != 2 || detail.Metadata["team"] != "ops" {
+		t.Errorf("get: expected conversation with 2 messages and metadata, got %d %+v", rr.Code, detail)
+	}
+
+	if rr = do("bob-key", "GET", "/conversations/"+created.ID, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("get by other identity: expected 404, got %d", rr.Code)
+	}
+
+	rr = do("alice-key", "PATCH", "/conversations/"+created.ID, `{"title":"Renamed"}`)
+	var patched chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || patched.Title != "Renamed" {
+		t.Errorf("patch: expected renamed conversation, got %d %+v", rr.Code, patched)
+	}
+
+	// An empty chunked body has no Content-Length.
+	req := httptest.NewRequest("POST", "/conversations", strings.NewReader(""))
+	req.ContentLength = -1
+	if rr = srv.send("alice-key", req); rr.Code != http.StatusCreated {
+		t.Errorf("create without body: expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+	rr = do("alice-key", "GET", "/conversations?limit=1", "")
+	var list conversationList
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.Total != 2 || len(list.Conversations) != 1 || list.NextOffset == nil || *list.NextOffset != 1 {
+		t.Errorf("list: expected first page of 2, got %+v", list)
+	}
+
+	rr = do("bob-key", "GET", "/conversations", "")
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.Total != 0 {
+		t.Errorf("list by other identity: expected none, got %d", list.Total)
+	}
+
+	if rr = do("alice-key", "POST", "/conversations/"+created.ID+"/fork", `{"owner":"`+identityForKey("bob-key")+`"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("fork for another owner without an admin key: expected 403, got %d", rr.Code)
+	}
+	rr = do("alice-key", "POST", "/conversations/"+created.ID+"/fork", `{"message_id":"`+detail.Messages[0].ID+`","title":"Fork"}`)
+	var fork conversationDetail
+	json.NewDecoder(rr.Body).Decode(&fork)
+	if rr.Code != http.StatusCreated || fork.Title != "Fork" || fork.Owner != identityForKey("alice-key") || len(fork.Messages) != 1 || fork.ForkedFrom == nil || fork.ForkedFrom.ConversationID != created.ID {
+		t.Errorf("fork: expected new conversation with one message and provenance, got %d %+v", rr.Code, fork)
+	}
+	req = httptest.NewRequest("POST", "/conversations/"+created.ID+"/fork", strings.NewReader(""))
+	req.ContentLength = -1
+	if rr = srv.send("alice-key", req); rr.Code != http.StatusCreated {
+		t.Errorf("fork without body: expected 201, got %d: %s", rr.Code, rr.Body)
+	}
+	if rr = do("bob-key", "POST", "/conversations/"+created.ID+"/fork", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("fork by other identity: expected 404, got %d", rr.Code)
+	}
+	if rr = do("admin-key", "POST", "/conversations/"+created.ID+"/fork", `{"owner":"`+identityForKey("bob-key")+`"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("fork of another identity's conversation by an admin: expected 404, got %d", rr.Code)
+	}
+
+	rr = do("admin-key", "POST", "/conversations", `{"title":"Onboarding"}`)
+	var shared chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&shared)
+	rr = do("admin-key", "POST", "/conversations/"+shared.ID+"/fork", `{"owner":"`+identityForKey("bob-key")+`"}`)
+	json.NewDecoder(rr.Body).Decode(&fork)
+	if rr.Code != http.StatusCreated || fork.Owner != identityForKey("bob-key") {
+		t.Errorf("fork for another owner by an admin: expected bob's conversation, got %d %+v", rr.Code, fork)
+	}
+	if rr = do("bob-key", "GET", "/conversations/"+fork.ID, ""); rr.Code != http.StatusOK {
+		t.Errorf("admin fork: expected bob to see it, got %d", rr.Code)
+	}
+
+	if rr = do("alice-key", "DELETE", "/conversations/"+created.ID, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("delete: expected 204, got %d", rr.Code)
+	}
+	if rr = do("alice-key", "GET", "/conversations/"+created.ID, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("get after delete: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestBranchEndpoints(t *testing.T) {
+	srv := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{}))
+	do := srv.do
+
+	rr := do("alice-key", "POST", "/conversations", "")
+	var c chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&c)
+
+	if rr = do("alice-key", "POST", "/conversations/"+c.ID+"/regenerate", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("regenerate empty conversation: expected 400, got %d", rr.Code)
+	}
+
+	do("alice-key", "POST", "/chat", `{"conversation_id":"`+c.ID+`","messages":[{"role":"user","content":"hi"}]}`)
+	req := httptest.NewRequest("POST", "/conversations/"+c.ID+"/regenerate", strings.NewReader(""))
+	req.ContentLength = -1
+	rr = srv.send("alice-key", req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "[DONE]") {
+		t.Fatalf("regenerate: expected stream, got %d: %s", rr.Code, rr.Body)
+	}
+
+	rr = do("alice-key", "GET", "/conversations/"+c.ID+"/tree", "")
+	var tree conversationTree
+	json.NewDecoder(rr.Body).Decode(&tree)
+	if rr.Code != http.StatusOK || len(tree.Messages) != 3 || tree.CurrentLeaf != tree.Messages[2].ID {
+		t.Fatalf("tree: expected 3 messages with the regenerated answer active, got %d %+v", rr.Code, tree)
+	}
+
+	if rr = do("alice-key", "POST", "/conversations/"+c.ID+"/messages/"+tree.Messages[1].ID+"/edit", `{"content":"x"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("edit assistant message: expected 400, got %d", rr.Code)
+	}
+	if rr = do("alice-key", "POST", "/conversations/"+c.ID+"/messages/missing/edit", `{"content":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("edit unknown message: expected 404, got %d", rr.Code)
+	}
+
+	rr = do("alice-key", "PUT", "/conversations/"+c.ID+"/branch", `{"message_id":"`+tree.Messages[1].ID+`"}`)
+	var detail conversationDetail
+	json.NewDecoder(rr.Body).Decode(&detail)
+	if rr.Code != http.StatusOK || detail.CurrentLeaf != tree.Messages[1].ID || len(detail.Messages) != 2 {
+		t.Errorf("branch: expected original answer active, got %d %+v", rr.Code, detail)
+	}
+}
+
+func TestChatRefusal(t *testing.T) {
+	policy, err := moderation.NewPolicy([]moderation.Rule{
+		{Category: "violence", Keywords: []string{"hurt"}, Stages: []chat.ModerationStage{chat.StageInput}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := chat.NewService(chat.NewHistoryManager(), echoLLM{},
+		chat.WithModerator(func() chat.Moderator { return policy }, 0))
+	rr := newTestServer(svc).do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"how to hurt someone"}]}`)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, "event: refusal\ndata: {\"stage\":\"input\",\"categories\":[\"violence\"]") {
+		t.Fatalf("Expected a refusal event, got %d: %s", rr.Code, body)
+	}
+	if strings.Contains(body, "reply") || !strings.Contains(body, "data: [DONE]") {
+		t.Errorf("Expected the stream to end without an answer, got %s", body)
+	}
+}
+
+// toolLLM asks for the echo tool once, then answers.
+type toolLLM struct{ echoLLM }
+
+func (toolLLM) StreamChatWithTools(ctx context.Context, messages []chat.Message, tools []chat.ToolDefinition) (<-chan chat.Chunk, error) {
+	if messages[len(messages)-1].Role == chat.RoleTool {
+		return echoLLM{}.StreamChat(ctx, messages)
+	}
+	ch := make(chan chat.Chunk, 1)
+	ch <- chat.Chunk{FinishReason: "tool_calls", ToolCalls: []chat.ToolCall{{ID: "call_1", Name: "echo", Arguments: `{"text":"hi"}`}}}
+	close(ch)
+	return ch, nil
+}
+
+func TestChatToolEvents(t *testing.T) {
+	tools := chat.NewToolRegistry()
+	tools.Register(chat.Tool{
+		ToolDefinition: chat.ToolDefinition{Name: "echo"},
+		Handler:        func(ctx context.Context, args json.RawMessage) (string, error) { return "hi", nil },
+	})
+	srv := newTestServer(chat.NewService(chat.NewHistoryManager(), toolLLM{}, chat.WithTools(tools, 0)))
+	rr := srv.do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"say hi"}]}`)
+
+	body := rr.Body.String()
+	running := strings.Index(body, `event: tool`+"\n"+`data: {"id":"call_1","name":"echo","arguments":"{\"text\":\"hi\"}","status":"running"}`)
+	done := strings.Index(body, `"status":"done","result":"hi"`)
+	answer := strings.Index(body, `data: {"content":"reply"}`)
+	if running < 0 || done < running || answer < done {
+		t.Fatalf("Expected tool events before the answer, got %s", body)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"chat-service/internal/chat"
+)
+
+// maxDocumentBytes bounds the size of an uploaded document.
+const maxDocumentBytes = 20 << 20
+
+// documentTypes maps file extensions to the content types documents are
+// read as, for uploads sent without a useful Content-Type.
+var documentTypes = map[string]string{
+	".txt":      "text/plain",
+	".text":     "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".html":     "text/html",
+	".htm":      "text/html",
+	".pdf":      "application/pdf",
+}
+
+type documentList struct {
+	Documents []chat.Document `json:"documents"`
+}
+
+// HandleUploadDocument handles POST /documents?title=. The body is either
+// the document itself, typed by its Content-Type, or a multipart form with
+// the document in the "file" field and an optional "title" field.
+func (h *Handler) HandleUploadDocument(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentBytes)
+	title := r.URL.Query().Get("title")
+	contentType := r.Header.Get("Content-Type")
+	var body io.Reader = r.Body
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxDocumentBytes); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid multipart body")
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, `Missing "file" field`)
+			return
+		}
+		defer file.Close()
+		body = file
+		contentType = documentType(header.Header.Get("Content-Type"), header.Filename)
+		if title == "" {
+			title = r.FormValue("title")
+		}
+		if title == "" {
+			title = header.Filename
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "Document is too large")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read document")
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, "Document is empty")
+		return
+	}
+
+	doc, err := h.chatService.AddDocument(r.Context(), identityFromContext(r.Context()), chat.Document{
+		Title:       title,
+		ContentType: contentType,
+	}, data)
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, doc)
+}
+
+// HandleListDocuments handles GET /documents.
+func (h *Handler) HandleListDocuments(w http.ResponseWriter, r *http.Request) {
+	docs, err := h.chatService.ListDocuments(r.Context())
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, documentList{Documents: docs})
+}
+
+// HandleGetDocument handles GET /documents/{id}.
+func (h *Handler) HandleGetDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.chatService.GetDocument(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// HandleDeleteDocument handles DELETE /documents/{id}.
+func (h *Handler) HandleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	if err := h.chatService.DeleteDocument(r.Context(), r.PathValue("id")); err != nil {
+		writeDocumentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// documentType picks the content type of an uploaded file, falling back to
+// its extension when the client sent none or a generic one.
+func documentType(contentType, filename string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "" && mediaType != "application/octet-stream" {
+		return contentType
+	}
+	if t, ok := documentTypes[strings.ToLower(path.Ext(filename))]; ok {
+		return t
+	}
+	return contentType
+}
+
+func writeDocumentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, chat.ErrDocumentNotFound), errors.Is(err, chat.ErrDocumentsDisabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, chat.ErrUnsupportedDocument):
+		writeError(w, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, chat.ErrUnreadableDocument):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Document storage error")
+	}
+}
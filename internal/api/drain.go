@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// streamTracker counts in-flight chat streams so shutdown can wait for them,
+// tell them the server is going away, and cancel whatever is left at the
+// deadline.
+type streamTracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+	notify   chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newStreamTracker() *streamTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamTracker{
+		notify: make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// acquire registers a new stream. It returns false once draining has begun.
+func (t *streamTracker) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *streamTracker) release() {
+	t.wg.Done()
+}
+
+// bind derives a context for a generation that is cancelled either with
+// parent or when the drain deadline forces remaining streams to stop.
+func (t *streamTracker) bind(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(t.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (t *streamTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// drain stops new streams and waits for active ones. If ctx ends first the
+// remaining streams are cancelled and drain waits for them to unwind, which
+// is prompt because cancellation aborts the upstream request.
+func (t *streamTracker) drain(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.draining {
+		t.draining = true
+		close(t.notify)
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-service/internal/chat"
+)
+
+// hangingLLM emits one chunk and then blocks until the request is cancelled,
+// like a long generation that outlives the drain deadline.
+type hangingLLM struct{}
+
+func (hangingLLM) StreamChat(ctx context.Context, messages []chat.Message) (<-chan chat.Chunk, error) {
+	ch := make(chan chat.Chunk)
+	go func() {
+		defer close(ch)
+		ch <- chat.Chunk{Content: "partial"}
+		<-ctx.Done()
+	}()
+	return ch, nil
+}
+
+func TestDrainInterruptsStreams(t *testing.T) {
+	history := chat.NewHistoryManager()
+	h := NewHandler(chat.NewService(history, hangingLLM{}))
+	server := httptest.NewServer(http.HandlerFunc(h.HandleChat))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json",
+		strings.NewReader(`{"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	// Wait for the partial chunk so the stream is known to be in flight.
+	for line := range lines {
+		if strings.Contains(line, "partial") {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := h.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected drain to hit its deadline, got %v", err)
+	}
+
+	var sawShutdown, sawDone bool
+	for line := range lines {
+		sawShutdown = sawShutdown || line == "event: shutdown"
+		sawDone = sawDone || line == "data: [DONE]"
+	}
+	if !sawShutdown {
+		t.Error("expected a shutdown event on the active stream")
+	}
+	if !sawDone {
+		t.Error("expected the stream to be terminated cleanly")
+	}
+
+	msgs, _ := history.GetAll(context.Background(), chat.DefaultConversationID(anonymousIdentity))
+	if len(msgs) != 2 {
+		t.Fatalf("expected user and partial assistant message, got %d", len(msgs))
+	}
+	if !msgs[1].Interrupted || msgs[1].Content != "partial" {
+		t.Errorf("expected interrupted partial response, got %+v", msgs[1])
+	}
+
+	rr := httptest.NewRecorder()
+	h.HandleChat(rr, httptest.NewRequest("POST", "/chat",
+		strings.NewReader(`{"messages":[{"role":"user","content":"again"}]}`)))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new chats to be rejected while draining, got %d", rr.Code)
+	}
+}
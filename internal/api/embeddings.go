@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+
+	"chat-service/internal/chat"
+)
+
+// Limits on an embeddings request: body size, and how many texts it may
+// hold, which matches OpenAI's.
+const (
+	maxEmbeddingBytes  = 8 << 20
+	maxEmbeddingInputs = 2048
+)
+
+// embeddingRequest follows OpenAI's embeddings API. Input is a string or
+// an array of strings; token arrays are not accepted.
+type embeddingRequest struct {
+	Input          json.RawMessage `json:"input"`
+	Model          string          `json:"model"`
+	EncodingFormat string          `json:"encoding_format"`
+}
+
+type embeddingData struct {
+	Object string `json:"object"`
+	Index  int    `json:"index"`
+	// Embedding is a []float32, or a base64 string of little-endian
+	// float32s when requested.
+	Embedding any `json:"embedding"`
+}
+
+type embeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []embeddingData `json:"data"`
+	Model  string          `json:"model"`
+}
+
+// HandleEmbeddings handles POST /v1/embeddings, an OpenAI-compatible
+// endpoint for the configured embedding model.
+func (h *Handler) HandleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req embeddingRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEmbeddingBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	model := h.chatService.EmbeddingModel()
+	if model == "" {
+		writeError(w, http.StatusNotFound, "Embeddings are not enabled")
+		return
+	}
+	if req.Model != "" && req.Model != model {
+		writeError(w, http.StatusBadRequest, "Unknown model, use "+model)
+		return
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		writeError(w, http.StatusBadRequest, `encoding_format must be "float" or "base64"`)
+		return
+	}
+	texts, ok := embeddingInputs(req.Input)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "input must be a non-empty string or an array of them")
+		return
+	}
+	if len(texts) > maxEmbeddingInputs {
+		writeError(w, http.StatusBadRequest, "input has too many texts")
+		return
+	}
+
+	vecs, err := h.chatService.Embed(r.Context(), texts)
+	if errors.Is(err, chat.ErrEmbeddingsDisabled) {
+		writeError(w, http.StatusNotFound, "Embeddings are not enabled")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Embedding request failed", "error", err)
+		writeError(w, http.StatusBadGateway, "Embedding provider error")
+		return
+	}
+
+	resp := embeddingResponse{Object: "list", Data: make([]embeddingData, len(vecs)), Model: model}
+	for i, vec := range vecs {
+		resp.Data[i] = embeddingData{Object: "embedding", Index: i, Embedding: vec}
+		if req.EncodingFormat == "base64" {
+			resp.Data[i].Embedding = encodeVector(vec)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// embeddingInputs reads input as one string or an array of strings, none
+// of them empty.
+func embeddingInputs(input json.RawMessage) ([]string, bool) {
+	var texts []string
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		texts = []string{text}
+	} else if err := json.Unmarshal(input, &texts); err != nil {
+		return nil, false
+	}
+	if len(texts) == 0 {
+		return nil, false
+	}
+	for _, t := range texts {
+		if t == "" {
+			return nil, false
+		}
+	}
+	return texts, true
+}
+
+func encodeVector(vec []float32) string {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
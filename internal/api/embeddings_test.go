@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/llm"
+)
+
+func TestEmbeddingsEndpoint(t *testing.T) {
+	embedder := llm.NewHashEmbedder(8)
+	enabled := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{}, chat.WithEmbedder(embedder)))
+
+	post := func(router http.Handler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer alice-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post(enabled, `{"model":"hash-8","input":["first text","second text"]}`)
+	var resp struct {
+		Object string `json:"object"`
+		Model  string `json:"model"`
+		Data   []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Object != "list" || resp.Model != "hash-8" || len(resp.Data) != 2 {
+		t.Fatalf("Expected two embeddings, got %d %s", rr.Code, rr.Body)
+	}
+	want, _ := embedder.Embed(t.Context(), []string{"second text"})
+	if resp.Data[1].Index != 1 || len(resp.Data[1].Embedding) != 8 || resp.Data[1].Embedding[0] != want[0][0] {
+		t.Errorf("Expected the second text's vector at index 1, got %+v", resp.Data[1])
+	}
+
+	rr = post(enabled, `{"input":"second text","encoding_format":"base64"}`)
+	var encoded struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &encoded)
+	raw, err := base64.StdEncoding.DecodeString(encoded.Data[0].Embedding)
+	if rr.Code != http.StatusOK || err != nil || len(raw) != 32 || math.Float32frombits(binary.LittleEndian.Uint32(raw)) != want[0][0] {
+		t.Errorf("Expected a base64 vector of 8 float32s, got %d %s", rr.Code, rr.Body)
+	}
+
+	for _, body := range []string{
+		`{"input":""}`,
+		`{"input":[]}`,
+		`{"input":[[1,2,3]]}`,
+		`{"input":"x","model":"text-embedding-3-small"}`,
+		`{"input":"x","encoding_format":"int8"}`,
+	} {
+		if rr := post(enabled, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+
+	disabled := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{}))
+	if rr := post(disabled, `{"input":"x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an embedder, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// HandleErase handles DELETE /me/data: it deletes every conversation and
+// message of the caller and returns a receipt describing what was removed.
+func (h *Handler) HandleErase(w http.ResponseWriter, r *http.Request) {
+	receipt, err := h.chatService.EraseIdentity(r.Context(), identityFromContext(r.Context()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Erasure failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "Erasure failed")
+		return
+	}
+	slog.InfoContext(r.Context(), "Erased identity data",
+		"receipt_id", receipt.ID,
+		"identity", receipt.Identity,
+		"conversations", receipt.Conversations,
+		"messages", receipt.Messages,
+		"anonymised_documents", len(receipt.AnonymisedDocuments),
+		"anonymised_templates", len(receipt.AnonymisedTemplates),
+	)
+	writeJSON(w, http.StatusOK, receipt)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/rag"
+	"chat-service/internal/templates"
+)
+
+func TestEraseEndpoint(t *testing.T) {
+	index, err := rag.Open(context.Background(), rag.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	library, err := templates.Open("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{}, chat.WithDocuments(index, 0), chat.WithTemplates(library)))
+	do := srv.do
+
+	do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"hi"}]}`)
+	do("bob-key", "POST", "/chat", `{"messages":[{"role":"user","content":"hi"}]}`)
+
+	rr := do("alice-key", "DELETE", "/me/data", "")
+	var receipt chat.ErasureReceipt
+	json.NewDecoder(rr.Body).Decode(&receipt)
+	if rr.Code != http.StatusOK || receipt.ID == "" || receipt.Conversations != 1 || receipt.Messages != 2 {
+		t.Fatalf("erase: expected receipt for 1 conversation and 2 messages, got %d %+v", rr.Code, receipt)
+	}
+
+	rr = do("alice-key", "GET", "/conversations", "")
+	if !strings.Contains(rr.Body.String(), `"total":0`) {
+		t.Errorf("after erase: expected no conversations, got %s", rr.Body)
+	}
+	rr = do("bob-key", "GET", "/conversations", "")
+	if !strings.Contains(rr.Body.String(), `"total":1`) {
+		t.Errorf("other identity: expected conversation kept, got %s", rr.Body)
+	}
+
+	req := httptest.NewRequest("POST", "/documents", strings.NewReader("Promote the replica when the primary fails."))
+	req.Header.Set("Content-Type", "text/plain")
+	rr = srv.send("admin-key", req)
+	var doc chat.Document
+	json.NewDecoder(rr.Body).Decode(&doc)
+	do("admin-key", "PUT", "/templates/review", `{"text":"Review this."}`)
+	rr = do("admin-key", "DELETE", "/me/data", "")
+	receipt = chat.ErasureReceipt{}
+	json.NewDecoder(rr.Body).Decode(&receipt)
+	if len(receipt.AnonymisedDocuments) != 1 || receipt.AnonymisedDocuments[0] != doc.ID || len(receipt.AnonymisedTemplates) != 1 {
+		t.Fatalf("admin erase: expected the uploaded document and template anonymised, got %d %+v", rr.Code, receipt)
+	}
+	rr = do("bob-key", "GET", "/documents/"+doc.ID, "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "uploaded_by") {
+		t.Errorf("after erase: expected the document kept without its uploader, got %d %s", rr.Code, rr.Body)
+	}
+	rr = do("bob-key", "GET", "/templates/review", "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "created_by") {
+		t.Errorf("after erase: expected the template kept without its author, got %d %s", rr.Code, rr.Body)
+	}
+}
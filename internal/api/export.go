@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"chat-service/internal/chat"
+)
+
+// maxImportBytes bounds the size of an imported transcript.
+const maxImportBytes = 10 << 20
+
+// HandleExportConversation handles GET /conversations/{id}/export?format=.
+func (h *Handler) HandleExportConversation(w http.ResponseWriter, r *http.Request) {
+	format, err := chat.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t, err := h.chatService.ExportConversation(r.Context(), identityFromContext(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeTranscripts(w, r, format, t.Conversation.ID, t)
+}
+
+// HandleExportConversations handles GET /conversations/export?format= and
+// exports every conversation of the caller.
+func (h *Handler) HandleExportConversations(w http.ResponseWriter, r *http.Request) {
+	format, err := chat.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ts, err := h.chatService.ExportConversations(r.Context(), identityFromContext(r.Context()))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeTranscripts(w, r, format, "conversations", ts...)
+}
+
+// HandleImportConversation handles POST /conversations/import. The body is
+// a single conversation in the JSON export format.
+func (h *Handler) HandleImportConversation(w http.ResponseWriter, r *http.Request) {
+	var t chat.Transcript
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&t); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	c, err := h.chatService.ImportConversation(r.Context(), identityFromContext(r.Context()), t)
+	if errors.Is(err, chat.ErrInvalidTranscript) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func writeTranscripts(w http.ResponseWriter, r *http.Request, format chat.Format, name string, ts ...chat.Transcript) {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+format.Extension()))
+	if err := chat.WriteTranscripts(w, format, ts...); err != nil {
+		// Headers are gone by now; all we can do is log.
+		slog.ErrorContext(r.Context(), "Failed to write export", "error", err)
+	}
+}
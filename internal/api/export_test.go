@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+func TestExportImportEndpoints(t *testing.T) {
+	do := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{})).do
+
+	rr := do("alice-key", "POST", "/conversations", `{"title":"Notes"}`)
+	var c chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&c)
+	do("alice-key", "POST", "/chat", `{"conversation_id":"`+c.ID+`","messages":[{"role":"user","content":"hi"}]}`)
+
+	rr = do("alice-key", "GET", "/conversations/"+c.ID+"/export?format=markdown", "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/markdown") || !strings.Contains(rr.Body.String(), "# Notes") {
+		t.Errorf("markdown export: got %d %q\n%s", rr.Code, rr.Header().Get("Content-Type"), rr.Body)
+	}
+	if rr = do("alice-key", "GET", "/conversations/"+c.ID+"/export?format=pdf", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: expected 400, got %d", rr.Code)
+	}
+	if rr = do("bob-key", "GET", "/conversations/"+c.ID+"/export", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("export by other identity: expected 404, got %d", rr.Code)
+	}
+
+	rr = do("alice-key", "GET", "/conversations/export?format=jsonl", "")
+	if lines := strings.Count(rr.Body.String(), "\n"); rr.Code != http.StatusOK || lines != 1 {
+		t.Errorf("jsonl export: expected one example, got %d with %d lines", rr.Code, lines)
+	}
+
+	rr = do("alice-key", "GET", "/conversations/"+c.ID+"/export", "")
+	export := rr.Body.String()
+	rr = do("bob-key", "POST", "/conversations/import", export)
+	var imported chat.Conversation
+	json.NewDecoder(rr.Body).Decode(&imported)
+	if rr.Code != http.StatusCreated || imported.Owner == c.Owner || imported.Title != "Notes" || imported.MessageCount != 2 {
+		t.Fatalf("import: expected copy for bob, got %d %+v", rr.Code, imported)
+	}
+	if rr = do("bob-key", "GET", "/conversations/"+imported.ID, ""); rr.Code != http.StatusOK {
+		t.Errorf("imported conversation: expected 200, got %d", rr.Code)
+	}
+
+	if rr = do("bob-key", "POST", "/conversations/import", `{"version":99}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid transcript: expected 400, got %d", rr.Code)
+	}
+	if rr = do("bob-key", "POST", "/conversations/import", "not json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: expected 400, got %d", rr.Code)
+	}
+}
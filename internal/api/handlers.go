@@ -1,19 +1,34 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"chat-service/internal/chat"
+	"chat-service/internal/requestid"
 )
 
 type Handler struct {
 	chatService *chat.Service
+	checks      []HealthCheck
+	streams     *streamTracker
 }
 
-func NewHandler(s *chat.Service) *Handler {
-	return &Handler{chatService: s}
+func NewHandler(s *chat.Service, checks ...HealthCheck) *Handler {
+	return &Handler{chatService: s, checks: checks, streams: newStreamTracker()}
+}
+
+// Drain stops accepting new chats, sends a shutdown event to every active
+// stream and waits for them to finish. Streams still running when ctx ends
+// are cancelled; their partial responses are kept in history flagged as
+// interrupted. Drain returns ctx.Err() in that case.
+func (h *Handler) Drain(ctx context.Context) error {
+	return h.streams.drain(ctx)
 }
 
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
@@ -43,18 +58,88 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if lastUserContent == "" {
+	if lastUserContent == "" && req.Template == nil {
 		http.Error(w, "No user message found", http.StatusBadRequest)
 		return
 	}
 
-	streamChan, err := h.chatService.ProcessMessage(lastUserContent)
-	if err != nil {
+	stream := req.Stream == nil || *req.Stream
+	h.serveStream(w, r, stream, func(ctx context.Context) (<-chan chat.Event, error) {
+		return h.chatService.ProcessMessage(ctx, chat.Prompt{
+			Content:        lastUserContent,
+			Owner:          identityFromContext(r.Context()),
+			ConversationID: req.ConversationID,
+			Persona:        req.Persona,
+			Format:         req.ResponseFormat,
+			Template:       req.Template,
+		})
+	})
+}
+
+// chatResponse is the body of a chat answered without streaming.
+type chatResponse struct {
+	RequestID string `json:"request_id"`
+	Content   string `json:"content"`
+	// Output is the parsed answer to a request with a response format,
+	// when it matched; OutputErrors lists the problems when it did not.
+	Output       json.RawMessage     `json:"output,omitempty"`
+	OutputErrors []string            `json:"output_errors,omitempty"`
+	Citations    []chat.Citation     `json:"citations,omitempty"`
+	Tools        []chat.ToolActivity `json:"tools,omitempty"`
+	Refusal      *chat.Refusal       `json:"refusal,omitempty"`
+}
+
+// serveStream runs start under the drain tracker and relays the resulting
+// stream to the client as SSE, or as one JSON response when stream is
+// false.
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request, stream bool, start func(ctx context.Context) (<-chan chat.Event, error)) {
+	if !h.streams.acquire() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.streams.release()
+
+	ctx, cancel := h.streams.bind(r.Context())
+	defer cancel()
+
+	streamChan, err := start(ctx)
+	var refused *chat.RefusalError
+	switch {
+	case errors.As(err, &refused):
+		// Refusals are reported in-stream so clients handle them the same
+		// way whether the prompt or the answer was refused.
+		refusal := make(chan chat.Event, 1)
+		refusal <- chat.Event{Refusal: &refused.Refusal}
+		close(refusal)
+		streamChan = refusal
+	case errors.Is(err, chat.ErrUnknownPersona),
+		errors.Is(err, chat.ErrNothingToRegenerate),
+		errors.Is(err, chat.ErrNotEditable),
+		errors.Is(err, chat.ErrInvalidResponseFormat),
+		errors.Is(err, chat.ErrInvalidVariables):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, chat.ErrTemplateNotFound), errors.Is(err, chat.ErrTemplatesDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, chat.ErrConversationNotFound):
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	case errors.Is(err, chat.ErrMessageNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Chat request failed", "error", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadGateway)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
+	if !stream {
+		writeJSON(w, http.StatusOK, collect(r.Context(), streamChan))
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -66,24 +151,104 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for token := range streamChan {
-		data, _ := json.Marshal(map[string]string{"content": token})
-		fmt.Fprintf(w, "data: %s\n\n", data)
-		flusher.Flush()
+	// The first frame carries the request ID so clients can quote it in bug
+	// reports even when they cannot read response headers.
+	meta, _ := json.Marshal(map[string]string{"request_id": requestid.FromContext(r.Context())})
+	fmt.Fprintf(w, "data: %s\n\n", meta)
+	flusher.Flush()
+
+	notify := h.streams.notify
+	for {
+		select {
+		case event, ok := <-streamChan:
+			if !ok {
+				fmt.Fprintf(w, "data: [DONE]\n\n")
+				flusher.Flush()
+				return
+			}
+			if event.Refusal != nil {
+				data, _ := json.Marshal(event.Refusal)
+				fmt.Fprintf(w, "event: refusal\ndata: %s\n\n", data)
+				flusher.Flush()
+				continue
+			}
+			if event.Tool != nil {
+				data, _ := json.Marshal(event.Tool)
+				fmt.Fprintf(w, "event: tool\ndata: %s\n\n", data)
+				flusher.Flush()
+				continue
+			}
+			if event.Citations != nil {
+				data, _ := json.Marshal(event.Citations)
+				fmt.Fprintf(w, "event: citations\ndata: %s\n\n", data)
+				flusher.Flush()
+				continue
+			}
+			if event.Output != nil {
+				data, _ := json.Marshal(event.Output)
+				fmt.Fprintf(w, "event: output\ndata: %s\n\n", data)
+				flusher.Flush()
+				continue
+			}
+			data, _ := json.Marshal(map[string]string{"content": event.Content})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-notify:
+			// Sent once; the stream keeps going until it completes or the
+			// drain deadline cancels it.
+			notify = nil
+			fmt.Fprintf(w, "event: shutdown\ndata: {\"message\":\"server is shutting down\"}\n\n")
+			flusher.Flush()
+		}
 	}
+}
 
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+// collect gathers a stream into a single response. Tool calls are listed
+// once, as they finished.
+func collect(ctx context.Context, events <-chan chat.Event) chatResponse {
+	resp := chatResponse{RequestID: requestid.FromContext(ctx)}
+	var content strings.Builder
+	for event := range events {
+		switch {
+		case event.Refusal != nil:
+			resp.Refusal = event.Refusal
+		case event.Tool != nil:
+			if event.Tool.Status != "running" {
+				resp.Tools = append(resp.Tools, *event.Tool)
+			}
+		case event.Citations != nil:
+			resp.Citations = event.Citations
+		case event.Output != nil:
+			if event.Output.Valid {
+				resp.Output = event.Output.Value
+			} else {
+				resp.OutputErrors = event.Output.Errors
+			}
+		default:
+			content.WriteString(event.Content)
+		}
+	}
+	resp.Content = content.String()
+	return resp
 }
 
-// just for tracking history
+// HandleHistory returns the messages of a conversation, the caller's
+// default conversation unless ?conversation_id= is given.
 func (h *Handler) HandleHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	history := h.chatService.GetHistory()
+	history, err := h.chatService.GetHistory(r.Context(), identityFromContext(r.Context()), r.URL.Query().Get("conversation_id"))
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(history); err != nil {
@@ -99,3 +264,13 @@ func (h *Handler) HandleWeb(w http.ResponseWriter, r *http.Request) {
 
 	http.ServeFile(w, r, "web/index.html")
 }
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+type jsonLLM struct{}
+
+func (jsonLLM) StreamChat(ctx context.Context, messages []chat.Message) (<-chan chat.Chunk, error) {
+	ch := make(chan chat.Chunk, 2)
+	ch <- chat.Chunk{Content: `{"answer": `}
+	ch <- chat.Chunk{Content: `42}`}
+	close(ch)
+	return ch, nil
+}
+
+func TestChatResponseFormat(t *testing.T) {
+	srv := newTestServer(chat.NewService(chat.NewHistoryManager(), jsonLLM{}))
+	format := `"response_format":{"type":"json_schema","json_schema":{"name":"answer","schema":{"type":"object","required":["answer"]}}}`
+
+	rr := srv.do("alice-key", "POST", "/chat", `{"stream":false,"messages":[{"role":"user","content":"6 times 7?"}],`+format+`}`)
+	var resp chatResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON response, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if string(resp.Output) != `{"answer":42}` || resp.Content != `{"answer": 42}` || len(resp.OutputErrors) != 0 {
+		t.Errorf("Expected the parsed output, got %+v", resp)
+	}
+
+	rr = srv.do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"6 times 7?"}],`+format+`}`)
+	if body := rr.Body.String(); !strings.Contains(body, "event: output\ndata: {\"valid\":true,\"value\":{\"answer\":42},\"attempts\":1}") {
+		t.Errorf("Expected an output event in the stream, got %s", body)
+	}
+
+	rr = srv.do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"yaml"}}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", rr.Code)
+	}
+}
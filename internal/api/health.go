@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds each readiness check so one slow dependency cannot
+// hold the probe past the kubelet's timeout.
+const checkTimeout = 2 * time.Second
+
+// HealthCheck is a named dependency probed by /readyz.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type readinessReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+// HandleLive reports that the process is up. It never checks dependencies,
+// so a broken upstream does not get the pod restarted.
+func (h *Handler) HandleLive(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// HandleReady runs every registered check concurrently and returns a JSON
+// report, with 503 if any check failed.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := readinessReport{Status: "ok", Checks: make([]checkResult, len(h.checks))}
+
+	var wg sync.WaitGroup
+	for i, hc := range h.checks {
+		wg.Add(1)
+		go func(i int, hc HealthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.Check(ctx)
+			result := checkResult{
+				Name:       hc.Name,
+				Status:     "ok",
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			report.Checks[i] = result
+		}(i, hc)
+	}
+	wg.Wait()
+
+	if h.streams.isDraining() {
+		report.Checks = append(report.Checks, checkResult{Name: "shutdown", Status: "fail", Error: "server is draining"})
+	}
+
+	status := http.StatusOK
+	for _, c := range report.Checks {
+		if c.Status != "ok" {
+			report.Status = "fail"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
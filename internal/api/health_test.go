@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleReady(t *testing.T) {
+	ok := HealthCheck{Name: "config", Check: func(context.Context) error { return nil }}
+	broken := HealthCheck{Name: "upstream", Check: func(context.Context) error { return errors.New("status 401") }}
+
+	tests := []struct {
+		name           string
+		checks         []HealthCheck
+		expectedStatus int
+		expectedReport string
+	}{
+		{name: "All Passing", checks: []HealthCheck{ok}, expectedStatus: http.StatusOK, expectedReport: "ok"},
+		{name: "One Failing", checks: []HealthCheck{ok, broken}, expectedStatus: http.StatusServiceUnavailable, expectedReport: "fail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, tt.checks...)
+			rr := httptest.NewRecorder()
+			h.HandleReady(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+
+			var report readinessReport
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if report.Status != tt.expectedReport {
+				t.Errorf("expected report status %q, got %q", tt.expectedReport, report.Status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("expected %d checks, got %d", len(tt.checks), len(report.Checks))
+			}
+			for i, c := range report.Checks {
+				if c.Name != tt.checks[i].Name {
+					t.Errorf("expected check %q at %d, got %q", tt.checks[i].Name, i, c.Name)
+				}
+			}
+		})
+	}
+}
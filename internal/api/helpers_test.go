@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/config"
+)
+
+// testServer routes requests to a service the way the server does. It
+// accepts the client keys alice-key and bob-key and the admin key
+// admin-key, with rate limits no test reaches.
+type testServer struct {
+	http.Handler
+}
+
+func newTestServer(svc *chat.Service) testServer {
+	cfg := &config.Config{
+		APIKeys:        []string{"alice-key", "bob-key"},
+		AdminKeys:      []string{"admin-key"},
+		RateLimitRPS:   100,
+		RateLimitBurst: 100,
+	}
+	return testServer{NewRouter(NewHandler(svc), cfg)}
+}
+
+// do sends a request with body, authenticated with key.
+func (s testServer) do(key, method, path, body string) *httptest.ResponseRecorder {
+	return s.send(key, httptest.NewRequest(method, path, strings.NewReader(body)))
+}
+
+// send serves req, authenticated with key.
+func (s testServer) send(key string, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("X-API-Key", key)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr
+}
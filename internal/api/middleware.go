@@ -1,6 +1,10 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"log/slog"
 	"net"
 	"net/http"
@@ -9,10 +13,19 @@ import (
 	"time"
 
 	"chat-service/internal/config"
+	"chat-service/internal/requestid"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
+var tracer = otel.Tracer("chat-service/internal/api")
+
 type responseWriter struct {
 	http.ResponseWriter
 	status       int
@@ -44,7 +57,7 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(rw, r)
 
-		slog.Info("Processed request",
+		slog.InfoContext(r.Context(), "Processed request",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", rw.status,
@@ -54,11 +67,51 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// RequestIDMiddleware accepts a well-formed X-Request-ID from the caller or
+// generates one, stores it in the request context and echoes it back.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// TracingMiddleware starts a server span for each request, continuing any
+// trace context supplied by the caller in the traceparent header.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				attribute.String("http.request.id", requestid.FromContext(r.Context())),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.status))
+		if rw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.status))
+		}
+	})
+}
+
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, "+requestid.Header)
+		w.Header().Set("Access-Control-Expose-Headers", requestid.Header)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -69,10 +122,48 @@ func CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// anonymousIdentity owns conversations when authentication is disabled.
+const anonymousIdentity = "anonymous"
+
+type (
+	identityKey struct{}
+	adminKey    struct{}
+)
+
+// identityFromContext returns the caller identity set by AuthMiddleware.
+func identityFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(string); ok {
+		return id
+	}
+	return anonymousIdentity
+}
+
+// isAdmin reports whether the caller authenticated with an admin key.
+// Without authentication every caller is an admin.
+func isAdmin(ctx context.Context) bool {
+	if _, ok := ctx.Value(identityKey{}).(string); !ok {
+		return true
+	}
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// identityForKey derives a stable, non-secret identity from an API key so
+// conversations can be scoped per key without storing the key itself.
+func identityForKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key-" + hex.EncodeToString(sum[:6])
+}
+
+// AuthMiddleware checks the caller's key against the keys in the current
+// configuration, so rotated keys take effect on reload. The caller identity
+// derived from the key is stored in the request context.
+func AuthMiddleware(p config.Provider) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cfg.APIKey == "" {
+			cfg := p.Current()
+			keys := cfg.ClientKeys()
+			if len(keys) == 0 {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -85,17 +176,45 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				}
 			}
 
-			if apiKey != cfg.APIKey {
+			if !validKey(apiKey, keys) {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), identityKey{}, identityForKey(apiKey))
+			ctx = context.WithValue(ctx, adminKey{}, validKey(apiKey, cfg.AdminKeys))
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func RateLimitMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// AdminMiddleware only lets callers with an admin key through; it runs
+// after AuthMiddleware.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r.Context()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validKey(apiKey string, keys []string) bool {
+	if apiKey == "" {
+		return false
+	}
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(k)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware limits requests per client IP. Limiters pick up new
+// rate and burst values from the current configuration on their next use.
+func RateLimitMiddleware(p config.Provider) func(http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
 		lastSeen time.Time
@@ -125,12 +244,19 @@ func RateLimitMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				ip = r.RemoteAddr
 			}
 
+			cfg := p.Current()
+			limit := rate.Limit(cfg.RateLimitRPS)
+
 			mu.Lock()
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(cfg.RateLimitRPS), cfg.RateLimitBurst),
+					limiter: rate.NewLimiter(limit, cfg.RateLimitBurst),
 				}
 			}
+			if l := clients[ip].limiter; l.Limit() != limit || l.Burst() != cfg.RateLimitBurst {
+				l.SetLimit(limit)
+				l.SetBurst(cfg.RateLimitBurst)
+			}
 			clients[ip].lastSeen = time.Now()
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
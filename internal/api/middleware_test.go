@@ -6,6 +6,14 @@ import (
 	"testing"
 
 	"chat-service/internal/config"
+	"chat-service/internal/requestid"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestAuthMiddleware(t *testing.T) {
@@ -61,6 +69,32 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestAdminMiddleware(t *testing.T) {
+	cfg := &config.Config{APIKey: "secret", AdminKeys: []string{"root"}}
+	handler := AuthMiddleware(cfg)(AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for key, want := range map[string]int{"secret": http.StatusForbidden, "root": http.StatusOK, "wrong": http.StatusUnauthorized} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Expected %d for key %q, got %d", want, key, rr.Code)
+		}
+	}
+
+	open := AuthMiddleware(&config.Config{})(AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	rr := httptest.NewRecorder()
+	open.ServeHTTP(rr, httptest.NewRequest("POST", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected every caller to be an admin without authentication, got %d", rr.Code)
+	}
+}
+
 func TestRateLimitMiddleware(t *testing.T) {
 	cfg := &config.Config{
 		RateLimitRPS:   10,
@@ -109,3 +143,80 @@ func TestRateLimitMiddleware(t *testing.T) {
 		t.Errorf("Rate limiter did not block excessive requests")
 	}
 }
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var handlerSpan trace.SpanContext
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest("POST", "/chat", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "POST /chat" {
+		t.Errorf("expected span name 'POST /chat', got '%s'", span.Name())
+	}
+	if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected incoming trace id to be continued, got %s", got)
+	}
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected remote parent span, got %s", span.Parent().SpanID())
+	}
+	if handlerSpan.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("handler context does not carry the server span")
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("expected error status for 502 response, got %v", span.Status().Code)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestid.FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "Generated", incoming: "", keep: false},
+		{name: "Accepted", incoming: "client-req-42", keep: true},
+		{name: "Invalid Replaced", incoming: "bad id", keep: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestid.Header, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			echoed := rr.Header().Get(requestid.Header)
+			if echoed == "" || echoed != seen {
+				t.Fatalf("expected echoed id to match context id, got %q and %q", echoed, seen)
+			}
+			if tt.keep && echoed != tt.incoming {
+				t.Errorf("expected id %q to be kept, got %q", tt.incoming, echoed)
+			}
+			if !tt.keep && echoed == tt.incoming {
+				t.Errorf("expected a generated id, got %q", echoed)
+			}
+		})
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"chat-service/internal/config"
 )
 
-func NewRouter(h *Handler, cfg *config.Config) http.Handler {
+func NewRouter(h *Handler, cfg config.Provider) http.Handler {
 	mux := http.NewServeMux()
 
 	authMw := AuthMiddleware(cfg)
@@ -14,16 +14,53 @@ func NewRouter(h *Handler, cfg *config.Config) http.Handler {
 	chain := func(h http.Handler) http.Handler {
 		return rateMw(authMw(h))
 	}
+	admin := func(h http.Handler) http.Handler {
+		return chain(AdminMiddleware(h))
+	}
 
 	mux.HandleFunc("/health", h.HandleHealth)
+	mux.HandleFunc("/livez", h.HandleLive)
+	mux.HandleFunc("/readyz", h.HandleReady)
 
 	mux.Handle("/chat", chain(http.HandlerFunc(h.HandleChat)))
 	mux.Handle("/history", chain(http.HandlerFunc(h.HandleHistory)))
 
+	mux.Handle("POST /conversations", chain(http.HandlerFunc(h.HandleCreateConversation)))
+	mux.Handle("GET /conversations", chain(http.HandlerFunc(h.HandleListConversations)))
+	mux.Handle("GET /conversations/export", chain(http.HandlerFunc(h.HandleExportConversations)))
+	mux.Handle("POST /conversations/import", chain(http.HandlerFunc(h.HandleImportConversation)))
+	mux.Handle("GET /conversations/{id}", chain(http.HandlerFunc(h.HandleGetConversation)))
+	mux.Handle("PATCH /conversations/{id}", chain(http.HandlerFunc(h.HandleUpdateConversation)))
+	mux.Handle("DELETE /conversations/{id}", chain(http.HandlerFunc(h.HandleDeleteConversation)))
+	mux.Handle("GET /conversations/{id}/export", chain(http.HandlerFunc(h.HandleExportConversation)))
+	mux.Handle("POST /conversations/{id}/fork", chain(http.HandlerFunc(h.HandleForkConversation)))
+	mux.Handle("POST /conversations/{id}/regenerate", chain(http.HandlerFunc(h.HandleRegenerate)))
+	mux.Handle("POST /conversations/{id}/messages/{messageID}/edit", chain(http.HandlerFunc(h.HandleEditMessage)))
+	mux.Handle("PUT /conversations/{id}/branch", chain(http.HandlerFunc(h.HandleSwitchBranch)))
+	mux.Handle("GET /conversations/{id}/tree", chain(http.HandlerFunc(h.HandleGetTree)))
+
+	mux.Handle("POST /documents", admin(http.HandlerFunc(h.HandleUploadDocument)))
+	mux.Handle("GET /documents", chain(http.HandlerFunc(h.HandleListDocuments)))
+	mux.Handle("GET /documents/{id}", chain(http.HandlerFunc(h.HandleGetDocument)))
+	mux.Handle("DELETE /documents/{id}", admin(http.HandlerFunc(h.HandleDeleteDocument)))
+
+	mux.Handle("GET /templates", chain(http.HandlerFunc(h.HandleListTemplates)))
+	mux.Handle("GET /templates/{name}", chain(http.HandlerFunc(h.HandleGetTemplate)))
+	mux.Handle("GET /templates/{name}/versions", chain(http.HandlerFunc(h.HandleTemplateVersions)))
+	mux.Handle("PUT /templates/{name}", admin(http.HandlerFunc(h.HandleSaveTemplate)))
+	mux.Handle("DELETE /templates/{name}", admin(http.HandlerFunc(h.HandleDeleteTemplate)))
+
+	mux.Handle("POST /v1/embeddings", chain(http.HandlerFunc(h.HandleEmbeddings)))
+
+	mux.Handle("GET /search", chain(http.HandlerFunc(h.HandleSearch)))
+	mux.Handle("DELETE /me/data", chain(http.HandlerFunc(h.HandleErase)))
+
 	mux.HandleFunc("/web", h.HandleWeb)
 
 	handler := CORSMiddleware(mux)
+	handler = TracingMiddleware(handler)
 	handler = LoggerMiddleware(handler)
+	handler = RequestIDMiddleware(handler)
 
 	return handler
 }
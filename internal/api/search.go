@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"chat-service/internal/chat"
+)
+
+type searchResults struct {
+	Query   string           `json:"query"`
+	Results []chat.SearchHit `json:"results"`
+}
+
+// HandleSearch handles GET /search?q=&role=&from=&to=&conversation_id=&limit=.
+// Only the caller's conversations are searched.
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := chat.SearchQuery{
+		Text:           query.Get("q"),
+		Role:           chat.Role(query.Get("role")),
+		ConversationID: query.Get("conversation_id"),
+	}
+	switch q.Role {
+	case "", chat.RoleUser, chat.RoleAssistant, chat.RoleSystem:
+	default:
+		writeError(w, http.StatusBadRequest, "role must be user, assistant or system")
+		return
+	}
+
+	var err error
+	if q.From, err = parseSearchTime(query.Get("from"), false); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.To, err = parseSearchTime(query.Get("to"), true); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.Limit, err = queryInt(r, "limit", defaultPageSize); err != nil || q.Limit < 1 || q.Limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+
+	hits, err := h.chatService.Search(r.Context(), identityFromContext(r.Context()), q)
+	if errors.Is(err, chat.ErrEmptyQuery) {
+		writeError(w, http.StatusBadRequest, "q must contain at least one word")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Search failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, searchResults{Query: q.Text, Results: hits})
+}
+
+// parseSearchTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseSearchTime(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC 3339", v)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+func TestSearchEndpoint(t *testing.T) {
+	do := newTestServer(chat.NewService(chat.NewHistoryManager(), echoLLM{})).do
+
+	do("alice-key", "POST", "/chat", `{"messages":[{"role":"user","content":"Tuning Kafka retention"}]}`)
+
+	rr := do("alice-key", "GET", "/search?q=kafka&role=user&from=2000-01-01", "")
+	var res searchResults
+	json.NewDecoder(rr.Body).Decode(&res)
+	if rr.Code != http.StatusOK || len(res.Results) != 1 || !strings.Contains(res.Results[0].Snippet, "<mark>Kafka</mark>") {
+		t.Fatalf("search: expected one highlighted hit, got %d %+v", rr.Code, res)
+	}
+
+	rr = do("bob-key", "GET", "/search?q=kafka", "")
+	res = searchResults{}
+	json.NewDecoder(rr.Body).Decode(&res)
+	if rr.Code != http.StatusOK || len(res.Results) != 0 {
+		t.Errorf("search by other identity: expected no hits, got %d %+v", rr.Code, res)
+	}
+
+	for _, path := range []string{"/search", "/search?q=kafka&role=tool", "/search?q=kafka&from=last+week", "/search?q=kafka&limit=0"} {
+		if rr := do("alice-key", "GET", path, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, rr.Code)
+		}
+	}
+}
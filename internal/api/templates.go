@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"chat-service/internal/chat"
+)
+
+// maxTemplateBytes bounds the body of a template upload.
+const maxTemplateBytes = 256 << 10
+
+type templateList struct {
+	Templates []chat.Template `json:"templates"`
+}
+
+// HandleListTemplates handles GET /templates.
+func (h *Handler) HandleListTemplates(w http.ResponseWriter, r *http.Request) {
+	list, err := h.chatService.ListTemplates(r.Context())
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, templateList{Templates: list})
+}
+
+// HandleGetTemplate handles GET /templates/{name}?version=, the latest
+// version unless one is given.
+func (h *Handler) HandleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	version, err := queryInt(r, "version", 0)
+	if err != nil || version < 0 {
+		writeError(w, http.StatusBadRequest, "version must be a positive integer")
+		return
+	}
+	t, err := h.chatService.GetTemplate(r.Context(), r.PathValue("name"), version)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// HandleTemplateVersions handles GET /templates/{name}/versions.
+func (h *Handler) HandleTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := h.chatService.TemplateVersions(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, templateList{Templates: versions})
+}
+
+// HandleSaveTemplate handles PUT /templates/{name}. Every save adds a
+// version; earlier ones stay available.
+func (h *Handler) HandleSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Description string          `json:"description"`
+		Text        string          `json:"text"`
+		Variables   []chat.Variable `json:"variables"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTemplateBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	t, err := h.chatService.SaveTemplate(r.Context(), identityFromContext(r.Context()), chat.Template{
+		Name:        r.PathValue("name"),
+		Description: req.Description,
+		Text:        req.Text,
+		Variables:   req.Variables,
+	})
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// HandleDeleteTemplate handles DELETE /templates/{name}.
+func (h *Handler) HandleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := h.chatService.DeleteTemplate(r.Context(), r.PathValue("name")); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, chat.ErrTemplateNotFound), errors.Is(err, chat.ErrTemplatesDisabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, chat.ErrInvalidTemplate):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, chat.ErrTemplateReadOnly):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Template storage error")
+	}
+}
@@ -0,0 +1,162 @@
+// Package cache is an in-memory chat.ResponseCache. Answers match a
+// question whose whole context hashes the same, or, for single questions,
+// one whose embedding is similar enough. Entries expire after a TTL and the
+// oldest are evicted beyond a size limit.
+package cache
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"chat-service/internal/chat"
+)
+
+const defaultMaxEntries = 1000
+
+// Options configures a Cache.
+type Options struct {
+	// TTL is how long an answer is reused.
+	TTL time.Duration
+	// MaxEntries bounds the number of answers kept; 0 uses the default.
+	MaxEntries int
+	// MinSimilarity is the cosine similarity a question's embedding needs
+	// to an earlier one to reuse its answer; 0 turns similarity matching
+	// off.
+	MinSimilarity float64
+}
+
+// Cache is safe for concurrent use.
+type Cache struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry // by owner, scope and key
+	order   []*entry          // oldest first
+}
+
+type entry struct {
+	id       string
+	owner    string
+	scope    string
+	vector   []float32
+	answer   chat.CachedAnswer
+	storedAt time.Time
+}
+
+func New(opts Options) *Cache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultMaxEntries
+	}
+	return &Cache{opts: opts, now: time.Now, entries: make(map[string]*entry)}
+}
+
+func entryID(q chat.CacheQuery) string {
+	return q.Owner + "\x00" + q.Scope + "\x00" + q.Key
+}
+
+// Lookup returns the answer stored for the same context, or else the one
+// whose question is most similar to q's, within q's owner and scope.
+func (c *Cache) Lookup(ctx context.Context, q chat.CacheQuery) (*chat.CacheHit, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+
+	if e, ok := c.entries[entryID(q)]; ok {
+		return &chat.CacheHit{Answer: e.answer, Match: chat.CacheExact, CachedAt: e.storedAt}, nil
+	}
+	if q.Vector == nil || c.opts.MinSimilarity <= 0 {
+		return nil, nil
+	}
+	var (
+		best    *entry
+		bestSim float64
+	)
+	for _, e := range c.order {
+		if e.owner != q.Owner || e.scope != q.Scope || e.vector == nil {
+			continue
+		}
+		if sim := cosine(q.Vector, e.vector); sim >= c.opts.MinSimilarity && sim > bestSim {
+			best, bestSim = e, sim
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &chat.CacheHit{Answer: best.answer, Match: chat.CacheSimilar, Similarity: bestSim, CachedAt: best.storedAt}, nil
+}
+
+// Store keeps answer for q, replacing an answer stored for the same
+// context.
+func (c *Cache) Store(ctx context.Context, q chat.CacheQuery, answer chat.CachedAnswer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+
+	id := entryID(q)
+	if old, ok := c.entries[id]; ok {
+		c.remove(old)
+	}
+	for len(c.order) >= c.opts.MaxEntries {
+		c.remove(c.order[0])
+	}
+	e := &entry{id: id, owner: q.Owner, scope: q.Scope, vector: q.Vector, answer: answer, storedAt: c.now()}
+	c.entries[id] = e
+	c.order = append(c.order, e)
+	return nil
+}
+
+func (c *Cache) Purge(ctx context.Context, owner string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order = slices.DeleteFunc(c.order, func(e *entry) bool {
+		if e.owner == owner {
+			delete(c.entries, e.id)
+			return true
+		}
+		return false
+	})
+	return nil
+}
+
+// expire drops the entries older than the TTL, which are at the front of
+// order. The caller holds the lock.
+func (c *Cache) expire() {
+	cutoff := c.now().Add(-c.opts.TTL)
+	n := 0
+	for n < len(c.order) && !c.order[n].storedAt.After(cutoff) {
+		delete(c.entries, c.order[n].id)
+		n++
+	}
+	c.order = c.order[n:]
+}
+
+// remove drops e. The caller holds the lock.
+func (c *Cache) remove(e *entry) {
+	delete(c.entries, e.id)
+	for i, o := range c.order {
+		if o == e {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-service/internal/chat"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New(Options{TTL: time.Hour, MaxEntries: 3, MinSimilarity: 0.9})
+	c.now = func() time.Time { return now }
+
+	hours := chat.CacheQuery{Owner: "alice", Scope: "support", Key: "k1", Vector: []float32{1, 0, 0}}
+	c.Store(ctx, hours, chat.CachedAnswer{Content: "9 to 5", Model: "m"})
+
+	if hit, _ := c.Lookup(ctx, hours); hit == nil || hit.Match != chat.CacheExact || hit.Answer.Content != "9 to 5" || !hit.CachedAt.Equal(now) {
+		t.Errorf("Expected an exact hit, got %+v", hit)
+	}
+	similar := chat.CacheQuery{Owner: "alice", Scope: "support", Key: "k2", Vector: []float32{0.95, 0.1, 0}}
+	if hit, _ := c.Lookup(ctx, similar); hit == nil || hit.Match != chat.CacheSimilar || hit.Similarity < 0.9 {
+		t.Errorf("Expected a similar hit, got %+v", hit)
+	}
+	for _, miss := range []chat.CacheQuery{
+		{Owner: "alice", Scope: "sales", Key: "k1", Vector: []float32{1, 0, 0}},
+		{Owner: "bob", Scope: "support", Key: "k1", Vector: []float32{1, 0, 0}},
+		{Owner: "alice", Scope: "support", Key: "k2", Vector: []float32{0.5, 0.5, 0}},
+		{Owner: "alice", Scope: "support", Key: "k2"},
+	} {
+		if hit, _ := c.Lookup(ctx, miss); hit != nil {
+			t.Errorf("Expected a miss for %+v, got %+v", miss, hit)
+		}
+	}
+
+	c.Store(ctx, chat.CacheQuery{Owner: "bob", Scope: "support", Key: "k1"}, chat.CachedAnswer{Content: "8 to 4"})
+	c.Purge(ctx, "alice")
+	if hit, _ := c.Lookup(ctx, hours); hit != nil || len(c.order) != 1 {
+		t.Errorf("Expected only alice's answers purged, got %+v and %d left", hit, len(c.order))
+	}
+	c.Store(ctx, hours, chat.CachedAnswer{Content: "9 to 5", Model: "m"})
+
+	now = now.Add(30 * time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Store(ctx, chat.CacheQuery{Owner: "alice", Scope: "support", Key: key}, chat.CachedAnswer{Content: key})
+	}
+	if hit, _ := c.Lookup(ctx, hours); hit != nil {
+		t.Errorf("Expected the oldest answer evicted, got %+v", hit)
+	}
+	if hit, _ := c.Lookup(ctx, chat.CacheQuery{Owner: "alice", Scope: "support", Key: "a"}); hit == nil {
+		t.Error("Expected newer answers kept")
+	}
+
+	now = now.Add(time.Hour)
+	if hit, _ := c.Lookup(ctx, chat.CacheQuery{Owner: "alice", Scope: "support", Key: "a"}); hit != nil || len(c.order) != 0 {
+		t.Errorf("Expected answers to expire after the TTL, got %+v", hit)
+	}
+}
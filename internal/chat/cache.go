@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How a cached answer matched the question.
+const (
+	CacheExact   = "exact"
+	CacheSimilar = "similar"
+)
+
+// ResponseCache keeps answers so that a question asked again is answered
+// without calling the model. Lookup returns nil on a miss.
+type ResponseCache interface {
+	Lookup(ctx context.Context, q CacheQuery) (*CacheHit, error)
+	Store(ctx context.Context, q CacheQuery, answer CachedAnswer) error
+	// Purge drops every answer stored for owner.
+	Purge(ctx context.Context, owner string) error
+}
+
+// CacheQuery describes a question as it goes to the model. Answers are
+// never shared between owners, whose questions and answers may hold
+// personal data, nor between scopes; the scope is the persona. Key hashes
+// the whole context, for exact matches. Vector is the question's
+// embedding, for matching similar questions; it is nil when the context
+// holds earlier turns, since the answer then depends on more than the
+// question.
+type CacheQuery struct {
+	Owner  string
+	Scope  string
+	Key    string
+	Vector []float32
+}
+
+// CachedAnswer is an answer as the model wrote it, with the citations its
+// [n] markers refer to.
+type CachedAnswer struct {
+	Content   string
+	Model     string
+	Citations []Citation
+}
+
+// CacheHit is a cached answer found for a question. It is recorded in the
+// metadata of the replayed answer under "cache".
+type CacheHit struct {
+	Answer CachedAnswer `json:"-"`
+	// Match is CacheExact or CacheSimilar; Similarity is the cosine
+	// similarity of a similar question.
+	Match      string    `json:"match"`
+	Similarity float64   `json:"similarity,omitempty"`
+	CachedAt   time.Time `json:"cached_at"`
+}
+
+// WithResponseCache answers repeated questions from cache. Similar
+// questions only match with an embedder (see WithEmbedder).
+func WithResponseCache(c ResponseCache) Option {
+	return func(s *Service) {
+		s.cache = c
+	}
+}
+
+// cacheQuery builds owner's query for messages and tools as they go to the
+// model. It returns false when the answer must not come from or go to the
+// cache: the cache is off, or the question was flagged as a prompt
+// injection.
+func (s *Service) cacheQuery(ctx context.Context, owner string, persona Persona, messages []Message, tools []ToolDefinition) (CacheQuery, bool) {
+	n := len(messages)
+	if s.cache == nil || n == 0 || messages[n-1].Role != RoleUser || injectionAction(messages[n-1]) != "" {
+		return CacheQuery{}, false
+	}
+
+	type keyMessage struct {
+		Role       Role       `json:"role"`
+		Content    string     `json:"content"`
+		ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+		ToolCallID string     `json:"tool_call_id,omitempty"`
+	}
+	key := struct {
+		Messages []keyMessage     `json:"messages"`
+		Tools    []ToolDefinition `json:"tools,omitempty"`
+	}{Tools: tools}
+	firstTurn := true
+	for i, m := range messages {
+		key.Messages = append(key.Messages, keyMessage{m.Role, m.Content, m.ToolCalls, m.ToolCallID})
+		if i < n-1 && m.Role != RoleSystem {
+			firstTurn = false
+		}
+	}
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	q := CacheQuery{Owner: owner, Scope: persona.Name, Key: hex.EncodeToString(sum[:])}
+
+	if firstTurn && s.embedder != nil {
+		vecs, err := s.embedder.Embed(ctx, []string{messages[n-1].Content})
+		if err == nil && len(vecs) == 1 {
+			q.Vector = vecs[0]
+		} else {
+			slog.WarnContext(ctx, "Failed to embed question, matching the cache exactly", "error", err)
+		}
+	}
+	return q, true
+}
+
+// lookupCache returns the cached answer for q, or nil. A failing cache is
+// logged and treated as a miss.
+func (s *Service) lookupCache(ctx context.Context, span trace.Span, q CacheQuery) *CacheHit {
+	hit, err := s.cache.Lookup(ctx, q)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Response cache lookup failed", "error", err)
+		return nil
+	}
+	if hit != nil {
+		span.SetAttributes(attribute.String("chat.cache.match", hit.Match))
+	}
+	return hit
+}
+
+// replay streams a cached answer the way the model would, a word at a
+// time, ending with a chunk naming the model. It stops early when ctx
+// ends.
+func replay(ctx context.Context, answer CachedAnswer) <-chan Chunk {
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		for _, word := range strings.SplitAfter(answer.Content, " ") {
+			select {
+			case ch <- Chunk{Content: word}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case ch <- Chunk{Model: answer.Model, FinishReason: "stop"}:
+		case <-ctx.Done():
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// mapCache matches exact keys only and records the queries it was given.
+type mapCache struct {
+	answers map[string]CachedAnswer
+	queries []CacheQuery
+}
+
+func (c *mapCache) Lookup(ctx context.Context, q CacheQuery) (*CacheHit, error) {
+	c.queries = append(c.queries, q)
+	if a, ok := c.answers[q.Owner+"/"+q.Scope+"/"+q.Key]; ok {
+		return &CacheHit{Answer: a, Match: CacheExact}, nil
+	}
+	return nil, nil
+}
+
+func (c *mapCache) Store(ctx context.Context, q CacheQuery, answer CachedAnswer) error {
+	c.answers[q.Owner+"/"+q.Scope+"/"+q.Key] = answer
+	return nil
+}
+
+func (c *mapCache) Purge(ctx context.Context, owner string) error {
+	for key := range c.answers {
+		if strings.HasPrefix(key, owner+"/") {
+			delete(c.answers, key)
+		}
+	}
+	return nil
+}
+
+type fixedEmbedder struct{}
+
+func (fixedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i := range texts {
+		vecs[i] = []float32{1, 0}
+	}
+	return vecs, nil
+}
+
+func (fixedEmbedder) Model() string { return "fixed" }
+
+func TestService_ResponseCache(t *testing.T) {
+	ctx := context.Background()
+	llm := &MockLLM{ResponseChunks: []string{"We open ", "at nine."}}
+	cache := &mapCache{answers: make(map[string]CachedAnswer)}
+	personas := func(name string) (Persona, bool) {
+		return Persona{Name: name, SystemPrompt: "You work at the front desk."}, true
+	}
+	s := NewService(NewHistoryManager(), llm, WithPersonas(personas), WithResponseCache(cache), WithEmbedder(fixedEmbedder{}))
+
+	ask := func(prompt Prompt, regenerate bool) (string, bool) {
+		t.Helper()
+		llm.CapturedMessages = nil
+		var stream <-chan Event
+		var err error
+		if regenerate {
+			stream, err = s.Regenerate(ctx, prompt)
+		} else {
+			stream, err = s.ProcessMessage(ctx, prompt)
+		}
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var sb strings.Builder
+		for ev := range stream {
+			sb.WriteString(ev.Content)
+		}
+		return sb.String(), llm.CapturedMessages != nil
+	}
+
+	if answer, called := ask(Prompt{Owner: "alice", Content: "When are you open?"}, false); !called || answer != "We open at nine." {
+		t.Fatalf("Expected the model to answer first, got %q", answer)
+	}
+	if len(cache.answers) != 1 || cache.queries[0].Vector == nil || cache.queries[0].Owner != "alice" || cache.queries[0].Scope != "" {
+		t.Fatalf("Expected the answer cached with an embedded question, got %+v", cache.queries)
+	}
+
+	llm.ResponseChunks = []string{"Fresh answer."}
+	if _, called := ask(Prompt{Owner: "bob", Content: "When are you open?"}, false); !called {
+		t.Error("Expected answers not shared between owners")
+	}
+	again, _ := s.CreateConversation(ctx, "alice", Conversation{})
+	if answer, called := ask(Prompt{Owner: "alice", ConversationID: again.ID, Content: "When are you open?"}, false); called || answer != "We open at nine." {
+		t.Errorf("Expected the cached answer replayed, got %q (model called: %v)", answer, called)
+	}
+	history, _ := s.GetHistory(ctx, "alice", again.ID)
+	if hit, _ := history[1].Metadata["cache"].(*CacheHit); hit == nil || hit.Match != CacheExact || history[1].Usage != nil || history[1].Model != "mock-model" {
+		t.Errorf("Expected the hit marked in metadata, got %+v", history[1])
+	}
+
+	if _, called := ask(Prompt{Owner: "dave", Content: "When are you open?", Persona: "sales"}, false); !called {
+		t.Error("Expected answers not shared between personas")
+	}
+	if _, called := ask(Prompt{Owner: "alice", Content: "And on Sundays?"}, false); !called || cache.queries[len(cache.queries)-1].Vector != nil {
+		t.Error("Expected follow-up questions matched exactly only")
+	}
+	ask(Prompt{Owner: "carol", Content: "When are you open?"}, false)
+	if answer, called := ask(Prompt{Owner: "carol"}, true); !called || answer != "Fresh answer." {
+		t.Errorf("Expected regeneration to bypass the cache, got %q", answer)
+	}
+
+	if _, err := s.EraseIdentity(ctx, "alice"); err != nil {
+		t.Fatalf("EraseIdentity failed: %v", err)
+	}
+	for key := range cache.answers {
+		if strings.HasPrefix(key, "alice/") {
+			t.Errorf("Expected alice's cached answers purged on erasure, found %q", key)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chat-service/internal/redact"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrDocumentNotFound is returned for an unknown document ID.
+	ErrDocumentNotFound = errors.New("document not found")
+	// ErrUnsupportedDocument is returned when a document's content type
+	// cannot be read.
+	ErrUnsupportedDocument = errors.New("unsupported document type")
+	// ErrUnreadableDocument is returned when no text can be read from a
+	// document of a supported type.
+	ErrUnreadableDocument = errors.New("unreadable document")
+	// ErrDocumentsDisabled is returned by document calls on a Service
+	// without a document index.
+	ErrDocumentsDisabled = errors.New("documents are not enabled")
+)
+
+// defaultRetrievalK is how many passages are added to the context when no
+// count is configured.
+const defaultRetrievalK = 4
+
+// Document is a file in the knowledge base that answers are grounded in.
+type Document struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// ContentType is the media type the document was read as.
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Chunks      int       `json:"chunks"`
+	UploadedBy  string    `json:"uploaded_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Passage is a chunk of a document retrieved for a query.
+type Passage struct {
+	DocumentID string
+	Title      string
+	// Chunk is the position of the passage in its document, from 0.
+	Chunk int
+	Text  string
+	Score float64
+}
+
+// Citation tells the client which passage a [n] marker in the answer
+// refers to. Citations are streamed before the answer and recorded in its
+// metadata under "citations".
+type Citation struct {
+	Index      int     `json:"index"`
+	DocumentID string  `json:"document_id"`
+	Title      string  `json:"title"`
+	Chunk      int     `json:"chunk"`
+	Score      float64 `json:"score"`
+	Snippet    string  `json:"snippet"`
+}
+
+// DocumentIndex stores documents and finds the passages most relevant to a
+// query. Add reads data as doc.ContentType and returns the stored document.
+type DocumentIndex interface {
+	Add(ctx context.Context, doc Document, data []byte) (Document, error)
+	List(ctx context.Context) ([]Document, error)
+	Get(ctx context.Context, id string) (Document, error)
+	Delete(ctx context.Context, id string) error
+	Retrieve(ctx context.Context, query string, k int) ([]Passage, error)
+	// Disown clears the uploader of the documents owner uploaded, which
+	// stay in the knowledge base, and returns their IDs.
+	Disown(ctx context.Context, owner string) ([]string, error)
+}
+
+// WithDocuments grounds answers in the index: the k passages most relevant
+// to the user's message are added to the context, and cited. A k of 0 uses
+// the default.
+func WithDocuments(index DocumentIndex, k int) Option {
+	return func(s *Service) {
+		s.documents = index
+		if k > 0 {
+			s.retrievalK = k
+		}
+	}
+}
+
+// AddDocument reads data into the knowledge base on behalf of owner.
+func (s *Service) AddDocument(ctx context.Context, owner string, doc Document, data []byte) (Document, error) {
+	if s.documents == nil {
+		return Document{}, ErrDocumentsDisabled
+	}
+	doc.ID = newID()
+	doc.UploadedBy = owner
+	doc.CreatedAt = time.Now().UTC()
+	return s.documents.Add(ctx, doc, data)
+}
+
+func (s *Service) ListDocuments(ctx context.Context) ([]Document, error) {
+	if s.documents == nil {
+		return nil, ErrDocumentsDisabled
+	}
+	return s.documents.List(ctx)
+}
+
+func (s *Service) GetDocument(ctx context.Context, id string) (Document, error) {
+	if s.documents == nil {
+		return Document{}, ErrDocumentsDisabled
+	}
+	return s.documents.Get(ctx, id)
+}
+
+func (s *Service) DeleteDocument(ctx context.Context, id string) error {
+	if s.documents == nil {
+		return ErrDocumentsDisabled
+	}
+	return s.documents.Delete(ctx, id)
+}
+
+// maxSnippet bounds the passage excerpt sent with a citation, in bytes.
+const maxSnippet = 200
+
+// groundingPrompt introduces the retrieved passages to the model.
+const groundingPrompt = "The following excerpts from the knowledge base may help answer the user's last message. " +
+	"Use them when they are relevant and cite them by number, like [1]. " +
+	"If they do not answer the question, say so rather than guessing."
+
+// retrieve finds the passages relevant to the last user message in
+// messages and inserts them as a system message just before it, redacted
+// with session when it is not nil. It returns the citations for them; a
+// retrieval failure is logged and the turn goes on ungrounded.
+func (s *Service) retrieve(ctx context.Context, span trace.Span, messages []Message, session *redact.Session) ([]Message, []Citation) {
+	if s.documents == nil || len(messages) == 0 || messages[len(messages)-1].Role != RoleUser {
+		return messages, nil
+	}
+	last := len(messages) - 1
+	passages, err := s.documents.Retrieve(ctx, messages[last].Content, s.retrievalK)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Document retrieval failed", "error", err)
+		return messages, nil
+	}
+	span.SetAttributes(attribute.Int("chat.retrieval.passages", len(passages)))
+	if len(passages) == 0 {
+		return messages, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(groundingPrompt)
+	citations := make([]Citation, len(passages))
+	for i, p := range passages {
+		fmt.Fprintf(&sb, "\n\n[%d] %s (part %d)\n%s", i+1, p.Title, p.Chunk+1, p.Text)
+		citations[i] = Citation{
+			Index:      i + 1,
+			DocumentID: p.DocumentID,
+			Title:      p.Title,
+			Chunk:      p.Chunk,
+			Score:      p.Score,
+			Snippet:    snippetOf(p.Text),
+		}
+	}
+	grounding := sb.String()
+	if session != nil {
+		grounding = session.Redact(grounding)
+	}
+	grounded := make([]Message, 0, len(messages)+1)
+	grounded = append(grounded, messages[:last]...)
+	grounded = append(grounded, Message{Role: RoleSystem, Content: grounding}, messages[last])
+	return grounded, citations
+}
+
+// snippetOf shortens text to about maxSnippet bytes at a word boundary.
+func snippetOf(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= maxSnippet {
+		return text
+	}
+	cut := strings.LastIndexByte(text[:maxSnippet], ' ')
+	if cut <= 0 {
+		cut = maxSnippet
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return text[:cut] + "…"
+}
@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"chat-service/internal/redact"
+)
+
+// stubIndex returns fixed passages and records the queries it was given.
+type stubIndex struct {
+	passages []Passage
+	err      error
+	queries  []string
+}
+
+func (x *stubIndex) Add(ctx context.Context, doc Document, data []byte) (Document, error) {
+	doc.Size = len(data)
+	return doc, nil
+}
+func (x *stubIndex) List(ctx context.Context) ([]Document, error) { return nil, nil }
+func (x *stubIndex) Get(ctx context.Context, id string) (Document, error) {
+	return Document{}, ErrDocumentNotFound
+}
+func (x *stubIndex) Delete(ctx context.Context, id string) error { return ErrDocumentNotFound }
+func (x *stubIndex) Disown(ctx context.Context, owner string) ([]string, error) {
+	return nil, nil
+}
+
+func (x *stubIndex) Retrieve(ctx context.Context, query string, k int) ([]Passage, error) {
+	x.queries = append(x.queries, query)
+	return x.passages[:min(k, len(x.passages))], x.err
+}
+
+func TestService_Documents(t *testing.T) {
+	ctx := context.Background()
+	index := &stubIndex{passages: []Passage{
+		{DocumentID: "d1", Title: "Failover", Chunk: 2, Text: "Promote the replica. " + strings.Repeat("Then wait. ", 40), Score: 3.5},
+		{DocumentID: "d2", Title: "Backups", Chunk: 0, Text: "Backups run nightly.", Score: 1.2},
+		{DocumentID: "d3", Title: "Lunch", Text: "Noon.", Score: 0.1},
+	}}
+
+	t.Run("grounds the answer and cites its sources", func(t *testing.T) {
+		llm := &MockLLM{ResponseChunks: []string{"Promote it [1]."}}
+		h := NewHistoryManager()
+		s := NewService(h, llm, WithDocuments(index, 2))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "database down"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		var events []Event
+		for ev := range stream {
+			events = append(events, ev)
+		}
+
+		if len(events) < 2 || len(events[0].Citations) != 2 || events[1].Content == "" {
+			t.Fatalf("Expected citations before the content, got %+v", events)
+		}
+		c := events[0].Citations[0]
+		if c.Index != 1 || c.DocumentID != "d1" || c.Chunk != 2 || c.Score != 3.5 || len(c.Snippet) > maxSnippet+len("…") || !strings.HasSuffix(c.Snippet, "…") {
+			t.Errorf("Unexpected citation %+v", c)
+		}
+
+		sent := llm.CapturedMessages
+		if len(sent) != 2 || sent[0].Role != RoleSystem || sent[1].Content != "database down" {
+			t.Fatalf("Expected the passages just before the user message, got %+v", sent)
+		}
+		if !strings.Contains(sent[0].Content, "[1] Failover (part 3)\nPromote the replica.") || !strings.Contains(sent[0].Content, "[2] Backups (part 1)") || strings.Contains(sent[0].Content, "Lunch") {
+			t.Errorf("Unexpected grounding message %q", sent[0].Content)
+		}
+		if index.queries[len(index.queries)-1] != "database down" {
+			t.Errorf("Expected the user message as the query, got %q", index.queries)
+		}
+
+		msgs, _ := h.GetAll(ctx, DefaultConversationID("alice"))
+		if citations, _ := msgs[1].Metadata["citations"].([]Citation); len(citations) != 2 {
+			t.Errorf("Expected citations in the answer metadata, got %+v", msgs[1].Metadata)
+		}
+	})
+
+	t.Run("answers ungrounded when retrieval fails", func(t *testing.T) {
+		llm := &MockLLM{ResponseChunks: []string{"Not sure."}}
+		s := NewService(NewHistoryManager(), llm, WithDocuments(&stubIndex{err: errors.New("index down")}, 0))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "database down"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		for ev := range stream {
+			if ev.Citations != nil {
+				t.Errorf("Expected no citations, got %+v", ev.Citations)
+			}
+		}
+		if len(llm.CapturedMessages) != 1 {
+			t.Errorf("Expected only the user message, got %+v", llm.CapturedMessages)
+		}
+	})
+
+	t.Run("retrieves with the redacted message", func(t *testing.T) {
+		r, _ := redact.New(redact.Options{Mode: redact.ModePlaceholder})
+		index := &stubIndex{passages: []Passage{{DocumentID: "d1", Title: "Contacts", Text: "Escalate to oncall@example.com."}}}
+		llm := &MockLLM{ResponseChunks: []string{"OK."}}
+		s := NewService(NewHistoryManager(), llm, WithDocuments(index, 0), WithRedactor(func() *redact.Redactor { return r }))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "jane@example.com cannot log in"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		for range stream {
+		}
+		if index.queries[0] != "[EMAIL_1] cannot log in" {
+			t.Errorf("Expected the query redacted, got %q", index.queries)
+		}
+		if sent := llm.CapturedMessages[0].Content; !strings.Contains(sent, "Escalate to [EMAIL_2].") {
+			t.Errorf("Expected the passages redacted, got %q", sent)
+		}
+	})
+
+	t.Run("needs an index", func(t *testing.T) {
+		s := NewService(NewHistoryManager(), &MockLLM{})
+		if _, err := s.AddDocument(ctx, "alice", Document{}, []byte("x")); !errors.Is(err, ErrDocumentsDisabled) {
+			t.Errorf("Expected ErrDocumentsDisabled, got %v", err)
+		}
+		s = NewService(NewHistoryManager(), &MockLLM{}, WithDocuments(index, 0))
+		doc, err := s.AddDocument(ctx, "alice", Document{Title: "t"}, []byte("x"))
+		if err != nil || doc.ID == "" || doc.UploadedBy != "alice" || doc.CreatedAt.IsZero() {
+			t.Errorf("Expected the document stamped, got %+v, %v", doc, err)
+		}
+	})
+}
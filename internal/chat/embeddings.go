@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrEmbeddingsDisabled is returned by Embed on a Service without an
+// embedder.
+var ErrEmbeddingsDisabled = errors.New("embeddings are not enabled")
+
+// Embedder turns texts into vectors whose cosine similarity reflects how
+// related the texts are. Model names the embedding model; vectors from
+// different models must not be compared.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+}
+
+// WithEmbedder makes the embedder available through Embed.
+func WithEmbedder(e Embedder) Option {
+	return func(s *Service) {
+		s.embedder = e
+	}
+}
+
+// EmbeddingModel names the model Embed uses, or returns "" when
+// embeddings are not enabled.
+func (s *Service) EmbeddingModel() string {
+	if s.embedder == nil {
+		return ""
+	}
+	return s.embedder.Model()
+}
+
+// Embed returns one vector per text, in order.
+func (s *Service) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if s.embedder == nil {
+		return nil, ErrEmbeddingsDisabled
+	}
+	vecs, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vecs) != len(texts) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vecs), len(texts))
+	}
+	return vecs, nil
+}
@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TranscriptVersion is the version of the JSON export format written by
+// WriteTranscripts and accepted by ImportConversation.
+const TranscriptVersion = 1
+
+// Format names an export format.
+type Format string
+
+const (
+	// FormatJSON is the lossless export: every branch and all message
+	// metadata. It is the only format that can be imported again.
+	FormatJSON Format = "json"
+	// FormatMarkdown renders the active branch for people to read.
+	FormatMarkdown Format = "markdown"
+	// FormatJSONL writes one OpenAI fine-tuning example per conversation.
+	FormatJSONL Format = "jsonl"
+)
+
+var (
+	// ErrUnknownFormat is returned for an export format name that is not
+	// supported.
+	ErrUnknownFormat = errors.New("unknown export format")
+	// ErrInvalidTranscript is returned when an import does not hold a
+	// well-formed conversation.
+	ErrInvalidTranscript = errors.New("invalid transcript")
+)
+
+// ParseFormat resolves a format name; an empty name means FormatJSON.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatMarkdown, FormatJSONL:
+		return f, nil
+	case "md":
+		return FormatMarkdown, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+	}
+}
+
+// ContentType is the media type of documents in format f.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatJSONL:
+		return "application/jsonl"
+	default:
+		return "application/json"
+	}
+}
+
+// Extension is the file extension for documents in format f.
+func (f Format) Extension() string {
+	if f == FormatMarkdown {
+		return ".md"
+	}
+	return "." + string(f)
+}
+
+// Transcript is a conversation with every message of every branch. Its JSON
+// encoding is the export format.
+type Transcript struct {
+	Version      int          `json:"version"`
+	Conversation Conversation `json:"conversation"`
+	// SystemPrompt is the persona prompt in effect at export time. It is
+	// informational and ignored on import.
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Messages     []Message `json:"messages"`
+}
+
+// ActiveBranch returns the messages of the branch ending at CurrentLeaf.
+func (t Transcript) ActiveBranch() []Message {
+	return branchTo(t.Messages, t.Conversation.CurrentLeaf)
+}
+
+// WriteTranscripts encodes ts to w in format f. A single JSON transcript is
+// written as an object, several as an array.
+func WriteTranscripts(w io.Writer, f Format, ts ...Transcript) error {
+	switch f {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(ts) == 1 {
+			return enc.Encode(ts[0])
+		}
+		if ts == nil {
+			ts = []Transcript{}
+		}
+		return enc.Encode(ts)
+	case FormatMarkdown:
+		for i, t := range ts {
+			if i > 0 {
+				if _, err := io.WriteString(w, "\n---\n\n"); err != nil {
+					return err
+				}
+			}
+			if err := writeMarkdown(w, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, t := range ts {
+			example, ok := fineTuningExample(t)
+			if !ok {
+				continue
+			}
+			if err := enc.Encode(example); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+	}
+}
+
+func writeMarkdown(w io.Writer, t Transcript) error {
+	c := t.Conversation
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", markdownTitle(c))
+	fmt.Fprintf(&b, "- Conversation: `%s`\n", c.ID)
+	fmt.Fprintf(&b, "- Created: %s\n", c.CreatedAt.Format(time.RFC3339))
+	if c.Persona != "" {
+		fmt.Fprintf(&b, "- Persona: %s\n", c.Persona)
+	}
+	if c.ForkedFrom != nil {
+		fmt.Fprintf(&b, "- Forked from: `%s`\n", c.ForkedFrom.ConversationID)
+	}
+	b.WriteString("\n")
+
+	if t.SystemPrompt != "" {
+		fmt.Fprintf(&b, "## System\n\n%s\n\n", t.SystemPrompt)
+	}
+	for _, m := range t.ActiveBranch() {
+		fmt.Fprintf(&b, "## %s", roleHeading(m.Role))
+		if m.Model != "" {
+			fmt.Fprintf(&b, " (%s)", m.Model)
+		}
+		b.WriteString("\n\n")
+		if !m.CreatedAt.IsZero() {
+			fmt.Fprintf(&b, "_%s_\n\n", m.CreatedAt.Format(time.RFC3339))
+		}
+		b.WriteString(strings.TrimSpace(m.Content))
+		if m.Interrupted {
+			b.WriteString("\n\n_(interrupted)_")
+		}
+		b.WriteString("\n\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownTitle(c Conversation) string {
+	if c.Title != "" {
+		return c.Title
+	}
+	return "Conversation " + c.ID
+}
+
+func roleHeading(r Role) string {
+	switch r {
+	case RoleUser:
+		return "User"
+	case RoleAssistant:
+		return "Assistant"
+	case RoleSystem:
+		return "System"
+	default:
+		return string(r)
+	}
+}
+
+type fineTuningMessage struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// fineTuningExample converts the active branch to the OpenAI chat
+// fine-tuning format. Interrupted replies and anything after the last
+// complete assistant reply are dropped; a conversation without one yields
+// no example.
+func fineTuningExample(t Transcript) (map[string][]fineTuningMessage, bool) {
+	var msgs []fineTuningMessage
+	if t.SystemPrompt != "" {
+		msgs = append(msgs, fineTuningMessage{Role: RoleSystem, Content: t.SystemPrompt})
+	}
+	lastAnswer := -1
+	for _, m := range t.ActiveBranch() {
+		if m.Interrupted || m.Content == "" {
+			continue
+		}
+		msgs = append(msgs, fineTuningMessage{Role: m.Role, Content: m.Content})
+		if m.Role == RoleAssistant {
+			lastAnswer = len(msgs) - 1
+		}
+	}
+	if lastAnswer < 0 {
+		return nil, false
+	}
+	return map[string][]fineTuningMessage{"messages": msgs[:lastAnswer+1]}, true
+}
+
+// validate checks that t describes a message tree that can be stored as is:
+// unique IDs, known roles, and parents that precede their children.
+// Transcripts from before branching, without IDs or parent links, are
+// accepted and read as a single branch.
+func (t *Transcript) validate() error {
+	if t.Version != TranscriptVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidTranscript, t.Version)
+	}
+	rec := conversationRecord{Conversation: t.Conversation, Messages: t.Messages}
+	migrateLinear(&rec)
+	t.Conversation, t.Messages = rec.Conversation, rec.Messages
+
+	seen := make(map[string]bool, len(t.Messages))
+	for i, m := range t.Messages {
+		switch {
+		case m.Role != RoleUser && m.Role != RoleAssistant && m.Role != RoleSystem:
+			return fmt.Errorf("%w: message %d has role %q", ErrInvalidTranscript, i, m.Role)
+		case m.ID == "":
+			return fmt.Errorf("%w: message %d has no id", ErrInvalidTranscript, i)
+		case seen[m.ID]:
+			return fmt.Errorf("%w: duplicate message id %q", ErrInvalidTranscript, m.ID)
+		case m.ParentID != "" && !seen[m.ParentID]:
+			return fmt.Errorf("%w: message %q refers to unknown parent %q", ErrInvalidTranscript, m.ID, m.ParentID)
+		}
+		seen[m.ID] = true
+	}
+	if leaf := t.Conversation.CurrentLeaf; leaf != "" && !seen[leaf] {
+		return fmt.Errorf("%w: current_leaf %q is not a message", ErrInvalidTranscript, leaf)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteTranscripts(t *testing.T) {
+	ctx := context.Background()
+	personas := func(name string) (Persona, bool) {
+		return Persona{Name: "support", SystemPrompt: "Be helpful."}, name == "support"
+	}
+	s := NewService(NewHistoryManager(), &MockLLM{ResponseChunks: []string{"Hello!"}}, WithPersonas(personas))
+	c, _ := s.CreateConversation(ctx, "alice", Conversation{Title: "Greeting", Persona: "support"})
+	stream, _ := s.ProcessMessage(ctx, Prompt{Owner: "alice", ConversationID: c.ID, Content: "Hi"})
+	for range stream {
+	}
+	// A trailing unanswered question is not a usable training example.
+	s.history.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: "Still there?"})
+
+	tr, err := s.ExportConversation(ctx, "alice", c.ID)
+	if err != nil {
+		t.Fatalf("ExportConversation failed: %v", err)
+	}
+
+	var md bytes.Buffer
+	if err := WriteTranscripts(&md, FormatMarkdown, tr); err != nil {
+		t.Fatalf("markdown: %v", err)
+	}
+	for _, want := range []string{"# Greeting", "## System\n\nBe helpful.", "## User", "Hi", "## Assistant (mock-model)", "Hello!"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown: expected %q in\n%s", want, md.String())
+		}
+	}
+
+	var jsonl bytes.Buffer
+	if err := WriteTranscripts(&jsonl, FormatJSONL, tr, Transcript{Version: TranscriptVersion}); err != nil {
+		t.Fatalf("jsonl: %v", err)
+	}
+	want := `{"messages":[{"role":"system","content":"Be helpful."},{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello!"}]}` + "\n"
+	if jsonl.String() != want {
+		t.Errorf("jsonl: expected\n%s\ngot\n%s", want, jsonl.String())
+	}
+
+	var js bytes.Buffer
+	if err := WriteTranscripts(&js, FormatJSON, tr); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var decoded Transcript
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || len(decoded.Messages) != 3 {
+		t.Fatalf("json: expected a transcript with 3 messages, got %v %+v", err, decoded)
+	}
+
+	if _, err := ParseFormat("pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestImportConversation(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistoryManager()
+	src, _ := h.CreateConversation(ctx, Conversation{Owner: "alice", Title: "Original"})
+	q, _ := h.AddMessage(ctx, src.ID, Message{Role: RoleUser, Content: "Q"})
+	h.AddMessage(ctx, src.ID, Message{Role: RoleAssistant, Content: "A1"})
+	h.AddBranch(ctx, src.ID, q.ID, Message{Role: RoleAssistant, Content: "A2"})
+	src, _ = h.GetConversation(ctx, "alice", src.ID)
+	msgs, _ := h.GetTree(ctx, src.ID)
+
+	imported, err := h.ImportConversation(ctx, "bob", Transcript{Version: TranscriptVersion, Conversation: src, Messages: msgs})
+	if err != nil {
+		t.Fatalf("ImportConversation failed: %v", err)
+	}
+	if imported.ID == src.ID || imported.Owner != "bob" || imported.Title != "Original" || imported.Metadata["imported_from"] != src.ID {
+		t.Errorf("Expected a new conversation for bob recording its origin, got %+v", imported)
+	}
+	if tree, _ := h.GetTree(ctx, imported.ID); len(tree) != 3 {
+		t.Errorf("Expected every branch to be restored, got %d messages", len(tree))
+	}
+	if active, _ := h.GetAll(ctx, imported.ID); len(active) != 2 || active[1].Content != "A2" {
+		t.Errorf("Expected the active branch to be restored, got %+v", active)
+	}
+
+	legacy := Transcript{Version: TranscriptVersion, Messages: []Message{{Role: RoleUser, Content: "Q"}, {Role: RoleAssistant, Content: "A"}}}
+	c, err := h.ImportConversation(ctx, "bob", legacy)
+	if err != nil {
+		t.Fatalf("Expected linear transcript without IDs to import, got %v", err)
+	}
+	if active, _ := h.GetAll(ctx, c.ID); len(active) != 2 {
+		t.Errorf("Expected linear transcript as one branch, got %+v", active)
+	}
+
+	for name, bad := range map[string]Transcript{
+		"version":     {Version: 2},
+		"role":        {Version: TranscriptVersion, Messages: []Message{{ID: "a", Role: "tool"}}},
+		"duplicate":   {Version: TranscriptVersion, Conversation: Conversation{CurrentLeaf: "a"}, Messages: []Message{{ID: "a", Role: RoleUser}, {ID: "a", Role: RoleUser}}},
+		"orphan":      {Version: TranscriptVersion, Conversation: Conversation{CurrentLeaf: "b"}, Messages: []Message{{ID: "b", ParentID: "x", Role: RoleUser}}},
+		"unknownLeaf": {Version: TranscriptVersion, Conversation: Conversation{CurrentLeaf: "z"}, Messages: []Message{{ID: "a", Role: RoleUser}}},
+	} {
+		if _, err := h.ImportConversation(ctx, "bob", bad); !errors.Is(err, ErrInvalidTranscript) {
+			t.Errorf("%s: expected ErrInvalidTranscript, got %v", name, err)
+		}
+	}
+}
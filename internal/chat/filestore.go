@@ -0,0 +1,353 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"chat-service/internal/encryption"
+)
+
+// validID restricts conversation IDs to characters that are safe as file
+// names, since IDs arrive from request paths.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// FileStore persists each conversation as a JSON file in a directory. All
+// records are loaded into memory at startup and every change is written
+// through, so reads never touch the disk.
+type FileStore struct {
+	dir string
+	mem *MemoryStore
+	mu  sync.Mutex // serialises writes so files match memory order
+
+	// keys enables encryption at rest when set. Each conversation has its
+	// own data key, kept unwrapped in dataKeys while the store is open.
+	keys     *encryption.Keyring
+	dataKeys map[string]dataKey
+}
+
+type dataKey struct {
+	key     []byte
+	wrapped encryption.WrappedKey
+}
+
+// FileStoreOption configures a FileStore.
+type FileStoreOption func(*FileStore)
+
+// WithEncryption encrypts titles, message content and metadata on disk
+// with a data key per conversation, wrapped by the keyring's primary key.
+// Plaintext files, and data keys wrapped by an older key, are rewritten
+// when the store is opened, so rotating the primary key re-wraps every
+// data key on the next start. Like the file itself, every message is
+// sealed again on each write, so appending costs time in proportion to
+// the length of the conversation.
+func WithEncryption(keys *encryption.Keyring) FileStoreOption {
+	return func(s *FileStore) {
+		s.keys = keys
+	}
+}
+
+// NewFileStore opens dir, creating it if needed, and loads every stored
+// conversation.
+func NewFileStore(dir string, opts ...FileStoreOption) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history dir: %w", err)
+	}
+
+	s := &FileStore{dir: dir, mem: NewMemoryStore(), dataKeys: make(map[string]dataKey)}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var rec conversationRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if rec.Messages == nil {
+			rec.Messages = make([]Message, 0)
+		}
+		stale, err := s.decrypt(&rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+		migrateLinear(&rec)
+		s.mem.load(&rec)
+		if stale {
+			if err := s.persist(rec.Conversation.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) SaveConversation(ctx context.Context, c Conversation) error {
+	if !validID.MatchString(c.ID) {
+		return fmt.Errorf("invalid conversation id %q", c.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.SaveConversation(ctx, c); err != nil {
+		return err
+	}
+	return s.persist(c.ID)
+}
+
+func (s *FileStore) GetConversation(ctx context.Context, id string) (Conversation, error) {
+	return s.mem.GetConversation(ctx, id)
+}
+
+func (s *FileStore) ListConversations(ctx context.Context, owner string) ([]Conversation, error) {
+	return s.mem.ListConversations(ctx, owner)
+}
+
+func (s *FileStore) DeleteConversation(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.DeleteConversation(ctx, id); err != nil {
+		return err
+	}
+	delete(s.dataKeys, id)
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete conversation file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Owners(ctx context.Context) ([]string, error) {
+	return s.mem.Owners(ctx)
+}
+
+func (s *FileStore) AppendMessage(ctx context.Context, id string, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.AppendMessage(ctx, id, msg); err != nil {
+		return err
+	}
+	return s.persist(id)
+}
+
+func (s *FileStore) Messages(ctx context.Context, id string) ([]Message, error) {
+	return s.mem.Messages(ctx, id)
+}
+
+func (s *FileStore) ReplaceMessages(ctx context.Context, id string, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.ReplaceMessages(ctx, id, msgs); err != nil {
+		return err
+	}
+	return s.persist(id)
+}
+
+func (s *FileStore) Search(ctx context.Context, owner string, q SearchQuery) ([]SearchHit, error) {
+	return s.mem.Search(ctx, owner, q)
+}
+
+// Ping checks that the directory is still writable.
+func (s *FileStore) Ping(ctx context.Context) error {
+	f, err := os.CreateTemp(s.dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("history dir not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// persist writes the record for id atomically via a temp file and rename,
+// so a crash never leaves a half-written conversation behind.
+func (s *FileStore) persist(id string) error {
+	rec, ok := s.mem.record(id)
+	if !ok {
+		return ErrConversationNotFound
+	}
+	if err := s.encrypt(&rec); err != nil {
+		return fmt.Errorf("failed to encrypt conversation: %w", err)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+id+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write conversation: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write conversation: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write conversation: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write conversation: %w", err)
+	}
+	return nil
+}
+
+// encrypt replaces the content of rec, a copy about to be written, with
+// ciphertext under the conversation's data key, creating the key on first
+// use. It does nothing when encryption is off.
+func (s *FileStore) encrypt(rec *conversationRecord) error {
+	if s.keys == nil {
+		return nil
+	}
+	id := rec.Conversation.ID
+	dk, ok := s.dataKeys[id]
+	if !ok {
+		key, wrapped, err := s.keys.NewDataKey()
+		if err != nil {
+			return err
+		}
+		dk = dataKey{key, wrapped}
+		s.dataKeys[id] = dk
+	}
+
+	title, err := encryption.Seal(dk.key, rec.Conversation.Title, id)
+	if err != nil {
+		return err
+	}
+	rec.Conversation.Title = title
+	sealed := make(map[string]string)
+	if len(rec.Conversation.Metadata) > 0 {
+		if sealed[""], err = sealJSON(dk.key, rec.Conversation.Metadata, id+"/metadata"); err != nil {
+			return err
+		}
+		rec.Conversation.Metadata = nil
+	}
+	for i := range rec.Messages {
+		m := &rec.Messages[i]
+		if m.Content, err = encryption.Seal(dk.key, m.Content, id+"/"+m.ID); err != nil {
+			return err
+		}
+		if len(m.Metadata) > 0 {
+			if sealed[m.ID], err = sealJSON(dk.key, m.Metadata, id+"/"+m.ID+"/metadata"); err != nil {
+				return err
+			}
+			m.Metadata = nil
+		}
+	}
+	if len(sealed) > 0 {
+		rec.SealedMetadata = sealed
+	}
+	rec.Encryption = &dk.wrapped
+	return nil
+}
+
+// decrypt restores the plaintext of rec as read from disk and keeps its data
+// key for later writes. It reports whether the file should be rewritten:
+// it is plaintext but encryption is on, its data key is wrapped by a key
+// other than the primary one, or it was written before metadata was
+// encrypted.
+func (s *FileStore) decrypt(rec *conversationRecord) (bool, error) {
+	if rec.Encryption == nil {
+		return s.keys != nil, nil
+	}
+	if s.keys == nil {
+		return false, errors.New("conversation is encrypted but no encryption keys are configured")
+	}
+	id := rec.Conversation.ID
+	key, err := s.keys.Unwrap(*rec.Encryption)
+	if err != nil {
+		return false, err
+	}
+
+	if rec.Conversation.Title, err = encryption.Open(key, rec.Conversation.Title, id); err != nil {
+		return false, err
+	}
+	plainMetadata := len(rec.Conversation.Metadata) > 0
+	if sealed, ok := rec.SealedMetadata[""]; ok {
+		if err := openJSON(key, sealed, id+"/metadata", &rec.Conversation.Metadata); err != nil {
+			return false, fmt.Errorf("metadata: %w", err)
+		}
+	}
+	for i := range rec.Messages {
+		m := &rec.Messages[i]
+		if m.Content, err = encryption.Open(key, m.Content, id+"/"+m.ID); err != nil {
+			return false, fmt.Errorf("message %s: %w", m.ID, err)
+		}
+		plainMetadata = plainMetadata || len(m.Metadata) > 0
+		if sealed, ok := rec.SealedMetadata[m.ID]; ok {
+			if err := openJSON(key, sealed, id+"/"+m.ID+"/metadata", &m.Metadata); err != nil {
+				return false, fmt.Errorf("message %s metadata: %w", m.ID, err)
+			}
+		}
+	}
+	rec.SealedMetadata = nil
+
+	stale := rec.Encryption.KeyID != s.keys.PrimaryID()
+	wrapped := *rec.Encryption
+	if stale {
+		if wrapped, err = s.keys.Wrap(key); err != nil {
+			return false, err
+		}
+	}
+	s.dataKeys[id] = dataKey{key, wrapped}
+	rec.Encryption = nil
+	return stale || plainMetadata, nil
+}
+
+// sealJSON encrypts the JSON encoding of v.
+func sealJSON(key []byte, v any, aad string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return encryption.Seal(key, string(data), aad)
+}
+
+// openJSON decrypts ciphertext from sealJSON into v.
+func openJSON(key []byte, ciphertext, aad string, v any) error {
+	data, err := encryption.Open(key, ciphertext, aad)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// migrateLinear upgrades records written before messages formed a tree:
+// such records have messages but no branch pointer. Their messages are
+// chained in order and given IDs where missing.
+func migrateLinear(rec *conversationRecord) {
+	if rec.Conversation.CurrentLeaf != "" || len(rec.Messages) == 0 {
+		return
+	}
+	parent := ""
+	for i := range rec.Messages {
+		m := &rec.Messages[i]
+		if m.ID == "" {
+			m.ID = newID()
+		}
+		m.ParentID = parent
+		parent = m.ID
+	}
+	rec.Conversation.CurrentLeaf = parent
+}
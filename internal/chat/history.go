@@ -1,49 +1,455 @@
 package chat
 
 import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
 	"sync"
+	"time"
 )
 
+// ErrMessageNotFound is returned when a message ID does not exist in the
+// conversation.
+var ErrMessageNotFound = errors.New("message not found")
+
 const maxHistory = 20
 
+// HistoryManager manages conversations and their messages on top of a
+// Store, enforcing that callers only see conversations they own.
 type HistoryManager struct {
-	mu       sync.RWMutex
-	messages []Message
+	store Store
+	// mu serialises read-modify-write updates of conversation metadata,
+	// such as moving the branch pointer as messages are added.
+	mu sync.Mutex
 }
 
+// NewHistoryManager returns a manager backed by an in-memory store.
 func NewHistoryManager() *HistoryManager {
-	return &HistoryManager{
-		messages: make([]Message, 0),
+	return NewHistoryManagerWithStore(NewMemoryStore())
+}
+
+func NewHistoryManagerWithStore(store Store) *HistoryManager {
+	return &HistoryManager{store: store}
+}
+
+// DefaultConversationID is the conversation used when a chat request names
+// none, keeping the original single-conversation behaviour per caller.
+func DefaultConversationID(owner string) string {
+	return "default-" + owner
+}
+
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// CreateConversation stores c for its owner, assigning an ID unless one is
+// set, and timestamps.
+func (h *HistoryManager) CreateConversation(ctx context.Context, c Conversation) (Conversation, error) {
+	if c.ID == "" {
+		c.ID = newID()
+	}
+	now := time.Now().UTC()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	c.MessageCount = 0
+	if err := h.store.SaveConversation(ctx, c); err != nil {
+		return Conversation{}, err
+	}
+	return c, nil
+}
+
+// GetConversation returns the conversation if it exists and belongs to owner.
+func (h *HistoryManager) GetConversation(ctx context.Context, owner, id string) (Conversation, error) {
+	return h.getOwned(ctx, owner, id)
+}
+
+func (h *HistoryManager) getOwned(ctx context.Context, owner, id string) (Conversation, error) {
+	c, err := h.store.GetConversation(ctx, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if c.Owner != owner {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return c, nil
+}
+
+// EnsureConversation returns the owner's conversation id, creating the
+// owner's default conversation on first use.
+func (h *HistoryManager) EnsureConversation(ctx context.Context, owner, id string) (Conversation, error) {
+	if id == "" {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		id = DefaultConversationID(owner)
+		c, err := h.GetConversation(ctx, owner, id)
+		if err == ErrConversationNotFound {
+			return h.CreateConversation(ctx, Conversation{ID: id, Owner: owner})
+		}
+		return c, err
+	}
+	return h.GetConversation(ctx, owner, id)
+}
+
+// ListConversations returns one page of the owner's conversations, most
+// recently updated first, and the total number available.
+func (h *HistoryManager) ListConversations(ctx context.Context, owner string, offset, limit int) ([]Conversation, int, error) {
+	all, err := h.store.ListConversations(ctx, owner)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(all)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return all[offset:end], total, nil
+}
+
+// UpdateConversation applies patch to the owner's conversation.
+func (h *HistoryManager) UpdateConversation(ctx context.Context, owner, id string, patch ConversationPatch) (Conversation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.getOwned(ctx, owner, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if patch.Title != nil {
+		c.Title = *patch.Title
+	}
+	if patch.Persona != nil {
+		c.Persona = *patch.Persona
+	}
+	for k, v := range patch.Metadata {
+		if v == nil {
+			delete(c.Metadata, k)
+			continue
+		}
+		if c.Metadata == nil {
+			c.Metadata = make(map[string]string)
+		}
+		c.Metadata[k] = *v
+	}
+	c.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveConversation(ctx, c); err != nil {
+		return Conversation{}, err
 	}
+	return c, nil
 }
 
-func (h *HistoryManager) AddMessage(msg Message) {
+// DeleteConversation removes the owner's conversation and its messages.
+func (h *HistoryManager) DeleteConversation(ctx context.Context, owner, id string) error {
+	if _, err := h.GetConversation(ctx, owner, id); err != nil {
+		return err
+	}
+	return h.store.DeleteConversation(ctx, id)
+}
+
+// ForkConversation copies the owner's conversation id into a new one. Only
+// the branch ending at messageID is copied, or the active branch when
+// messageID is empty. Messages get new IDs but keep their content,
+// timestamps and generation metadata. The fork belongs to dst.Owner when
+// set and to owner otherwise; fields set on dst (title, persona, metadata)
+// override those inherited from the source.
+func (h *HistoryManager) ForkConversation(ctx context.Context, owner, id, messageID string, dst Conversation) (Conversation, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	h.messages = append(h.messages, msg)
+	src, err := h.getOwned(ctx, owner, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	msgs, err := h.store.Messages(ctx, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	leaf := src.CurrentLeaf
+	if messageID != "" {
+		if _, ok := indexByID(msgs)[messageID]; !ok {
+			return Conversation{}, ErrMessageNotFound
+		}
+		leaf = messageID
+	}
+
+	fork := Conversation{
+		Owner:    cmp.Or(dst.Owner, owner),
+		Title:    cmp.Or(dst.Title, src.Title),
+		Persona:  cmp.Or(dst.Persona, src.Persona),
+		Metadata: maps.Clone(src.Metadata),
+		ForkedFrom: &Provenance{
+			ConversationID: src.ID,
+			MessageID:      leaf,
+			ForkedAt:       time.Now().UTC(),
+		},
+	}
+	for k, v := range dst.Metadata {
+		if fork.Metadata == nil {
+			fork.Metadata = make(map[string]string)
+		}
+		fork.Metadata[k] = v
+	}
+	fork, err = h.CreateConversation(ctx, fork)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	parentID := ""
+	for _, m := range branchTo(msgs, leaf) {
+		m.ID = ""
+		m.Metadata = maps.Clone(m.Metadata)
+		copied, err := h.addLocked(ctx, fork, parentID, m)
+		if err != nil {
+			return Conversation{}, err
+		}
+		fork.CurrentLeaf = copied.ID
+		parentID = copied.ID
+	}
+	// addLocked dates the conversation by its newest message; a fork is
+	// new as of now.
+	if err := h.store.SaveConversation(ctx, fork); err != nil {
+		return Conversation{}, err
+	}
+	return h.store.GetConversation(ctx, fork.ID)
 }
 
-func (h *HistoryManager) GetContext() []Message {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// ImportConversation stores the transcript t as a new conversation of
+// owner. Messages keep their IDs, branches and timestamps; the
+// conversation gets a new ID and records the original one in its metadata.
+func (h *HistoryManager) ImportConversation(ctx context.Context, owner string, t Transcript) (Conversation, error) {
+	if err := t.validate(); err != nil {
+		return Conversation{}, err
+	}
+	src := t.Conversation
+	c := Conversation{
+		Owner:    owner,
+		Title:    src.Title,
+		Persona:  src.Persona,
+		Metadata: maps.Clone(src.Metadata),
+	}
+	if src.ID != "" {
+		if c.Metadata == nil {
+			c.Metadata = make(map[string]string)
+		}
+		c.Metadata["imported_from"] = src.ID
+	}
+	c, err := h.CreateConversation(ctx, c)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	for _, m := range t.Messages {
+		if err := h.store.AppendMessage(ctx, c.ID, m); err != nil {
+			return Conversation{}, err
+		}
+	}
+	c.CurrentLeaf = src.CurrentLeaf
+	if err := h.store.SaveConversation(ctx, c); err != nil {
+		return Conversation{}, err
+	}
+	return h.store.GetConversation(ctx, c.ID)
+}
 
-	total := len(h.messages)
+// AddMessage appends msg to the active branch of the conversation.
+func (h *HistoryManager) AddMessage(ctx context.Context, id string, msg Message) (Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.store.GetConversation(ctx, id)
+	if err != nil {
+		return Message{}, err
+	}
+	return h.addLocked(ctx, c, c.CurrentLeaf, msg)
+}
+
+// AddBranch adds msg as a child of parentID (empty for a new root) and makes
+// it the active branch. Adding under a message that already has children
+// creates a sibling branch.
+func (h *HistoryManager) AddBranch(ctx context.Context, id, parentID string, msg Message) (Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.store.GetConversation(ctx, id)
+	if err != nil {
+		return Message{}, err
+	}
+	if parentID != "" {
+		msgs, err := h.store.Messages(ctx, id)
+		if err != nil {
+			return Message{}, err
+		}
+		if _, ok := indexByID(msgs)[parentID]; !ok {
+			return Message{}, ErrMessageNotFound
+		}
+	}
+	return h.addLocked(ctx, c, parentID, msg)
+}
+
+// addLocked stores msg under parentID, assigning its ID and creation time if
+// unset, and moves the conversation's branch pointer to it.
+func (h *HistoryManager) addLocked(ctx context.Context, c Conversation, parentID string, msg Message) (Message, error) {
+	if msg.ID == "" {
+		msg.ID = newID()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now().UTC()
+	}
+	msg.ParentID = parentID
+	if err := h.store.AppendMessage(ctx, c.ID, msg); err != nil {
+		return Message{}, err
+	}
+	c.CurrentLeaf = msg.ID
+	c.UpdatedAt = msg.CreatedAt
+	return msg, h.store.SaveConversation(ctx, c)
+}
+
+// SwitchBranch makes the branch through messageID active. The new leaf is
+// the most recent continuation below messageID, so switching to an earlier
+// sibling restores where that branch left off.
+func (h *HistoryManager) SwitchBranch(ctx context.Context, owner, id, messageID string) (Conversation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.getOwned(ctx, owner, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	msgs, err := h.store.Messages(ctx, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if _, ok := indexByID(msgs)[messageID]; !ok {
+		return Conversation{}, ErrMessageNotFound
+	}
+
+	c.CurrentLeaf = latestLeaf(msgs, messageID)
+	c.UpdatedAt = time.Now().UTC()
+	if err := h.store.SaveConversation(ctx, c); err != nil {
+		return Conversation{}, err
+	}
+	return c, nil
+}
+
+// GetMessage returns a single message of the conversation by ID.
+func (h *HistoryManager) GetMessage(ctx context.Context, id, messageID string) (Message, error) {
+	msgs, err := h.store.Messages(ctx, id)
+	if err != nil {
+		return Message{}, err
+	}
+	m, ok := indexByID(msgs)[messageID]
+	if !ok {
+		return Message{}, ErrMessageNotFound
+	}
+	return m, nil
+}
+
+// GetContext returns the most recent messages of the active branch, capped
+// at maxHistory, for sending to the model.
+func (h *HistoryManager) GetContext(ctx context.Context, id string) ([]Message, error) {
+	c, err := h.store.GetConversation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return h.GetContextAt(ctx, id, c.CurrentLeaf)
+}
+
+// GetContextAt is GetContext for the branch ending at leafID, which need not
+// be the active one.
+func (h *HistoryManager) GetContextAt(ctx context.Context, id, leafID string) ([]Message, error) {
+	all, err := h.store.Messages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	msgs := branchTo(all, leafID)
+
+	total := len(msgs)
 	start := 0
 	if total > maxHistory {
 		start = total - maxHistory
 	}
+	return msgs[start:], nil
+}
+
+// GetAll returns the active branch of the conversation, root first.
+func (h *HistoryManager) GetAll(ctx context.Context, id string) ([]Message, error) {
+	c, err := h.store.GetConversation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := h.store.Messages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return branchTo(msgs, c.CurrentLeaf), nil
+}
 
-	result := make([]Message, total-start)
-	copy(result, h.messages[start:])
-	return result
+// GetTree returns every message of the conversation across all branches, in
+// the order they were added.
+func (h *HistoryManager) GetTree(ctx context.Context, id string) ([]Message, error) {
+	return h.store.Messages(ctx, id)
 }
 
-func (h *HistoryManager) GetAll() []Message {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+func indexByID(msgs []Message) map[string]Message {
+	index := make(map[string]Message, len(msgs))
+	for _, m := range msgs {
+		index[m.ID] = m
+	}
+	return index
+}
+
+// branchTo walks from leaf up to the root and returns the path root first.
+func branchTo(msgs []Message, leaf string) []Message {
+	index := indexByID(msgs)
+	var path []Message
+	for id := leaf; id != ""; {
+		m, ok := index[id]
+		if !ok {
+			break
+		}
+		path = append(path, m)
+		id = m.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	if path == nil {
+		path = make([]Message, 0)
+	}
+	return path
+}
+
+// latestLeaf follows the most recently added child from messageID down to a
+// leaf. msgs is in insertion order, so the last child seen is the newest.
+func latestLeaf(msgs []Message, messageID string) string {
+	newestChild := make(map[string]string)
+	for _, m := range msgs {
+		newestChild[m.ParentID] = m.ID
+	}
+	leaf := messageID
+	for {
+		child, ok := newestChild[leaf]
+		if !ok {
+			return leaf
+		}
+		leaf = child
+	}
+}
+
+// Search finds the owner's messages matching q across all conversations
+// and branches.
+func (h *HistoryManager) Search(ctx context.Context, owner string, q SearchQuery) ([]SearchHit, error) {
+	return h.store.Search(ctx, owner, q)
+}
 
-	result := make([]Message, len(h.messages))
-	copy(result, h.messages)
-	return result
+// CheckHealth reports whether history storage is usable.
+func (h *HistoryManager) CheckHealth(ctx context.Context) error {
+	return h.store.Ping(ctx)
 }
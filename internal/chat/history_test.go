@@ -1,45 +1,306 @@
 package chat
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"chat-service/internal/encryption"
 )
 
 func TestHistoryManager(t *testing.T) {
+	ctx := context.Background()
+
+	newConversation := func(t *testing.T, h *HistoryManager) string {
+		t.Helper()
+		c, err := h.CreateConversation(ctx, Conversation{Owner: "alice"})
+		if err != nil {
+			t.Fatalf("CreateConversation failed: %v", err)
+		}
+		return c.ID
+	}
+
 	t.Run("Add and Retrieve", func(t *testing.T) {
 		h := NewHistoryManager()
+		id := newConversation(t, h)
 		msg := Message{Role: RoleUser, Content: "Hello"}
-		h.AddMessage(msg)
+		h.AddMessage(ctx, id, msg)
 
-		ctx := h.GetContext()
-		if len(ctx) != 1 {
-			t.Fatalf("Expected 1 message, got %d", len(ctx))
+		ctxMsgs, _ := h.GetContext(ctx, id)
+		if len(ctxMsgs) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(ctxMsgs))
 		}
-		if ctx[0] != msg {
-			t.Errorf("Expected message %v, got %v", msg, ctx[0])
+		if ctxMsgs[0].Role != msg.Role || ctxMsgs[0].Content != msg.Content {
+			t.Errorf("Expected message %v, got %v", msg, ctxMsgs[0])
 		}
 	})
 
 	t.Run("Max History Truncation", func(t *testing.T) {
 		h := NewHistoryManager()
+		id := newConversation(t, h)
 		for i := 0; i < 25; i++ {
-			h.AddMessage(Message{Role: RoleUser, Content: fmt.Sprintf("msg %d", i)})
+			h.AddMessage(ctx, id, Message{Role: RoleUser, Content: fmt.Sprintf("msg %d", i)})
 		}
 
-		ctx := h.GetContext()
-		if len(ctx) != maxHistory {
-			t.Errorf("Expected %d messages, got %d", maxHistory, len(ctx))
+		ctxMsgs, _ := h.GetContext(ctx, id)
+		if len(ctxMsgs) != maxHistory {
+			t.Errorf("Expected %d messages, got %d", maxHistory, len(ctxMsgs))
 		}
 
 		// Check if it kept the *last* messages
-		lastMsg := ctx[maxHistory-1]
+		lastMsg := ctxMsgs[maxHistory-1]
 		if lastMsg.Content != "msg 24" {
 			t.Errorf("Expected last message 'msg 24', got '%s'", lastMsg.Content)
 		}
 
-		firstMsg := ctx[0]
+		firstMsg := ctxMsgs[0]
 		if firstMsg.Content != "msg 5" { // 0..24 is 25 items -> remove first 5 -> start at 5
 			t.Errorf("Expected first message 'msg 5', got '%s'", firstMsg.Content)
 		}
 	})
+
+	t.Run("Owner Scoping", func(t *testing.T) {
+		h := NewHistoryManager()
+		id := newConversation(t, h)
+
+		if _, err := h.GetConversation(ctx, "mallory", id); err != ErrConversationNotFound {
+			t.Errorf("Expected ErrConversationNotFound for other owner, got %v", err)
+		}
+		if err := h.DeleteConversation(ctx, "mallory", id); err != ErrConversationNotFound {
+			t.Errorf("Expected delete by other owner to fail, got %v", err)
+		}
+		if _, err := h.GetConversation(ctx, "alice", id); err != nil {
+			t.Errorf("Expected owner to see conversation, got %v", err)
+		}
+	})
+
+	t.Run("Update Metadata", func(t *testing.T) {
+		h := NewHistoryManager()
+		id := newConversation(t, h)
+		title, team, ticket := "Kafka tuning", "infra", "OPS-1"
+
+		h.UpdateConversation(ctx, "alice", id, ConversationPatch{
+			Title:    &title,
+			Metadata: map[string]*string{"team": &team, "ticket": &ticket},
+		})
+		c, err := h.UpdateConversation(ctx, "alice", id, ConversationPatch{
+			Metadata: map[string]*string{"ticket": nil},
+		})
+		if err != nil {
+			t.Fatalf("UpdateConversation failed: %v", err)
+		}
+		if c.Title != title {
+			t.Errorf("Expected title %q, got %q", title, c.Title)
+		}
+		if len(c.Metadata) != 1 || c.Metadata["team"] != "infra" {
+			t.Errorf("Expected only team metadata to remain, got %v", c.Metadata)
+		}
+	})
+
+	t.Run("List Pagination", func(t *testing.T) {
+		h := NewHistoryManager()
+		for i := 0; i < 5; i++ {
+			newConversation(t, h)
+		}
+		h.CreateConversation(ctx, Conversation{Owner: "bob"})
+
+		page, total, err := h.ListConversations(ctx, "alice", 3, 10)
+		if err != nil {
+			t.Fatalf("ListConversations failed: %v", err)
+		}
+		if total != 5 {
+			t.Errorf("Expected 5 conversations for alice, got %d", total)
+		}
+		if len(page) != 2 {
+			t.Errorf("Expected 2 conversations on last page, got %d", len(page))
+		}
+	})
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	h := NewHistoryManagerWithStore(store)
+	c, _ := h.CreateConversation(ctx, Conversation{Owner: "alice", Title: "persisted"})
+	h.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: "Hello"})
+	h.AddMessage(ctx, c.ID, Message{Role: RoleAssistant, Content: "Hi"})
+
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Reopening store failed: %v", err)
+	}
+	h = NewHistoryManagerWithStore(reopened)
+
+	got, err := h.GetConversation(ctx, "alice", c.ID)
+	if err != nil {
+		t.Fatalf("Expected conversation to survive restart, got %v", err)
+	}
+	if got.Title != "persisted" || got.MessageCount != 2 {
+		t.Errorf("Expected title and 2 messages, got %+v", got)
+	}
+
+	if err := h.DeleteConversation(ctx, "alice", c.ID); err != nil {
+		t.Fatalf("DeleteConversation failed: %v", err)
+	}
+	reopened, _ = NewFileStore(dir)
+	if _, err := reopened.GetConversation(ctx, c.ID); err != ErrConversationNotFound {
+		t.Errorf("Expected deleted conversation to stay deleted, got %v", err)
+	}
+}
+
+func TestFileStoreEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring := func(entries ...string) *encryption.Keyring {
+		t.Helper()
+		k, err := encryption.ParseKeyring(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	k1 := "k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, encryption.KeySize))
+	k2 := "k2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, encryption.KeySize))
+
+	// Start in plaintext, then turn encryption on.
+	plain, _ := NewFileStore(dir)
+	h := NewHistoryManagerWithStore(plain)
+	c, _ := h.CreateConversation(ctx, Conversation{Owner: "alice", Title: "Project Falcon", Metadata: map[string]string{"site": "Osprey Base"}})
+	h.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: "the launch code is 1234", Metadata: map[string]any{"template": map[string]any{"name": "launch", "variables": map[string]any{"target": "Kestrel"}}}})
+
+	if _, err := NewFileStore(dir, WithEncryption(keyring(k1))); err != nil {
+		t.Fatalf("Encrypting existing history failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, c.ID+".json"))
+	if bytes.Contains(data, []byte("launch code")) || bytes.Contains(data, []byte("Falcon")) || bytes.Contains(data, []byte("Osprey")) || bytes.Contains(data, []byte("Kestrel")) {
+		t.Fatalf("Expected no plaintext on disk, got %s", data)
+	}
+
+	if _, err := NewFileStore(dir); err == nil {
+		t.Error("Expected opening encrypted history without keys to fail")
+	}
+
+	// Rotate: k2 becomes primary, k1 is kept to unwrap existing data keys.
+	rotated, err := NewFileStore(dir, WithEncryption(keyring(k2, k1)))
+	if err != nil {
+		t.Fatalf("Opening with rotated keys failed: %v", err)
+	}
+	h = NewHistoryManagerWithStore(rotated)
+	h.AddMessage(ctx, c.ID, Message{Role: RoleAssistant, Content: "noted"})
+
+	// After one start with k2 primary, k1 can be retired.
+	retired, err := NewFileStore(dir, WithEncryption(keyring(k2)))
+	if err != nil {
+		t.Fatalf("Expected data keys to be re-wrapped under k2, got %v", err)
+	}
+	h = NewHistoryManagerWithStore(retired)
+	got, _ := h.GetConversation(ctx, "alice", c.ID)
+	msgs, _ := h.GetAll(ctx, c.ID)
+	if got.Title != "Project Falcon" || len(msgs) != 2 || msgs[0].Content != "the launch code is 1234" {
+		t.Errorf("Expected transparent decryption, got %+v %+v", got, msgs)
+	}
+	if tmpl, _ := msgs[0].Metadata["template"].(map[string]any); got.Metadata["site"] != "Osprey Base" || tmpl["name"] != "launch" {
+		t.Errorf("Expected metadata decrypted, got %+v %+v", got.Metadata, msgs[0].Metadata)
+	}
+	if hits, _ := h.Search(ctx, "alice", SearchQuery{Text: "launch"}); len(hits) != 1 {
+		t.Errorf("Expected decrypted content to be searchable, got %d hits", len(hits))
+	}
+}
+
+func TestHistoryManager_Branches(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistoryManager()
+	c, _ := h.CreateConversation(ctx, Conversation{Owner: "alice"})
+
+	q, _ := h.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: "Q"})
+	a1, _ := h.AddMessage(ctx, c.ID, Message{Role: RoleAssistant, Content: "A1"})
+	a2, err := h.AddBranch(ctx, c.ID, q.ID, Message{Role: RoleAssistant, Content: "A2"})
+	if err != nil {
+		t.Fatalf("AddBranch failed: %v", err)
+	}
+	if a2.ParentID != q.ID || a1.ParentID != q.ID {
+		t.Fatalf("Expected both answers to hang off the question, got %q and %q", a1.ParentID, a2.ParentID)
+	}
+
+	active, _ := h.GetAll(ctx, c.ID)
+	if len(active) != 2 || active[1].Content != "A2" {
+		t.Errorf("Expected new branch to be active, got %+v", active)
+	}
+	if tree, _ := h.GetTree(ctx, c.ID); len(tree) != 3 {
+		t.Errorf("Expected tree to keep all 3 messages, got %d", len(tree))
+	}
+
+	if _, err := h.SwitchBranch(ctx, "alice", c.ID, a1.ID); err != nil {
+		t.Fatalf("SwitchBranch failed: %v", err)
+	}
+	active, _ = h.GetAll(ctx, c.ID)
+	if active[1].Content != "A1" {
+		t.Errorf("Expected switched branch to be active, got %+v", active)
+	}
+
+	// Switching to an inner message follows its newest descendants.
+	c, _ = h.SwitchBranch(ctx, "alice", c.ID, q.ID)
+	if c.CurrentLeaf != a2.ID {
+		t.Errorf("Expected newest leaf under the question, got %q", c.CurrentLeaf)
+	}
+
+	if _, err := h.AddBranch(ctx, c.ID, "missing", Message{Role: RoleUser}); err != ErrMessageNotFound {
+		t.Errorf("Expected ErrMessageNotFound for unknown parent, got %v", err)
+	}
+	if _, err := h.SwitchBranch(ctx, "bob", c.ID, a1.ID); err != ErrConversationNotFound {
+		t.Errorf("Expected other owners to be rejected, got %v", err)
+	}
+}
+
+func TestHistoryManager_Fork(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistoryManager()
+	src, _ := h.CreateConversation(ctx, Conversation{Owner: "alice", Title: "Support", Persona: "support", Metadata: map[string]string{"team": "ops"}})
+	q, _ := h.AddMessage(ctx, src.ID, Message{Role: RoleUser, Content: "Q"})
+	a, _ := h.AddMessage(ctx, src.ID, Message{Role: RoleAssistant, Content: "A", Model: "m"})
+	h.AddMessage(ctx, src.ID, Message{Role: RoleUser, Content: "Q2"})
+
+	fork, err := h.ForkConversation(ctx, "alice", src.ID, a.ID, Conversation{Owner: "bob", Metadata: map[string]string{"experiment": "x"}})
+	if err != nil {
+		t.Fatalf("ForkConversation failed: %v", err)
+	}
+	if fork.ID == src.ID || fork.Owner != "bob" || fork.Title != "Support" || fork.Persona != "support" {
+		t.Errorf("Expected a new conversation for bob inheriting title and persona, got %+v", fork)
+	}
+	if fork.Metadata["team"] != "ops" || fork.Metadata["experiment"] != "x" {
+		t.Errorf("Expected merged metadata, got %+v", fork.Metadata)
+	}
+	if fork.ForkedFrom == nil || fork.ForkedFrom.ConversationID != src.ID || fork.ForkedFrom.MessageID != a.ID {
+		t.Errorf("Expected provenance pointing at the source, got %+v", fork.ForkedFrom)
+	}
+
+	msgs, _ := h.GetAll(ctx, fork.ID)
+	if len(msgs) != 2 || msgs[0].Content != "Q" || msgs[1].Content != "A" || msgs[1].Model != "m" {
+		t.Fatalf("Expected messages up to the fork point, got %+v", msgs)
+	}
+	if msgs[0].ID == q.ID || msgs[1].ParentID != msgs[0].ID || fork.CurrentLeaf != msgs[1].ID {
+		t.Errorf("Expected copies with fresh IDs chained together, got %+v", msgs)
+	}
+
+	// The fork is independent of its source.
+	h.AddMessage(ctx, fork.ID, Message{Role: RoleUser, Content: "other"})
+	if c, _ := h.GetConversation(ctx, "alice", src.ID); c.MessageCount != 3 {
+		t.Errorf("Expected source to be untouched, got %d messages", c.MessageCount)
+	}
+
+	if _, err := h.ForkConversation(ctx, "bob", src.ID, "", Conversation{}); err != ErrConversationNotFound {
+		t.Errorf("Expected forking another owner's conversation to fail, got %v", err)
+	}
+	if _, err := h.ForkConversation(ctx, "alice", src.ID, "missing", Conversation{}); err != ErrMessageNotFound {
+		t.Errorf("Expected unknown message to fail, got %v", err)
+	}
 }
@@ -1,19 +1,147 @@
 package chat
 
+import "time"
+
 type Role string
 
 const (
 	RoleUser      Role = "user"
 	RoleAssistant Role = "assistant"
 	RoleSystem    Role = "system"
+	// RoleTool carries a tool result back to the model. Tool messages only
+	// exist within a turn and are not stored.
+	RoleTool Role = "tool"
 )
 
 type Message struct {
-	Role    Role   `json:"role"`
-	Content string `json:"content"`
+	// ID is assigned when the message is stored and never changes.
+	ID string `json:"id,omitempty"`
+	// ParentID is the message this one follows; empty for a root. Edits and
+	// regenerations add siblings, turning the conversation into a tree.
+	ParentID  string    `json:"parent_id,omitempty"`
+	Role      Role      `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+
+	// Generation details, set on assistant messages.
+	Model        string `json:"model,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+	LatencyMS    int64  `json:"latency_ms,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Interrupted marks an assistant message whose generation was cut off,
+	// e.g. by the client disconnecting or the server shutting down.
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// ToolCalls are the calls an assistant message asks for, and
+	// ToolCallID names the call a tool message answers. Both only appear
+	// within a turn.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Usage is the token accounting reported by the model provider.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Chunk is one piece of a streamed completion. Content chunks come first;
+// the final chunk may carry only the model, finish reason, usage and the
+// tool calls the model made.
+type Chunk struct {
+	Content      string
+	Model        string
+	FinishReason string
+	Usage        *Usage
+	ToolCalls    []ToolCall
+}
+
+// Event is one item of a streamed answer: a piece of content, a refusal
+// that ends the answer early, progress of a tool call, citations, or the
+// structured output.
+type Event struct {
+	Content string
+	Refusal *Refusal
+	Tool    *ToolActivity
+	// Citations lists the passages the answer is grounded in; it comes
+	// before any content.
+	Citations []Citation
+	// Output comes after the content of an answer asked for as JSON.
+	Output *StructuredOutput
 }
 
 type ChatRequest struct {
 	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	// Stream selects an SSE response; only an explicit false asks for a
+	// single JSON response.
+	Stream         *bool           `json:"stream,omitempty"`
+	Persona        string          `json:"persona,omitempty"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Template writes the user message from a prompt template.
+	Template *TemplateRef `json:"template,omitempty"`
+}
+
+// Conversation describes a stored conversation. Messages are kept
+// separately and fetched through HistoryManager.
+type Conversation struct {
+	ID       string            `json:"id"`
+	Owner    string            `json:"owner"`
+	Title    string            `json:"title"`
+	Persona  string            `json:"persona,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// CurrentLeaf is the last message of the active branch.
+	CurrentLeaf string `json:"current_leaf,omitempty"`
+	// ForkedFrom is set on conversations created by forking another one.
+	ForkedFrom   *Provenance `json:"forked_from,omitempty"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	MessageCount int         `json:"message_count"`
+}
+
+// Provenance records which conversation, up to which message, a fork was
+// copied from.
+type Provenance struct {
+	ConversationID string    `json:"conversation_id"`
+	MessageID      string    `json:"message_id,omitempty"`
+	ForkedAt       time.Time `json:"forked_at"`
+}
+
+// ConversationPatch holds the fields of a partial conversation update. Nil
+// fields are left unchanged; a nil value in Metadata deletes that key.
+type ConversationPatch struct {
+	Title    *string            `json:"title"`
+	Persona  *string            `json:"persona"`
+	Metadata map[string]*string `json:"metadata"`
+}
+
+// Prompt is a single user turn submitted to the Service.
+type Prompt struct {
+	Content string
+	// Owner is the caller's identity; conversations are only visible to
+	// their owner.
+	Owner string
+	// ConversationID selects the conversation; empty means the owner's
+	// default conversation.
+	ConversationID string
+	// Persona selects the assistant configuration; empty means the
+	// conversation's persona, then the default.
+	Persona string
+	// Format asks for the answer as JSON; nil leaves it free.
+	Format *ResponseFormat
+	// Template, when set, writes Content from a template; Content, if
+	// any, follows the rendered text.
+	Template *TemplateRef
+}
+
+// Persona is a named assistant configuration applied to a chat.
+type Persona struct {
+	Name         string
+	SystemPrompt string
+	// ToolSources enables tools from these sources, e.g. MCP servers, for
+	// chats with this persona. Tools without a source are always offered.
+	ToolSources []string
 }
@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ModerationStage says whether text under review is a user message or the
+// model's answer.
+type ModerationStage string
+
+const (
+	StageInput  ModerationStage = "input"
+	StageOutput ModerationStage = "output"
+)
+
+// Verdict is a moderator's decision on a piece of text.
+type Verdict struct {
+	Flagged    bool
+	Categories []string
+	Reason     string
+}
+
+// Moderator reviews text before it reaches the model or the user.
+type Moderator interface {
+	Moderate(ctx context.Context, stage ModerationStage, text string) (Verdict, error)
+}
+
+// Moderators runs several moderators in order and returns the first flag.
+type Moderators []Moderator
+
+func (ms Moderators) Moderate(ctx context.Context, stage ModerationStage, text string) (Verdict, error) {
+	for _, m := range ms {
+		v, err := m.Moderate(ctx, stage, text)
+		if err != nil || v.Flagged {
+			return v, err
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Refusal explains why moderation stopped a message. It is sent to the
+// client in place of, or after part of, an answer.
+type Refusal struct {
+	Stage      ModerationStage `json:"stage"`
+	Categories []string        `json:"categories,omitempty"`
+	Reason     string          `json:"reason"`
+}
+
+// RefusalError is returned when a user message is refused before it is
+// sent to the model.
+type RefusalError struct {
+	Refusal Refusal
+}
+
+func (e *RefusalError) Error() string {
+	return "message refused by moderation: " + e.Refusal.Reason
+}
+
+// defaultModerationWindow is how much of an answer, in bytes, is held back
+// and reviewed at a time.
+const defaultModerationWindow = 200
+
+// moderationOverlap is how much already approved text is reviewed again
+// with the next window, so a phrase split across windows is still caught.
+const moderationOverlap = 64
+
+// moderator returns the moderator for this turn, or nil when moderation is
+// off.
+func (s *Service) moderator() Moderator {
+	if s.moderators == nil {
+		return nil
+	}
+	return s.moderators()
+}
+
+// moderate reviews text as the model sees it, after redaction. A moderator
+// error fails closed: the text is refused.
+func (s *Service) moderate(ctx context.Context, m Moderator, stage ModerationStage, text string) (*Refusal, error) {
+	v, err := m.Moderate(ctx, stage, text)
+	if err != nil {
+		return &Refusal{Stage: stage, Reason: "moderation unavailable"}, fmt.Errorf("moderation failed: %w", err)
+	}
+	if !v.Flagged {
+		return nil, nil
+	}
+	reason := v.Reason
+	if reason == "" {
+		reason = "content violates policy: " + strings.Join(v.Categories, ", ")
+	}
+	return &Refusal{Stage: stage, Categories: v.Categories, Reason: reason}, nil
+}
+
+// moderateInput refuses prompt content that moderation flags.
+func (s *Service) moderateInput(ctx context.Context, content string) error {
+	m := s.moderator()
+	if m == nil {
+		return nil
+	}
+	if r := s.currentRedactor(); r != nil {
+		content = r.NewSession().Redact(content)
+	}
+	refusal, err := s.moderate(ctx, m, StageInput, content)
+	if err != nil {
+		slog.ErrorContext(ctx, "Input moderation failed", "error", err)
+	}
+	if refusal != nil {
+		return &RefusalError{Refusal: *refusal}
+	}
+	return nil
+}
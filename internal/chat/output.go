@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"chat-service/internal/jsonschema"
+)
+
+// Response format types, as in OpenAI's response_format.
+const (
+	FormatText       = "text"
+	FormatJSONObject = "json_object"
+	FormatJSONSchema = "json_schema"
+)
+
+const (
+	defaultOutputRetries = 2
+	// maxSchemaBytes bounds a schema sent with a request.
+	maxSchemaBytes = 64 << 10
+)
+
+// ErrInvalidResponseFormat is returned when a prompt asks for an unknown
+// response format or carries a schema that does not compile.
+var ErrInvalidResponseFormat = errors.New("invalid response format")
+
+// ResponseFormat asks for the answer as JSON: any object for
+// FormatJSONObject, or a value matching JSONSchema for FormatJSONSchema.
+// FormatText, like a nil format, leaves the answer free.
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat names and describes the schema an answer must match.
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	// Strict asks providers that support it to constrain generation to
+	// the schema. The answer is validated either way.
+	Strict bool `json:"strict,omitempty"`
+}
+
+// FormatLLMClient is a ToolLLMClient that can ask the provider for a
+// response format, where the provider supports one.
+type FormatLLMClient interface {
+	ToolLLMClient
+	StreamChatWithFormat(ctx context.Context, messages []Message, tools []ToolDefinition, format *ResponseFormat) (<-chan Chunk, error)
+}
+
+// StructuredOutput reports the JSON an answer was asked for. It is streamed
+// after the answer and recorded in its metadata under "output".
+type StructuredOutput struct {
+	// Valid is true when Value parsed and matched the schema.
+	Valid bool            `json:"valid"`
+	Value json.RawMessage `json:"value,omitempty"`
+	// Errors lists what was wrong with the last attempt.
+	Errors []string `json:"errors,omitempty"`
+	// Attempts counts the answers the model gave, the first included.
+	Attempts int `json:"attempts"`
+}
+
+// WithOutputRetries lets the model try again up to retries times when an
+// answer does not match the requested format, with the problems found fed
+// back to it; 0 turns retries off. Without it, the default applies.
+func WithOutputRetries(retries int) Option {
+	return func(s *Service) {
+		s.outputRetries = max(retries, 0)
+	}
+}
+
+// outputFormat checks f and compiles its schema. It returns a nil format
+// when no structure is asked for, and a nil schema for FormatJSONObject.
+func outputFormat(f *ResponseFormat) (*ResponseFormat, *jsonschema.Schema, error) {
+	if f == nil {
+		return nil, nil, nil
+	}
+	switch f.Type {
+	case "", FormatText:
+		return nil, nil, nil
+	case FormatJSONObject:
+		return f, nil, nil
+	case FormatJSONSchema:
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown type %q", ErrInvalidResponseFormat, f.Type)
+	}
+	if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+		return nil, nil, fmt.Errorf("%w: json_schema.schema is required", ErrInvalidResponseFormat)
+	}
+	if !toolName.MatchString(f.JSONSchema.Name) {
+		return nil, nil, fmt.Errorf("%w: json_schema.name must be up to 64 letters, digits, _ or -", ErrInvalidResponseFormat)
+	}
+	if len(f.JSONSchema.Schema) > maxSchemaBytes {
+		return nil, nil, fmt.Errorf("%w: schema is larger than %d bytes", ErrInvalidResponseFormat, maxSchemaBytes)
+	}
+	schema, err := jsonschema.Compile(f.JSONSchema.Schema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidResponseFormat, err)
+	}
+	return f, schema, nil
+}
+
+// formatInstruction tells the model how to answer, for providers that do
+// not take a response format and as a reminder for those that do.
+func formatInstruction(f *ResponseFormat) string {
+	if f.Type == FormatJSONObject {
+		return "Answer with a single JSON object and nothing else: no prose and no code fences."
+	}
+	var sb strings.Builder
+	sb.WriteString("Answer with a single JSON value and nothing else: no prose and no code fences. It must match this JSON Schema")
+	if f.JSONSchema.Description != "" {
+		fmt.Fprintf(&sb, ", which describes %s", f.JSONSchema.Description)
+	}
+	sb.WriteString(":\n")
+	var compact bytes.Buffer
+	if json.Compact(&compact, f.JSONSchema.Schema) == nil {
+		sb.Write(compact.Bytes())
+	} else {
+		sb.Write(f.JSONSchema.Schema)
+	}
+	return sb.String()
+}
+
+// parseOutput extracts the JSON from an answer, ignoring code fences around
+// it, and checks it against schema, or that it is an object without one.
+// It returns the extracted text along with the result.
+func parseOutput(answer string, schema *jsonschema.Schema) (string, StructuredOutput) {
+	text := strings.TrimSpace(answer)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		// Drop the info string, e.g. "json", and the closing fence.
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text, StructuredOutput{Errors: []string{"the answer is not valid JSON: " + err.Error()}}
+	}
+	var err error
+	if schema != nil {
+		err = schema.Validate(value)
+	} else if _, ok := value.(map[string]any); !ok {
+		err = errors.New("/: must be a JSON object")
+	}
+	if err != nil {
+		return text, StructuredOutput{Errors: jsonschema.Problems(err)}
+	}
+	return text, StructuredOutput{Valid: true, Value: json.RawMessage(text)}
+}
+
+// retryPrompt asks the model to correct an answer that did not match.
+func retryPrompt(problems []string) string {
+	return "Your answer does not match the required format:\n- " + strings.Join(problems, "\n- ") +
+		"\nAnswer again with only the corrected JSON."
+}
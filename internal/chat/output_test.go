@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// formatLLM is a scriptedLLM that takes a response format.
+type formatLLM struct {
+	scriptedLLM
+	formats []*ResponseFormat
+}
+
+func (m *formatLLM) StreamChatWithFormat(ctx context.Context, messages []Message, tools []ToolDefinition, format *ResponseFormat) (<-chan Chunk, error) {
+	m.formats = append(m.formats, format)
+	return m.StreamChatWithTools(ctx, messages, tools)
+}
+
+var cityFormat = &ResponseFormat{Type: FormatJSONSchema, JSONSchema: &JSONSchemaFormat{
+	Name:   "city",
+	Schema: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"},"population":{"type":"integer"}},"required":["name","population"]}`),
+}}
+
+func answer(text string) []Chunk {
+	return []Chunk{{Content: text}, {Model: "m", FinishReason: "stop"}}
+}
+
+func TestService_StructuredOutput(t *testing.T) {
+	ctx := context.Background()
+	llm := &formatLLM{scriptedLLM: scriptedLLM{script: [][]Chunk{
+		answer("Sure! Here it is."),
+		answer(`{"name":"Oslo","population":"lots"}`),
+		answer("```json\n{\"name\":\"Oslo\",\"population\":709000}\n```"),
+	}}}
+	s := NewService(NewHistoryManager(), llm)
+
+	stream, err := s.ProcessMessage(ctx, Prompt{Content: "Capital of Norway?", Format: cityFormat})
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	var content strings.Builder
+	var output *StructuredOutput
+	for ev := range stream {
+		content.WriteString(ev.Content)
+		if ev.Output != nil {
+			output = ev.Output
+		}
+	}
+
+	want := `{"name":"Oslo","population":709000}`
+	if content.String() != want {
+		t.Errorf("Expected only the valid JSON sent, got %q", content.String())
+	}
+	if output == nil || !output.Valid || string(output.Value) != want || output.Attempts != 3 {
+		t.Fatalf("Expected a valid output after 3 attempts, got %+v", output)
+	}
+	if len(llm.formats) != 3 || llm.formats[0] != cityFormat {
+		t.Errorf("Expected the format passed on every call, got %v", llm.formats)
+	}
+	first := llm.calls[0]
+	if last := first[len(first)-1]; last.Role != RoleSystem || !strings.Contains(last.Content, `"population"`) {
+		t.Errorf("Expected the schema in a system instruction, got %+v", last)
+	}
+	retry := llm.calls[2]
+	if last := retry[len(retry)-1]; last.Role != RoleUser || !strings.Contains(last.Content, "/population: must be integer, not string") {
+		t.Errorf("Expected the validation errors fed back, got %+v", last)
+	}
+
+	history, _ := s.GetHistory(ctx, "", "")
+	if got, _ := history[1].Metadata["output"].(*StructuredOutput); got != output || history[1].Content != want {
+		t.Errorf("Expected the output recorded with the answer, got %+v", history[1])
+	}
+}
+
+func TestService_StructuredOutputGivesUp(t *testing.T) {
+	llm := &scriptedLLM{script: [][]Chunk{answer("[1, 2]")}}
+	s := NewService(NewHistoryManager(), llm, WithOutputRetries(1))
+
+	stream, err := s.ProcessMessage(context.Background(), Prompt{Content: "List", Format: &ResponseFormat{Type: FormatJSONObject}})
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	var output *StructuredOutput
+	for ev := range stream {
+		if ev.Output != nil {
+			output = ev.Output
+		}
+	}
+	if output == nil || output.Valid || output.Value != nil || output.Attempts != 2 || len(llm.calls) != 2 {
+		t.Errorf("Expected an invalid output after one retry, got %+v", output)
+	}
+}
+
+func TestService_InvalidResponseFormat(t *testing.T) {
+	s := NewService(NewHistoryManager(), &MockLLM{})
+	for _, f := range []*ResponseFormat{
+		{Type: "xml"},
+		{Type: FormatJSONSchema},
+		{Type: FormatJSONSchema, JSONSchema: &JSONSchemaFormat{Name: "bad name", Schema: json.RawMessage(`{}`)}},
+		{Type: FormatJSONSchema, JSONSchema: &JSONSchemaFormat{Name: "s", Schema: json.RawMessage(`{"type":"text"}`)}},
+	} {
+		if _, err := s.ProcessMessage(context.Background(), Prompt{Content: "hi", Format: f}); !errors.Is(err, ErrInvalidResponseFormat) {
+			t.Errorf("Expected ErrInvalidResponseFormat for %+v, got %v", f, err)
+		}
+	}
+	if history, _ := s.GetHistory(context.Background(), "", ""); len(history) != 0 {
+		t.Errorf("Expected nothing stored, got %d messages", len(history))
+	}
+}
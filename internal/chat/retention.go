@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionPolicy limits how much history is kept. A zero limit is not
+// enforced.
+type RetentionPolicy struct {
+	// MaxAge deletes conversations not updated for longer than this.
+	MaxAge time.Duration
+	// MaxMessages trims conversations to their newest messages.
+	MaxMessages int
+	// MaxConversations deletes an owner's least recently updated
+	// conversations beyond this many.
+	MaxConversations int
+	// SweepInterval is how often RunRetention enforces the policy.
+	SweepInterval time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxMessages > 0 || p.MaxConversations > 0
+}
+
+// SweepResult counts what a retention sweep removed.
+type SweepResult struct {
+	Conversations int
+	Messages      int
+}
+
+// RunRetention enforces the current policy every SweepInterval until ctx is
+// cancelled. The policy is read again before each sweep so reloaded limits
+// apply without a restart. Sweeps that remove something, or fail, are
+// reported to onSweep.
+func (h *HistoryManager) RunRetention(ctx context.Context, policy func() RetentionPolicy, onSweep func(SweepResult, error)) {
+	for {
+		p := policy()
+		timer := time.NewTimer(p.SweepInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		p = policy()
+		if !p.enabled() {
+			continue
+		}
+		res, err := h.Sweep(ctx, p, time.Now().UTC())
+		if err != nil || res.Conversations > 0 || res.Messages > 0 {
+			onSweep(res, err)
+		}
+	}
+}
+
+// Sweep applies p to every owner's conversations as of now. Conversations
+// that are too old or beyond the owner's limit are deleted; the rest are
+// trimmed to p.MaxMessages.
+func (h *HistoryManager) Sweep(ctx context.Context, p RetentionPolicy, now time.Time) (SweepResult, error) {
+	var res SweepResult
+	owners, err := h.store.Owners(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, owner := range owners {
+		if err := h.sweepOwner(ctx, owner, p, now, &res); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (h *HistoryManager) sweepOwner(ctx context.Context, owner string, p RetentionPolicy, now time.Time, res *SweepResult) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	convs, err := h.store.ListConversations(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for i, c := range convs {
+		expired := p.MaxAge > 0 && now.Sub(c.UpdatedAt) > p.MaxAge
+		excess := p.MaxConversations > 0 && i >= p.MaxConversations
+		if expired || excess {
+			if err := h.store.DeleteConversation(ctx, c.ID); err != nil && err != ErrConversationNotFound {
+				return err
+			}
+			res.Conversations++
+			res.Messages += c.MessageCount
+			continue
+		}
+		if p.MaxMessages > 0 && c.MessageCount > p.MaxMessages {
+			n, err := h.trimLocked(ctx, c, p.MaxMessages)
+			if err != nil {
+				return err
+			}
+			res.Messages += n
+		}
+	}
+	return nil
+}
+
+// trimLocked drops the oldest messages of c so that at most keep remain,
+// and returns how many were dropped. Parents are always added before their
+// replies, so a kept message whose parent was dropped becomes a root when
+// it is a question; a reply is dropped with its question, so that no
+// branch starts with an answer. If the active branch is gone, the newest
+// remaining branch becomes active.
+func (h *HistoryManager) trimLocked(ctx context.Context, c Conversation, keep int) (int, error) {
+	msgs, err := h.store.Messages(ctx, c.ID)
+	if err != nil {
+		return 0, err
+	}
+	drop := len(msgs) - keep
+	if drop <= 0 {
+		return 0, nil
+	}
+
+	dropped := make(map[string]bool, drop)
+	for _, m := range msgs[:drop] {
+		dropped[m.ID] = true
+	}
+	kept := make([]Message, 0, keep)
+	for _, m := range msgs[drop:] {
+		if dropped[m.ParentID] {
+			if m.Role != RoleUser {
+				dropped[m.ID] = true
+				continue
+			}
+			m.ParentID = ""
+		}
+		kept = append(kept, m)
+	}
+	if err := h.store.ReplaceMessages(ctx, c.ID, kept); err != nil {
+		return 0, err
+	}
+	if dropped[c.CurrentLeaf] {
+		c.CurrentLeaf = latestLeaf(kept, "")
+	}
+	return len(msgs) - len(kept), h.store.SaveConversation(ctx, c)
+}
+
+// ErasureReceipt records what an erasure deleted or anonymised, so it can
+// be confirmed to the data subject and audited later.
+type ErasureReceipt struct {
+	ID              string   `json:"receipt_id"`
+	Identity        string   `json:"identity"`
+	ConversationIDs []string `json:"conversation_ids"`
+	Conversations   int      `json:"conversations_deleted"`
+	Messages        int      `json:"messages_deleted"`
+	// AnonymisedDocuments are shared documents the identity uploaded; they
+	// are kept without their uploader.
+	AnonymisedDocuments []string `json:"anonymised_document_ids"`
+	// AnonymisedTemplates are shared templates with versions the identity
+	// saved; the versions are kept without their author.
+	AnonymisedTemplates []string  `json:"anonymised_templates"`
+	ErasedAt            time.Time `json:"erased_at"`
+}
+
+// EraseOwner deletes every conversation of owner along with all of its
+// messages and search index entries.
+func (h *HistoryManager) EraseOwner(ctx context.Context, owner string) (ErasureReceipt, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	receipt := ErasureReceipt{
+		ID:                  newID(),
+		Identity:            owner,
+		ConversationIDs:     make([]string, 0),
+		AnonymisedDocuments: make([]string, 0),
+		AnonymisedTemplates: make([]string, 0),
+	}
+	convs, err := h.store.ListConversations(ctx, owner)
+	if err != nil {
+		return ErasureReceipt{}, err
+	}
+	for _, c := range convs {
+		if err := h.store.DeleteConversation(ctx, c.ID); err != nil && err != ErrConversationNotFound {
+			return ErasureReceipt{}, err
+		}
+		receipt.ConversationIDs = append(receipt.ConversationIDs, c.ID)
+		receipt.Conversations++
+		receipt.Messages += c.MessageCount
+	}
+	receipt.ErasedAt = time.Now().UTC()
+	return receipt, nil
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+
+	newConversation := func(t *testing.T, h *HistoryManager, owner string, messages int) string {
+		t.Helper()
+		c, err := h.CreateConversation(ctx, Conversation{Owner: owner})
+		if err != nil {
+			t.Fatalf("CreateConversation failed: %v", err)
+		}
+		for i := 0; i < messages; i++ {
+			h.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: fmt.Sprintf("msg %d", i)})
+		}
+		return c.ID
+	}
+
+	t.Run("Max Age", func(t *testing.T) {
+		h := NewHistoryManager()
+		id := newConversation(t, h, "alice", 2)
+
+		res, err := h.Sweep(ctx, RetentionPolicy{MaxAge: time.Hour}, time.Now().Add(30*time.Minute))
+		if err != nil || res.Conversations != 0 {
+			t.Fatalf("Expected nothing removed within max age, got %+v, %v", res, err)
+		}
+		res, _ = h.Sweep(ctx, RetentionPolicy{MaxAge: time.Hour}, time.Now().Add(2*time.Hour))
+		if res.Conversations != 1 || res.Messages != 2 {
+			t.Errorf("Expected 1 conversation and 2 messages removed, got %+v", res)
+		}
+		if _, err := h.GetConversation(ctx, "alice", id); err != ErrConversationNotFound {
+			t.Errorf("Expected expired conversation to be gone, got %v", err)
+		}
+	})
+
+	t.Run("Max Conversations", func(t *testing.T) {
+		h := NewHistoryManager()
+		now := time.Now()
+		for i, owner := range []string{"alice", "alice", "alice", "bob"} {
+			h.store.SaveConversation(ctx, Conversation{ID: fmt.Sprint("c", i), Owner: owner, UpdatedAt: now.Add(time.Duration(i) * time.Minute)})
+		}
+		oldest := "c0"
+
+		res, _ := h.Sweep(ctx, RetentionPolicy{MaxConversations: 2}, now)
+		if res.Conversations != 1 {
+			t.Errorf("Expected 1 conversation removed, got %+v", res)
+		}
+		if _, err := h.GetConversation(ctx, "alice", oldest); err != ErrConversationNotFound {
+			t.Errorf("Expected the least recently updated conversation to be removed, got %v", err)
+		}
+		if _, total, _ := h.ListConversations(ctx, "bob", 0, 10); total != 1 {
+			t.Errorf("Expected bob's conversation to be kept, got %d", total)
+		}
+	})
+
+	t.Run("Max Messages", func(t *testing.T) {
+		h := NewHistoryManager()
+		id := newConversation(t, h, "alice", 5)
+
+		res, _ := h.Sweep(ctx, RetentionPolicy{MaxMessages: 3}, time.Now())
+		if res.Messages != 2 {
+			t.Errorf("Expected 2 messages removed, got %+v", res)
+		}
+		msgs, _ := h.GetAll(ctx, id)
+		if len(msgs) != 3 || msgs[0].Content != "msg 2" || msgs[0].ParentID != "" {
+			t.Errorf("Expected the newest 3 messages rooted at 'msg 2', got %+v", msgs)
+		}
+		if hits, _ := h.Search(ctx, "alice", SearchQuery{Text: "0"}); len(hits) != 0 {
+			t.Errorf("Expected trimmed messages to leave the search index, got %+v", hits)
+		}
+	})
+
+	t.Run("Max Messages keeps answers with their questions", func(t *testing.T) {
+		h := NewHistoryManager()
+		c, _ := h.CreateConversation(ctx, Conversation{Owner: "alice"})
+		for i, role := range []Role{RoleUser, RoleAssistant, RoleUser, RoleAssistant, RoleUser} {
+			h.AddMessage(ctx, c.ID, Message{Role: role, Content: fmt.Sprintf("msg %d", i)})
+		}
+
+		res, _ := h.Sweep(ctx, RetentionPolicy{MaxMessages: 4}, time.Now())
+		if res.Messages != 2 {
+			t.Errorf("Expected the question and its answer removed, got %+v", res)
+		}
+		msgs, _ := h.GetAll(ctx, c.ID)
+		if len(msgs) != 3 || msgs[0].Content != "msg 2" || msgs[0].ParentID != "" || msgs[2].Content != "msg 4" {
+			t.Errorf("Expected the branch to start at the question 'msg 2', got %+v", msgs)
+		}
+	})
+}
+
+func TestEraseOwner(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistoryManager()
+	for _, owner := range []string{"alice", "alice", "bob"} {
+		c, _ := h.CreateConversation(ctx, Conversation{Owner: owner})
+		h.AddMessage(ctx, c.ID, Message{Role: RoleUser, Content: "remember me"})
+	}
+
+	receipt, err := h.EraseOwner(ctx, "alice")
+	if err != nil {
+		t.Fatalf("EraseOwner failed: %v", err)
+	}
+	if receipt.ID == "" || receipt.Identity != "alice" || receipt.Conversations != 2 || receipt.Messages != 2 || len(receipt.ConversationIDs) != 2 {
+		t.Errorf("Unexpected receipt %+v", receipt)
+	}
+	if _, total, _ := h.ListConversations(ctx, "alice", 0, 10); total != 0 {
+		t.Errorf("Expected no conversations left for alice, got %d", total)
+	}
+	if hits, _ := h.Search(ctx, "bob", SearchQuery{Text: "remember"}); len(hits) != 1 {
+		t.Errorf("Expected bob's data to be kept, got %d hits", len(hits))
+	}
+}
@@ -0,0 +1,336 @@
+package chat
+
+import (
+	"errors"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrEmptyQuery is returned when a search query has no searchable terms.
+var ErrEmptyQuery = errors.New("search query has no terms")
+
+const (
+	defaultSearchLimit = 20
+	// snippetContext is roughly how many bytes of text a snippet shows
+	// around the first match.
+	snippetContext = 80
+)
+
+// SearchQuery selects messages. Text holds words and "quoted phrases"; a
+// message must contain all of them. The remaining fields are optional
+// filters.
+type SearchQuery struct {
+	Text           string
+	Role           Role
+	ConversationID string
+	// From and To bound the message creation time, both inclusive.
+	From, To time.Time
+	Limit    int
+}
+
+// SearchHit is a message matching a query.
+type SearchHit struct {
+	ConversationID    string    `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	MessageID         string    `json:"message_id"`
+	Role              Role      `json:"role"`
+	CreatedAt         time.Time `json:"created_at"`
+	// Snippet is an HTML-escaped excerpt with matches wrapped in <mark>.
+	Snippet string `json:"snippet"`
+	// Highlights are the byte ranges of the matches in the full content.
+	Highlights []TextRange `json:"highlights"`
+	Score      float64     `json:"score"`
+}
+
+// TextRange is a half-open byte range [Start, End) of a string.
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits s into lowercase words of letters and digits, keeping
+// their byte offsets for highlighting.
+func tokenize(s string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return tokens
+}
+
+// parseQuery splits query text into phrases; a bare word is a phrase of one
+// term. An unterminated quote runs to the end of the text.
+func parseQuery(text string) [][]string {
+	var phrases [][]string
+	add := func(s string, asPhrase bool) {
+		var terms []string
+		for _, t := range tokenize(s) {
+			terms = append(terms, t.term)
+		}
+		if asPhrase {
+			if len(terms) > 0 {
+				phrases = append(phrases, terms)
+			}
+			return
+		}
+		for _, t := range terms {
+			phrases = append(phrases, []string{t})
+		}
+	}
+	for i, part := range strings.Split(text, `"`) {
+		add(part, i%2 == 1)
+	}
+	return phrases
+}
+
+type docRef struct {
+	conversationID, messageID string
+}
+
+// searchIndex is an inverted index from terms to the positions at which
+// they occur in each message of one owner. It is not safe for concurrent use; the
+// owning store guards it.
+type searchIndex struct {
+	postings map[string]map[docRef][]int
+	terms    map[docRef][]string // distinct terms per message, for removal
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[docRef][]int),
+		terms:    make(map[docRef][]string),
+	}
+}
+
+func (x *searchIndex) add(conversationID string, m Message) {
+	if m.ID == "" {
+		return
+	}
+	ref := docRef{conversationID, m.ID}
+	x.remove(ref)
+	for pos, t := range tokenize(m.Content) {
+		docs := x.postings[t.term]
+		if docs == nil {
+			docs = make(map[docRef][]int)
+			x.postings[t.term] = docs
+		}
+		if docs[ref] == nil {
+			x.terms[ref] = append(x.terms[ref], t.term)
+		}
+		docs[ref] = append(docs[ref], pos)
+	}
+}
+
+func (x *searchIndex) remove(ref docRef) {
+	for _, term := range x.terms[ref] {
+		delete(x.postings[term], ref)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	delete(x.terms, ref)
+}
+
+// match returns the messages containing every phrase, each with the token
+// positions that matched and a tf-idf score.
+func (x *searchIndex) match(phrases [][]string) map[docRef]*indexMatch {
+	var result map[docRef]*indexMatch
+	for _, phrase := range phrases {
+		found := x.matchPhrase(phrase)
+		if result == nil {
+			result = found
+		} else {
+			for ref, m := range result {
+				f, ok := found[ref]
+				if !ok {
+					delete(result, ref)
+					continue
+				}
+				m.positions = append(m.positions, f.positions...)
+				m.score += f.score
+			}
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+type indexMatch struct {
+	positions []int // token positions to highlight
+	score     float64
+}
+
+func (x *searchIndex) matchPhrase(phrase []string) map[docRef]*indexMatch {
+	first := x.postings[phrase[0]]
+	result := make(map[docRef]*indexMatch)
+	for ref, starts := range first {
+		m := &indexMatch{}
+		for _, p := range starts {
+			if x.phraseAt(ref, phrase, p) {
+				for i := range phrase {
+					m.positions = append(m.positions, p+i)
+				}
+				m.score++
+			}
+		}
+		if m.score > 0 {
+			result[ref] = m
+		}
+	}
+	// Weight by rarity so a match on an unusual phrase ranks above one on
+	// a word every message contains.
+	idf := math.Log(1 + float64(len(x.terms))/float64(len(result)+1))
+	for _, m := range result {
+		m.score *= idf
+	}
+	return result
+}
+
+func (x *searchIndex) phraseAt(ref docRef, phrase []string, start int) bool {
+	for i, term := range phrase[1:] {
+		positions := x.postings[term][ref]
+		want := start + i + 1
+		j := sort.SearchInts(positions, want)
+		if j == len(positions) || positions[j] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight returns the byte ranges of the tokens at positions and an
+// HTML-escaped snippet around the first of them.
+func highlight(content string, positions []int) ([]TextRange, string) {
+	tokens := tokenize(content)
+	sort.Ints(positions)
+	var ranges []TextRange
+	last := -2
+	for _, p := range positions {
+		if p >= len(tokens) || p == last {
+			continue
+		}
+		// Consecutive tokens, as in a phrase, share one range.
+		if p == last+1 {
+			ranges[len(ranges)-1].End = tokens[p].end
+		} else {
+			ranges = append(ranges, TextRange{tokens[p].start, tokens[p].end})
+		}
+		last = p
+	}
+	if len(ranges) == 0 {
+		return ranges, ""
+	}
+
+	from := runeStart(content, max(0, ranges[0].Start-snippetContext))
+	to := runeStart(content, min(len(content), ranges[0].End+2*snippetContext))
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	at := from
+	for _, r := range ranges {
+		if r.Start >= to {
+			break
+		}
+		end := min(r.End, to)
+		b.WriteString(html.EscapeString(content[at:r.Start]))
+		b.WriteString("<mark>" + html.EscapeString(content[r.Start:end]) + "</mark>")
+		at = end
+	}
+	b.WriteString(html.EscapeString(content[at:to]))
+	if to < len(content) {
+		b.WriteString("…")
+	}
+	return ranges, b.String()
+}
+
+// runeStart moves i back to the start of the rune containing it.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+// searchRecords resolves index matches against the records, applying the
+// ownership and query filters, and returns the best hits first.
+func searchRecords(records map[string]*conversationRecord, matches map[docRef]*indexMatch, owner string, q SearchQuery) []SearchHit {
+	hits := make([]SearchHit, 0)
+	for ref, m := range matches {
+		rec, ok := records[ref.conversationID]
+		if !ok || rec.Conversation.Owner != owner {
+			continue
+		}
+		if q.ConversationID != "" && ref.conversationID != q.ConversationID {
+			continue
+		}
+		msg, ok := findMessage(rec.Messages, ref.messageID)
+		if !ok || (q.Role != "" && msg.Role != q.Role) {
+			continue
+		}
+		if (!q.From.IsZero() && msg.CreatedAt.Before(q.From)) || (!q.To.IsZero() && msg.CreatedAt.After(q.To)) {
+			continue
+		}
+		ranges, snippet := highlight(msg.Content, m.positions)
+		hits = append(hits, SearchHit{
+			ConversationID:    ref.conversationID,
+			ConversationTitle: rec.Conversation.Title,
+			MessageID:         msg.ID,
+			Role:              msg.Role,
+			CreatedAt:         msg.CreatedAt,
+			Snippet:           snippet,
+			Highlights:        ranges,
+			Score:             m.score,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if !hits[i].CreatedAt.Equal(hits[j].CreatedAt) {
+			return hits[i].CreatedAt.After(hits[j].CreatedAt)
+		}
+		return hits[i].MessageID < hits[j].MessageID
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func findMessage(msgs []Message, id string) (Message, bool) {
+	for _, m := range msgs {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Message{}, false
+}
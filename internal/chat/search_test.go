@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	h := NewHistoryManager()
+
+	add := func(owner, title string, msgs ...Message) string {
+		t.Helper()
+		c, err := h.CreateConversation(ctx, Conversation{Owner: owner, Title: title})
+		if err != nil {
+			t.Fatalf("CreateConversation failed: %v", err)
+		}
+		for _, m := range msgs {
+			if _, err := h.AddMessage(ctx, c.ID, m); err != nil {
+				t.Fatalf("AddMessage failed: %v", err)
+			}
+		}
+		return c.ID
+	}
+
+	kafka := add("alice", "Kafka",
+		Message{Role: RoleUser, Content: "How do Kafka consumer groups rebalance?"},
+		Message{Role: RoleAssistant, Content: "A consumer group rebalances when members join or leave."},
+	)
+	add("alice", "Cooking", Message{Role: RoleUser, Content: "Group the vegetables by cooking time."})
+	add("bob", "Kafka too", Message{Role: RoleUser, Content: "Kafka consumer lag is growing."})
+
+	t.Run("Phrase", func(t *testing.T) {
+		hits, err := h.Search(ctx, "alice", SearchQuery{Text: `"consumer group"`})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(hits) != 1 || hits[0].Role != RoleAssistant {
+			t.Fatalf("Expected the assistant reply only, got %+v", hits)
+		}
+		want := "A <mark>consumer group</mark> rebalances when members join or leave."
+		if hits[0].Snippet != want {
+			t.Errorf("Expected snippet %q, got %q", want, hits[0].Snippet)
+		}
+		if len(hits[0].Highlights) != 1 || hits[0].Highlights[0] != (TextRange{2, 16}) {
+			t.Errorf("Expected one highlight at [2,16), got %v", hits[0].Highlights)
+		}
+	})
+
+	t.Run("Owner Scoping", func(t *testing.T) {
+		hits, _ := h.Search(ctx, "alice", SearchQuery{Text: "kafka"})
+		for _, hit := range hits {
+			if hit.ConversationID != kafka {
+				t.Errorf("Expected only alice's Kafka conversation, got %+v", hit)
+			}
+		}
+		if hits, _ := h.Search(ctx, "carol", SearchQuery{Text: "kafka"}); len(hits) != 0 {
+			t.Errorf("Expected no hits for another identity, got %d", len(hits))
+		}
+	})
+
+	t.Run("Scores Ignore Other Owners", func(t *testing.T) {
+		before, _ := h.Search(ctx, "alice", SearchQuery{Text: "kafka"})
+		add("bob", "More Kafka", Message{Role: RoleUser, Content: "Kafka again"}, Message{Role: RoleUser, Content: "Unrelated words"})
+		after, _ := h.Search(ctx, "alice", SearchQuery{Text: "kafka"})
+		if len(before) != 1 || len(after) != 1 || before[0].Score != after[0].Score {
+			t.Errorf("Expected bob's messages to leave alice's scores alone, got %+v then %+v", before, after)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		hits, _ := h.Search(ctx, "alice", SearchQuery{Text: "group", Role: RoleUser})
+		if len(hits) != 1 || hits[0].ConversationTitle != "Cooking" {
+			t.Errorf("Expected the cooking question only, got %+v", hits)
+		}
+		hits, _ = h.Search(ctx, "alice", SearchQuery{Text: "group", From: time.Now().Add(time.Hour)})
+		if len(hits) != 0 {
+			t.Errorf("Expected no hits after a future date, got %d", len(hits))
+		}
+		hits, _ = h.Search(ctx, "alice", SearchQuery{Text: "group", ConversationID: kafka, Limit: 1})
+		if len(hits) != 1 || hits[0].ConversationID != kafka {
+			t.Errorf("Expected one hit in the Kafka conversation, got %+v", hits)
+		}
+	})
+
+	t.Run("Empty Query", func(t *testing.T) {
+		if _, err := h.Search(ctx, "alice", SearchQuery{Text: ` "" ?! `}); !errors.Is(err, ErrEmptyQuery) {
+			t.Errorf("Expected ErrEmptyQuery, got %v", err)
+		}
+	})
+
+	t.Run("Deleted Conversation", func(t *testing.T) {
+		if err := h.DeleteConversation(ctx, "alice", kafka); err != nil {
+			t.Fatalf("DeleteConversation failed: %v", err)
+		}
+		if hits, _ := h.Search(ctx, "alice", SearchQuery{Text: "rebalance"}); len(hits) != 0 {
+			t.Errorf("Expected deleted messages to leave the index, got %+v", hits)
+		}
+	})
+}
@@ -1,63 +1,914 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"strings"
+	"time"
+
+	"chat-service/internal/redact"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("chat-service/internal/chat")
+
 // LLMClient interface to decouple from concrete implementation (useful for testing)
 type LLMClient interface {
-	StreamChat(messages []Message) (<-chan string, error)
+	StreamChat(ctx context.Context, messages []Message) (<-chan Chunk, error)
 }
 
+// PersonaLookup resolves a persona by name; an empty name asks for the
+// default. It is called for every message so configuration reloads apply
+// to the next turn.
+type PersonaLookup func(name string) (Persona, bool)
+
+var (
+	// ErrUnknownPersona is returned when a prompt names a persona that is
+	// not configured.
+	ErrUnknownPersona = errors.New("unknown persona")
+	// ErrNothingToRegenerate is returned when the active branch has no user
+	// message to answer.
+	ErrNothingToRegenerate = errors.New("no user message to regenerate a reply for")
+	// ErrNotEditable is returned when editing a message that is not a user
+	// message.
+	ErrNotEditable = errors.New("only user messages can be edited")
+)
+
 type Service struct {
-	history *HistoryManager
-	llm     LLMClient
+	history  *HistoryManager
+	llm      LLMClient
+	personas PersonaLookup
+	redactor func() *redact.Redactor
+
+	moderators       func() Moderator
+	moderationWindow int
+	injection        func() *InjectionPolicy
+	tools            *ToolRegistry
+	toolRounds       int
+	documents        DocumentIndex
+	retrievalK       int
+	embedder         Embedder
+	cache            ResponseCache
+	outputRetries    int
+	templates        TemplateLibrary
+}
+
+// Option configures optional Service behaviour.
+type Option func(*Service)
+
+// WithPersonas enables personas resolved through lookup.
+func WithPersonas(lookup PersonaLookup) Option {
+	return func(s *Service) {
+		s.personas = lookup
+	}
+}
+
+// WithRedactor redacts the conversation sent to the model with the
+// redactor returned by current, called once per turn; nil disables
+// redaction for that turn. History keeps the original text.
+func WithRedactor(current func() *redact.Redactor) Option {
+	return func(s *Service) {
+		s.redactor = current
+	}
 }
 
-func NewService(h *HistoryManager, llm LLMClient) *Service {
-	return &Service{
-		history: h,
-		llm:     llm,
+// WithModerator reviews user messages before they reach the model, and
+// answers in windows of window bytes before they reach the user, with the
+// moderator returned by current, called once per turn; nil disables
+// moderation for that turn. A window of 0 uses the default.
+func WithModerator(current func() Moderator, window int) Option {
+	return func(s *Service) {
+		s.moderators = current
+		if window > 0 {
+			s.moderationWindow = window
+		}
+	}
+}
+
+// WithInjectionGuard screens user messages for prompt injection with the
+// policy returned by current, called once per turn; nil disables screening
+// for that turn.
+func WithInjectionGuard(current func() *InjectionPolicy) Option {
+	return func(s *Service) {
+		s.injection = current
+	}
+}
+
+// WithTools offers the registry's tools to the model, when the LLM client
+// supports tool calling. The model may call tools for up to rounds rounds
+// per answer; 0 uses the default.
+func WithTools(registry *ToolRegistry, rounds int) Option {
+	return func(s *Service) {
+		s.tools = registry
+		if rounds > 0 {
+			s.toolRounds = rounds
+		}
+	}
+}
+
+func NewService(h *HistoryManager, llm LLMClient, opts ...Option) *Service {
+	s := &Service{
+		history:          h,
+		llm:              llm,
+		moderationWindow: defaultModerationWindow,
+		toolRounds:       defaultToolRounds,
+		retrievalK:       defaultRetrievalK,
+		outputRetries:    defaultOutputRetries,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // ProcessMessage handles a new user message, updates history, and streams the response.
-// It returns a channel that emits chunks of the assistant's response.
-func (s *Service) ProcessMessage(userContent string) (<-chan string, error) {
-	userMsg := Message{Role: RoleUser, Content: userContent}
-	s.history.AddMessage(userMsg)
+// It returns a channel that emits chunks of the assistant's response. A
+// message refused by moderation or blocked as a prompt injection is not
+// stored and yields a *RefusalError. A prompt asking for an unusable
+// response format yields ErrInvalidResponseFormat.
+//
+// A prompt invoking a template is written from it, and the message records
+// the template version in its metadata.
+func (s *Service) ProcessMessage(ctx context.Context, prompt Prompt) (<-chan Event, error) {
+	ctx, span := tracer.Start(ctx, "chat.ProcessMessage")
 
-	messages := s.history.GetContext()
+	prompt, use, err := s.applyTemplate(ctx, prompt)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+	conv, persona, err := s.prepare(ctx, prompt, true)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+	if err := s.moderateInput(ctx, prompt.Content); err != nil {
+		return nil, endSpan(span, err)
+	}
+	report, err := s.screenInjection(ctx, prompt.Content)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
 
-	stream, err := s.llm.StreamChat(messages)
+	userMsg := Message{Role: RoleUser, Content: prompt.Content}
+	if report != nil || use != nil {
+		userMsg.Metadata = make(map[string]any)
+	}
+	if report != nil {
+		userMsg.Metadata["injection"] = report
+	}
+	if use != nil {
+		userMsg.Metadata["template"] = use
+		span.SetAttributes(attribute.String("chat.template", use.Name), attribute.Int("chat.template.version", use.Version))
+	}
+	userMsg, err = s.history.AddMessage(ctx, conv.ID, userMsg)
 	if err != nil {
-		return nil, fmt.Errorf("llm call failed: %w", err)
+		return nil, endSpan(span, fmt.Errorf("failed to save message: %w", err))
 	}
+	if conv.Title == "" {
+		title := titleFrom(prompt.Content)
+		s.history.UpdateConversation(ctx, conv.Owner, conv.ID, ConversationPatch{Title: &title})
+	}
+
+	return s.reply(ctx, span, conv, persona, userMsg.ID, nil, prompt.Format)
+}
 
-	outChan := make(chan string)
+// Regenerate answers the last user message of the active branch again. The
+// new answer becomes a sibling of the previous one and the active branch.
+func (s *Service) Regenerate(ctx context.Context, prompt Prompt) (<-chan Event, error) {
+	ctx, span := tracer.Start(ctx, "chat.Regenerate")
+
+	conv, persona, err := s.prepare(ctx, prompt, false)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+	branch, err := s.history.GetAll(ctx, conv.ID)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		if branch[i].Role != RoleUser {
+			continue
+		}
+		var metadata map[string]any
+		if i+1 < len(branch) {
+			metadata = map[string]any{"regenerated_from": branch[i+1].ID}
+		}
+		return s.reply(ctx, span, conv, persona, branch[i].ID, metadata, prompt.Format)
+	}
+	return nil, endSpan(span, ErrNothingToRegenerate)
+}
+
+// EditMessage replaces the user message messageID with prompt.Content by
+// adding a sibling with the new content, then answers it. The original
+// message and its replies stay reachable through SwitchBranch.
+func (s *Service) EditMessage(ctx context.Context, prompt Prompt, messageID string) (<-chan Event, error) {
+	ctx, span := tracer.Start(ctx, "chat.EditMessage")
+
+	conv, persona, err := s.prepare(ctx, prompt, false)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+	orig, err := s.history.GetMessage(ctx, conv.ID, messageID)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+	if orig.Role != RoleUser {
+		return nil, endSpan(span, ErrNotEditable)
+	}
+	if err := s.moderateInput(ctx, prompt.Content); err != nil {
+		return nil, endSpan(span, err)
+	}
+	report, err := s.screenInjection(ctx, prompt.Content)
+	if err != nil {
+		return nil, endSpan(span, err)
+	}
+
+	metadata := map[string]any{"edited_from": orig.ID}
+	if report != nil {
+		metadata["injection"] = report
+	}
+	edited, err := s.history.AddBranch(ctx, conv.ID, orig.ParentID, Message{
+		Role:     RoleUser,
+		Content:  prompt.Content,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return nil, endSpan(span, fmt.Errorf("failed to save message: %w", err))
+	}
+
+	return s.reply(ctx, span, conv, persona, edited.ID, nil, prompt.Format)
+}
+
+// SwitchBranch makes the branch containing messageID active and returns it.
+func (s *Service) SwitchBranch(ctx context.Context, owner, id, messageID string) (Conversation, []Message, error) {
+	c, err := s.history.SwitchBranch(ctx, owner, id, messageID)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	msgs, err := s.history.GetAll(ctx, id)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	return c, msgs, nil
+}
+
+// prepare checks prompt's response format, resolves the conversation and
+// persona for prompt and tags the span with them. With create set, the
+// owner's default conversation is created on first use.
+func (s *Service) prepare(ctx context.Context, prompt Prompt, create bool) (Conversation, Persona, error) {
+	if _, _, err := outputFormat(prompt.Format); err != nil {
+		return Conversation{}, Persona{}, err
+	}
+	var (
+		conv Conversation
+		err  error
+	)
+	if create {
+		conv, err = s.history.EnsureConversation(ctx, prompt.Owner, prompt.ConversationID)
+	} else {
+		id := prompt.ConversationID
+		if id == "" {
+			id = DefaultConversationID(prompt.Owner)
+		}
+		conv, err = s.history.GetConversation(ctx, prompt.Owner, id)
+	}
+	if err != nil {
+		return Conversation{}, Persona{}, err
+	}
+
+	personaName := prompt.Persona
+	if personaName == "" {
+		personaName = conv.Persona
+	}
+	persona, err := s.resolvePersona(personaName)
+	if err != nil {
+		return Conversation{}, Persona{}, err
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("chat.conversation.id", conv.ID),
+		attribute.String("chat.persona", persona.Name),
+	)
+	return conv, persona, nil
+}
+
+// reply streams the model's answer to the branch ending at parentID and
+// stores it as a child of parentID, which makes it the active branch.
+// It takes ownership of span and ends it when the stream completes.
+//
+// With moderation on, the answer is held back and reviewed in windows; a
+// flagged window is not sent, generation stops and a refusal ends the
+// stream. The part already sent is stored.
+//
+// With documents, passages relevant to the user's message are added to
+// the context and their citations are streamed before the answer.
+//
+// With tools, the model may ask for tool calls instead of answering; they
+// run server-side, their progress is streamed as events, and the model is
+// called again with the results until it answers. Only the final answer is
+// stored, with the calls in its metadata.
+//
+// With a response cache, a question answered before is answered again from
+// the cache, replayed as a stream, unless the answer is being regenerated.
+// Complete answers given without tools are cached.
+//
+// With a response format, the model is asked for JSON and the answer is
+// held back until it is complete. An answer that does not parse or match
+// the schema is sent back to the model with the problems found, up to the
+// retry limit. The last answer is then sent, followed by its structured
+// output; invalid answers are not cached.
+func (s *Service) reply(ctx context.Context, span trace.Span, conv Conversation, persona Persona, parentID string, metadata map[string]any, format *ResponseFormat) (<-chan Event, error) {
+	format, schema, _ := outputFormat(format) // checked by prepare
+	messages, err := s.history.GetContextAt(ctx, conv.ID, parentID)
+	if err != nil {
+		return nil, endSpan(span, fmt.Errorf("failed to load history: %w", err))
+	}
+	// Redacted first, so the query sent to an embedding provider is too.
+	session := s.redactContext(messages)
+	messages, citations := s.retrieve(ctx, span, messages, session)
+	rehydrator := rehydratorFor(span, session)
+	if n := len(messages); n > 0 && injectionAction(messages[n-1]) == InjectionWarn {
+		messages = append(messages, Message{Role: RoleSystem, Content: injectionCaution})
+	}
+	if persona.SystemPrompt != "" {
+		messages = append([]Message{{Role: RoleSystem, Content: persona.SystemPrompt}}, messages...)
+	}
+	if format != nil {
+		messages = append(messages, Message{Role: RoleSystem, Content: formatInstruction(format)})
+		span.SetAttributes(attribute.String("chat.response_format", format.Type))
+	}
+	span.SetAttributes(attribute.Int("chat.context.messages", len(messages)))
+
+	moderator := s.moderator()
+	tools := s.toolDefinitions(persona)
+	query, cacheable := s.cacheQuery(ctx, conv.Owner, persona, messages, tools)
+	var hit *CacheHit
+	if cacheable && metadata["regenerated_from"] == nil {
+		hit = s.lookupCache(ctx, span, query)
+	}
+	genCtx, stopGeneration := context.WithCancel(ctx)
+	start := time.Now()
+	var stream <-chan Chunk
+	if hit != nil {
+		// The cached answer refers to the passages it was grounded in.
+		stream, citations = replay(genCtx, hit.Answer), hit.Answer.Citations
+	} else if stream, err = s.streamChat(genCtx, messages, tools, format); err != nil {
+		stopGeneration()
+		return nil, endSpan(span, fmt.Errorf("llm call failed: %w", err))
+	}
+
+	outChan := make(chan Event)
 
 	go func() {
+		defer span.End()
 		defer close(outChan)
+		defer stopGeneration()
 		var sb strings.Builder
+		reply := Message{Role: RoleAssistant, Metadata: metadata}
+		if len(citations) > 0 {
+			if reply.Metadata == nil {
+				reply.Metadata = make(map[string]any)
+			}
+			reply.Metadata["citations"] = citations
+			outChan <- Event{Citations: citations}
+		}
+
+		emit := func(text string) {
+			if rehydrator != nil {
+				text = rehydrator.Write(text)
+			}
+			if text != "" {
+				sb.WriteString(text)
+				outChan <- Event{Content: text}
+			}
+		}
+
+		// pending holds model output not yet reviewed; reviewed keeps the
+		// tail of what was approved for overlap with the next window.
+		var pending []string
+		var pendingLen int
+		var reviewed string
+		var refusal *Refusal
+		review := func() {
+			if len(pending) == 0 {
+				return
+			}
+			text := strings.Join(pending, "")
+			r, err := s.moderate(ctx, moderator, StageOutput, reviewed+text)
+			if err != nil {
+				span.RecordError(err)
+				slog.ErrorContext(ctx, "Output moderation failed", "conversation_id", conv.ID, "error", err)
+			}
+			if r != nil {
+				refusal = r
+				return
+			}
+			for _, chunk := range pending {
+				emit(chunk)
+			}
+			reviewed = text[max(0, len(text)-moderationOverlap):]
+			pending, pendingLen = pending[:0], 0
+		}
 
-		for chunk := range stream {
-			sb.WriteString(chunk)
-			outChan <- chunk
+		var activities []ToolActivity
+		// said is this round's output as the model wrote it, to be
+		// replayed with its tool calls, or cached.
+		var said strings.Builder
+		// output is the check of the last complete answer; text is the
+		// JSON extracted from it.
+		var output *StructuredOutput
+		var text string
+		rounds, attempts := 0, 1
+		for {
+			said.Reset()
+			var calls []ToolCall
+			for chunk := range stream {
+				if chunk.Model != "" {
+					reply.Model = chunk.Model
+				}
+				if chunk.FinishReason != "" {
+					reply.FinishReason = chunk.FinishReason
+				}
+				if chunk.Usage != nil {
+					reply.Usage = addUsage(reply.Usage, chunk.Usage)
+				}
+				if len(chunk.ToolCalls) > 0 {
+					calls = chunk.ToolCalls
+				}
+				if chunk.Content == "" {
+					continue
+				}
+				said.WriteString(chunk.Content)
+				if format != nil {
+					// Held back until the answer is checked.
+					continue
+				}
+				if moderator == nil {
+					emit(chunk.Content)
+					continue
+				}
+				pending = append(pending, chunk.Content)
+				if pendingLen += len(chunk.Content); pendingLen >= s.moderationWindow {
+					if review(); refusal != nil {
+						break
+					}
+				}
+			}
+			if refusal != nil || genCtx.Err() != nil {
+				break
+			}
+			if len(calls) == 0 || len(tools) == 0 {
+				if format == nil {
+					break
+				}
+				var out StructuredOutput
+				text, out = parseOutput(said.String(), schema)
+				out.Attempts = attempts
+				output = &out
+				if out.Valid || attempts > s.outputRetries {
+					break
+				}
+				slog.InfoContext(ctx, "Answer does not match the response format, retrying", "conversation_id", conv.ID, "attempt", attempts, "problems", len(out.Errors))
+				attempts++
+				messages = append(messages,
+					Message{Role: RoleAssistant, Content: said.String()},
+					Message{Role: RoleUser, Content: retryPrompt(out.Errors)})
+				if stream, err = s.streamChat(genCtx, messages, tools, format); err != nil {
+					span.RecordError(err)
+					slog.ErrorContext(ctx, "Failed to retry the answer", "conversation_id", conv.ID, "error", err)
+					break
+				}
+				continue
+			}
+			if moderator != nil {
+				// Settle what the model said before the tools run.
+				if review(); refusal != nil {
+					break
+				}
+			}
+
+			// Run the tools and let the model continue with their results;
+			// after the last round it has to answer without them.
+			// With rehydration on, tools see the values the user wrote;
+			// otherwise they get the placeholders the model used. Either
+			// way the model sees their results redacted like the rest of
+			// the context.
+			messages = append(messages, Message{Role: RoleAssistant, Content: said.String(), ToolCalls: calls})
+			for _, call := range calls {
+				call.Arguments = restoreArguments(session, call.Arguments)
+				result, activity := s.runTool(genCtx, call, tools, func(a ToolActivity) { outChan <- Event{Tool: &a} })
+				if session != nil {
+					result.Content = session.Redact(result.Content)
+				}
+				messages = append(messages, result)
+				activities = append(activities, activity)
+			}
+			if rehydrator != nil {
+				// What this round said is complete; restore it before the
+				// rehydrator is rebuilt with the results' placeholders.
+				if text := rehydrator.Flush(); text != "" {
+					sb.WriteString(text)
+					outChan <- Event{Content: text}
+				}
+			}
+			rehydrator = rehydratorFor(span, session)
+			rounds++
+			slog.InfoContext(ctx, "Ran tools", "conversation_id", conv.ID, "tools", toolNames(calls), "round", rounds)
+			if rounds >= s.toolRounds {
+				tools = nil
+			}
+			if stream, err = s.streamChat(genCtx, messages, tools, format); err != nil {
+				span.RecordError(err)
+				slog.ErrorContext(ctx, "Failed to continue after tool calls", "conversation_id", conv.ID, "error", err)
+				break
+			}
+		}
+		if format != nil && refusal == nil {
+			// Send the checked JSON, or what there is of an answer that
+			// was cut off.
+			if output == nil {
+				text = said.String()
+			}
+			if moderator != nil {
+				pending = append(pending, text)
+			} else {
+				emit(text)
+			}
+		}
+		if moderator != nil && refusal == nil {
+			review()
+		}
+		if refusal != nil {
+			stopGeneration()
+			for range stream {
+				// Let the client wind down after the cancellation.
+			}
+		} else if rehydrator != nil {
+			if text := rehydrator.Flush(); text != "" {
+				sb.WriteString(text)
+				outChan <- Event{Content: text}
+			}
 		}
 
-		fullResponse := sb.String()
-		if fullResponse != "" {
-			s.history.AddMessage(Message{
-				Role:    RoleAssistant,
-				Content: fullResponse,
-			})
+		// A cancelled context means the stream ended early rather than at
+		// the model's natural stop; keep what we have but flag it.
+		reply.Interrupted = ctx.Err() != nil
+		reply.Content = sb.String()
+		reply.LatencyMS = time.Since(start).Milliseconds()
+		if refusal != nil {
+			reply.FinishReason = "content_filter"
+			if reply.Metadata == nil {
+				reply.Metadata = make(map[string]any)
+			}
+			reply.Metadata["moderation"] = refusal
+			span.SetAttributes(attribute.StringSlice("chat.moderation.categories", refusal.Categories))
+			outChan <- Event{Refusal: refusal}
+		}
+		if len(activities) > 0 {
+			if reply.Metadata == nil {
+				reply.Metadata = make(map[string]any)
+			}
+			reply.Metadata["tools"] = activities
+		}
+		if output != nil && refusal == nil {
+			// The value as sent, with redacted values restored.
+			if output.Valid && json.Valid([]byte(reply.Content)) {
+				output.Value = json.RawMessage(reply.Content)
+			}
+			if reply.Metadata == nil {
+				reply.Metadata = make(map[string]any)
+			}
+			reply.Metadata["output"] = output
+			span.SetAttributes(
+				attribute.Bool("chat.output.valid", output.Valid),
+				attribute.Int("chat.output.attempts", output.Attempts),
+			)
+			outChan <- Event{Output: output}
+		}
+		if hit != nil {
+			if reply.Metadata == nil {
+				reply.Metadata = make(map[string]any)
+			}
+			reply.Metadata["cache"] = hit
+		}
+		span.SetAttributes(
+			attribute.Int("chat.response.length", len(reply.Content)),
+			attribute.Int("chat.tools.calls", len(activities)),
+			attribute.Bool("chat.response.interrupted", reply.Interrupted),
+			attribute.Bool("chat.response.refused", refusal != nil),
+		)
+		if reply.Content != "" {
+			_, err := s.history.AddBranch(context.WithoutCancel(ctx), conv.ID, parentID, reply)
+			if err != nil {
+				span.RecordError(err)
+				slog.ErrorContext(ctx, "Failed to save assistant message", "conversation_id", conv.ID, "error", err)
+			}
+		}
+		// Only complete answers given without tools, and in the requested
+		// format, are worth repeating.
+		complete := refusal == nil && !reply.Interrupted && (reply.FinishReason == "" || reply.FinishReason == "stop")
+		formatted := format == nil || (output != nil && output.Valid)
+		if cacheable && hit == nil && complete && formatted && len(activities) == 0 && said.Len() > 0 {
+			answer := CachedAnswer{Content: said.String(), Model: reply.Model, Citations: citations}
+			if err := s.cache.Store(context.WithoutCancel(ctx), query, answer); err != nil {
+				span.RecordError(err)
+				slog.ErrorContext(ctx, "Failed to cache answer", "conversation_id", conv.ID, "error", err)
+			}
 		}
 	}()
 
 	return outChan, nil
 }
 
-func (s *Service) GetHistory() []Message {
-	return s.history.GetAll()
+// redactContext replaces personal data and secrets in messages in place.
+// It returns the session used, to redact the rest of the turn's context
+// with the same placeholders, or nil when redaction is off.
+func (s *Service) redactContext(messages []Message) *redact.Session {
+	r := s.currentRedactor()
+	if r == nil {
+		return nil
+	}
+	session := r.NewSession()
+	for i := range messages {
+		messages[i].Content = session.Redact(messages[i].Content)
+	}
+	return session
+}
+
+// rehydratorFor records on span how many values session redacted. It
+// returns a rehydrator for the answer when placeholders are to be
+// restored, nil otherwise.
+func rehydratorFor(span trace.Span, session *redact.Session) *redact.Rehydrator {
+	if session == nil {
+		return nil
+	}
+	for detector, n := range session.Found() {
+		span.SetAttributes(attribute.Int("chat.redactions."+detector, n))
+	}
+	return session.Rehydrator()
+}
+
+// restoreArguments puts the values behind session's placeholders back
+// into the strings of a tool call's JSON arguments when rehydration is
+// on. Arguments that are not valid JSON are left for the tool registry to
+// reject.
+func restoreArguments(session *redact.Session, arguments string) string {
+	if session == nil || session.Restore(arguments) == arguments {
+		return arguments
+	}
+	dec := json.NewDecoder(strings.NewReader(arguments))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return arguments
+	}
+	out, err := json.Marshal(restoreValue(session, v))
+	if err != nil {
+		return arguments
+	}
+	return string(out)
+}
+
+func restoreValue(session *redact.Session, v any) any {
+	switch v := v.(type) {
+	case string:
+		return session.Restore(v)
+	case []any:
+		for i := range v {
+			v[i] = restoreValue(session, v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = restoreValue(session, v[k])
+		}
+	}
+	return v
+}
+
+// currentRedactor returns the redactor for this turn, or nil when
+// redaction is off.
+func (s *Service) currentRedactor() *redact.Redactor {
+	if s.redactor == nil {
+		return nil
+	}
+	return s.redactor()
+}
+
+// addUsage adds u to total, which may be nil, and returns the sum.
+func addUsage(total, u *Usage) *Usage {
+	sum := *u
+	if total != nil {
+		sum.PromptTokens += total.PromptTokens
+		sum.CompletionTokens += total.CompletionTokens
+		sum.TotalTokens += total.TotalTokens
+	}
+	return &sum
+}
+
+// endSpan records err on span, ends it and returns err.
+func endSpan(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+	return err
+}
+
+// titleFrom derives a conversation title from its first user message.
+func titleFrom(content string) string {
+	const maxTitle = 60
+	title := strings.Join(strings.Fields(content), " ")
+	if r := []rune(title); len(r) > maxTitle {
+		title = strings.TrimSpace(string(r[:maxTitle])) + "…"
+	}
+	return title
+}
+
+// resolvePersona returns the persona for name. Without personas configured
+// only the empty name is accepted and yields no system prompt.
+func (s *Service) resolvePersona(name string) (Persona, error) {
+	if s.personas == nil {
+		if name != "" {
+			return Persona{}, fmt.Errorf("%w: %q", ErrUnknownPersona, name)
+		}
+		return Persona{}, nil
+	}
+	p, ok := s.personas(name)
+	if !ok {
+		if name == "" {
+			return Persona{}, nil
+		}
+		return Persona{}, fmt.Errorf("%w: %q", ErrUnknownPersona, name)
+	}
+	return p, nil
+}
+
+// GetHistory returns every message of the owner's conversation; an empty id
+// means the default conversation, which has no messages until first used.
+func (s *Service) GetHistory(ctx context.Context, owner, conversationID string) ([]Message, error) {
+	if conversationID == "" {
+		conversationID = DefaultConversationID(owner)
+	}
+	if _, err := s.history.GetConversation(ctx, owner, conversationID); err != nil {
+		if errors.Is(err, ErrConversationNotFound) && conversationID == DefaultConversationID(owner) {
+			return []Message{}, nil
+		}
+		return nil, err
+	}
+	return s.history.GetAll(ctx, conversationID)
+}
+
+// CreateConversation starts an empty conversation for owner.
+func (s *Service) CreateConversation(ctx context.Context, owner string, c Conversation) (Conversation, error) {
+	if c.Persona != "" {
+		if _, err := s.resolvePersona(c.Persona); err != nil {
+			return Conversation{}, err
+		}
+	}
+	c.ID = ""
+	c.Owner = owner
+	return s.history.CreateConversation(ctx, c)
+}
+
+func (s *Service) ListConversations(ctx context.Context, owner string, offset, limit int) ([]Conversation, int, error) {
+	return s.history.ListConversations(ctx, owner, offset, limit)
+}
+
+// GetConversation returns the owner's conversation together with its messages.
+func (s *Service) GetConversation(ctx context.Context, owner, id string) (Conversation, []Message, error) {
+	c, err := s.history.GetConversation(ctx, owner, id)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	msgs, err := s.history.GetAll(ctx, id)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	return c, msgs, nil
+}
+
+// GetTree returns the owner's conversation together with the messages of
+// every branch, in the order they were added.
+func (s *Service) GetTree(ctx context.Context, owner, id string) (Conversation, []Message, error) {
+	c, err := s.history.GetConversation(ctx, owner, id)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	msgs, err := s.history.GetTree(ctx, id)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	return c, msgs, nil
+}
+
+// ForkConversation copies the owner's conversation up to messageID (the
+// active branch when empty) into a new conversation described by dst. The
+// fork belongs to dst.Owner when set, which callers must only allow for
+// admins, and to owner otherwise.
+func (s *Service) ForkConversation(ctx context.Context, owner, id, messageID string, dst Conversation) (Conversation, error) {
+	if dst.Persona != "" {
+		if _, err := s.resolvePersona(dst.Persona); err != nil {
+			return Conversation{}, err
+		}
+	}
+	return s.history.ForkConversation(ctx, owner, id, messageID, dst)
+}
+
+// ExportConversation returns the owner's conversation with every branch,
+// ready for WriteTranscripts.
+func (s *Service) ExportConversation(ctx context.Context, owner, id string) (Transcript, error) {
+	c, msgs, err := s.GetTree(ctx, owner, id)
+	if err != nil {
+		return Transcript{}, err
+	}
+	t := Transcript{Version: TranscriptVersion, Conversation: c, Messages: msgs}
+	// The persona may have been removed from the configuration since; the
+	// transcript is still worth having without its prompt.
+	if p, err := s.resolvePersona(c.Persona); err == nil {
+		t.SystemPrompt = p.SystemPrompt
+	}
+	return t, nil
+}
+
+// ExportConversations exports all of the owner's conversations, most
+// recently updated first.
+func (s *Service) ExportConversations(ctx context.Context, owner string) ([]Transcript, error) {
+	convs, total, err := s.history.ListConversations(ctx, owner, 0, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	ts := make([]Transcript, 0, total)
+	for _, c := range convs {
+		t, err := s.ExportConversation(ctx, owner, c.ID)
+		if errors.Is(err, ErrConversationNotFound) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// ImportConversation restores a JSON export as a new conversation of owner.
+func (s *Service) ImportConversation(ctx context.Context, owner string, t Transcript) (Conversation, error) {
+	if t.Conversation.Persona != "" {
+		if _, err := s.resolvePersona(t.Conversation.Persona); err != nil {
+			return Conversation{}, err
+		}
+	}
+	return s.history.ImportConversation(ctx, owner, t)
+}
+
+// Search finds the owner's messages matching q.
+func (s *Service) Search(ctx context.Context, owner string, q SearchQuery) ([]SearchHit, error) {
+	return s.history.Search(ctx, owner, q)
+}
+
+func (s *Service) UpdateConversation(ctx context.Context, owner, id string, patch ConversationPatch) (Conversation, error) {
+	if patch.Persona != nil && *patch.Persona != "" {
+		if _, err := s.resolvePersona(*patch.Persona); err != nil {
+			return Conversation{}, err
+		}
+	}
+	return s.history.UpdateConversation(ctx, owner, id, patch)
+}
+
+func (s *Service) DeleteConversation(ctx context.Context, owner, id string) error {
+	return s.history.DeleteConversation(ctx, owner, id)
+}
+
+// EraseIdentity deletes all of the owner's data and returns a receipt.
+// Their cached answers are dropped too. Documents they uploaded and template
+// versions they saved are shared with every identity, so they are kept but
+// no longer name the owner.
+func (s *Service) EraseIdentity(ctx context.Context, owner string) (ErasureReceipt, error) {
+	receipt, err := s.history.EraseOwner(ctx, owner)
+	if err != nil {
+		return ErasureReceipt{}, err
+	}
+	if s.cache != nil {
+		if err := s.cache.Purge(ctx, owner); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("failed to purge cached answers: %w", err)
+		}
+	}
+	if s.documents != nil {
+		if receipt.AnonymisedDocuments, err = s.documents.Disown(ctx, owner); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("failed to anonymise documents: %w", err)
+		}
+	}
+	if s.templates != nil {
+		if receipt.AnonymisedTemplates, err = s.templates.Disown(ctx, owner); err != nil {
+			return ErasureReceipt{}, fmt.Errorf("failed to anonymise templates: %w", err)
+		}
+	}
+	return receipt, nil
 }
@@ -0,0 +1,259 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"chat-service/internal/encryption"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist or
+// belongs to another owner.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// Store persists conversations and their messages. Implementations must be
+// safe for concurrent use. Ownership checks are done by HistoryManager.
+type Store interface {
+	// SaveConversation creates or replaces a conversation's metadata.
+	SaveConversation(ctx context.Context, c Conversation) error
+	GetConversation(ctx context.Context, id string) (Conversation, error)
+	// ListConversations returns the owner's conversations, most recently
+	// updated first.
+	ListConversations(ctx context.Context, owner string) ([]Conversation, error)
+	DeleteConversation(ctx context.Context, id string) error
+	// Owners returns every owner with at least one conversation.
+	Owners(ctx context.Context) ([]string, error)
+	AppendMessage(ctx context.Context, id string, msg Message) error
+	Messages(ctx context.Context, id string) ([]Message, error)
+	// ReplaceMessages swaps all of a conversation's messages for msgs.
+	ReplaceMessages(ctx context.Context, id string, msgs []Message) error
+	// Search returns the owner's messages matching q, best matches first.
+	// It returns ErrEmptyQuery when q.Text has no searchable terms.
+	Search(ctx context.Context, owner string, q SearchQuery) ([]SearchHit, error)
+	// Ping reports whether the store can serve reads and writes.
+	Ping(ctx context.Context) error
+}
+
+type conversationRecord struct {
+	Conversation Conversation `json:"conversation"`
+	Messages     []Message    `json:"messages"`
+	// Encryption is set only in encrypted files, whose titles and message
+	// contents are ciphertext under this data key.
+	Encryption *encryption.WrappedKey `json:"encryption,omitempty"`
+	// SealedMetadata holds, in encrypted files, the JSON of the
+	// conversation's metadata under "" and of each message's under its ID,
+	// as ciphertext. The metadata fields themselves are left empty.
+	SealedMetadata map[string]string `json:"sealed_metadata,omitempty"`
+}
+
+// MemoryStore keeps everything in process memory; state is lost on restart.
+// Message content is indexed for Search as it is appended, in one index per
+// owner so that scores reflect only the owner's own messages.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]*conversationRecord
+	indexes map[string]*searchIndex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*conversationRecord),
+		indexes: make(map[string]*searchIndex),
+	}
+}
+
+// index adds the messages of rec to its owner's search index.
+func (m *MemoryStore) index(rec *conversationRecord, msgs ...Message) {
+	x := m.indexes[rec.Conversation.Owner]
+	if x == nil {
+		x = newSearchIndex()
+		m.indexes[rec.Conversation.Owner] = x
+	}
+	for _, msg := range msgs {
+		x.add(rec.Conversation.ID, msg)
+	}
+}
+
+// unindex removes every message of rec from its owner's search index.
+func (m *MemoryStore) unindex(rec *conversationRecord) {
+	x := m.indexes[rec.Conversation.Owner]
+	if x == nil {
+		return
+	}
+	for _, msg := range rec.Messages {
+		x.remove(docRef{rec.Conversation.ID, msg.ID})
+	}
+	if len(x.terms) == 0 {
+		delete(m.indexes, rec.Conversation.Owner)
+	}
+}
+
+// load adds a complete record, as read back from disk, and indexes it.
+func (m *MemoryStore) load(rec *conversationRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[rec.Conversation.ID] = rec
+	m.index(rec, rec.Messages...)
+}
+
+func (m *MemoryStore) SaveConversation(ctx context.Context, c Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[c.ID]; ok {
+		rec.Conversation = c
+		return nil
+	}
+	m.records[c.ID] = &conversationRecord{Conversation: c, Messages: make([]Message, 0)}
+	return nil
+}
+
+func (m *MemoryStore) GetConversation(ctx context.Context, id string) (Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return rec.snapshot(), nil
+}
+
+func (m *MemoryStore) ListConversations(ctx context.Context, owner string) ([]Conversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Conversation, 0)
+	for _, rec := range m.records {
+		if rec.Conversation.Owner == owner {
+			result = append(result, rec.snapshot())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].UpdatedAt.Equal(result[j].UpdatedAt) {
+			return result[i].UpdatedAt.After(result[j].UpdatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (m *MemoryStore) DeleteConversation(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return ErrConversationNotFound
+	}
+	m.unindex(rec)
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryStore) Owners(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	owners := make([]string, 0)
+	for _, rec := range m.records {
+		if o := rec.Conversation.Owner; !seen[o] {
+			seen[o] = true
+			owners = append(owners, o)
+		}
+	}
+	sort.Strings(owners)
+	return owners, nil
+}
+
+func (m *MemoryStore) AppendMessage(ctx context.Context, id string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return ErrConversationNotFound
+	}
+	rec.Messages = append(rec.Messages, msg)
+	m.index(rec, msg)
+	return nil
+}
+
+func (m *MemoryStore) Messages(ctx context.Context, id string) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	result := make([]Message, len(rec.Messages))
+	copy(result, rec.Messages)
+	return result, nil
+}
+
+func (m *MemoryStore) ReplaceMessages(ctx context.Context, id string, msgs []Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return ErrConversationNotFound
+	}
+	m.unindex(rec)
+	rec.Messages = make([]Message, len(msgs))
+	copy(rec.Messages, msgs)
+	m.index(rec, rec.Messages...)
+	return nil
+}
+
+func (m *MemoryStore) Search(ctx context.Context, owner string, q SearchQuery) ([]SearchHit, error) {
+	phrases := parseQuery(q.Text)
+	if len(phrases) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	x := m.indexes[owner]
+	if x == nil {
+		return make([]SearchHit, 0), nil
+	}
+	return searchRecords(m.records, x.match(phrases), owner, q), nil
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// record returns a deep copy of the stored record for id.
+func (m *MemoryStore) record(id string) (conversationRecord, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.records[id]
+	if !ok {
+		return conversationRecord{}, false
+	}
+	msgs := make([]Message, len(rec.Messages))
+	copy(msgs, rec.Messages)
+	return conversationRecord{Conversation: rec.snapshot(), Messages: msgs}, true
+}
+
+// snapshot copies the conversation so callers cannot mutate shared metadata.
+func (r *conversationRecord) snapshot() Conversation {
+	c := r.Conversation
+	c.MessageCount = len(r.Messages)
+	if r.Conversation.Metadata != nil {
+		c.Metadata = make(map[string]string, len(r.Conversation.Metadata))
+		for k, v := range r.Conversation.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return c
+}
@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTemplateNotFound is returned for an unknown template or version.
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrInvalidTemplate is returned when a template does not parse or
+	// declares unusable variables.
+	ErrInvalidTemplate = errors.New("invalid template")
+	// ErrInvalidVariables is returned when the variables given to a
+	// template are unknown, missing or of the wrong type.
+	ErrInvalidVariables = errors.New("invalid template variables")
+	// ErrTemplateReadOnly is returned when changing a template defined in
+	// the configuration.
+	ErrTemplateReadOnly = errors.New("template is defined in the configuration")
+	// ErrTemplatesDisabled is returned by template calls on a Service
+	// without a template library.
+	ErrTemplatesDisabled = errors.New("templates are not enabled")
+)
+
+// Where a template is defined.
+const (
+	TemplateFromConfig = "config"
+	TemplateFromAPI    = "api"
+)
+
+// Template is one version of a named prompt template, written in Go's
+// text/template with its variables as the dot, e.g. {{.ticket}}.
+type Template struct {
+	Name        string     `json:"name"`
+	Version     int        `json:"version"`
+	Description string     `json:"description,omitempty"`
+	Text        string     `json:"text"`
+	Variables   []Variable `json:"variables,omitempty"`
+	// Source is TemplateFromConfig or TemplateFromAPI.
+	Source    string    `json:"source"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// Variable declares a template variable. Type is string (the default),
+// number, integer, boolean or list, a list of strings. A variable that is
+// not given takes its default, or the type's zero value unless required.
+type Variable struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     any    `json:"default,omitempty"`
+}
+
+// TemplateRef invokes a template from a chat. A Version of 0 means the
+// latest.
+type TemplateRef struct {
+	Name      string         `json:"name"`
+	Version   int            `json:"version,omitempty"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// TemplateUse records which template version produced a user message,
+// with the variables it was filled in with, defaults included. It is kept
+// in the message's metadata under "template".
+type TemplateUse struct {
+	Name      string         `json:"name"`
+	Version   int            `json:"version"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// TemplateLibrary stores versioned prompt templates. Saving a template
+// adds a version; a version of 0 asks for the latest.
+type TemplateLibrary interface {
+	// List returns the latest version of every template, by name.
+	List(ctx context.Context) ([]Template, error)
+	Get(ctx context.Context, name string, version int) (Template, error)
+	// Versions returns every version of a template, newest first.
+	Versions(ctx context.Context, name string) ([]Template, error)
+	Save(ctx context.Context, t Template) (Template, error)
+	// Delete removes a template with all its versions.
+	Delete(ctx context.Context, name string) error
+	// Disown clears the author of the versions author saved, which stay
+	// in the library, and returns the names of their templates.
+	Disown(ctx context.Context, author string) ([]string, error)
+	// Render fills in a template version with vars and returns the text
+	// together with the use to record.
+	Render(ctx context.Context, name string, version int, vars map[string]any) (string, TemplateUse, error)
+}
+
+// WithTemplates lets prompts be written from the library's templates.
+func WithTemplates(library TemplateLibrary) Option {
+	return func(s *Service) {
+		s.templates = library
+	}
+}
+
+func (s *Service) ListTemplates(ctx context.Context) ([]Template, error) {
+	if s.templates == nil {
+		return nil, ErrTemplatesDisabled
+	}
+	return s.templates.List(ctx)
+}
+
+func (s *Service) GetTemplate(ctx context.Context, name string, version int) (Template, error) {
+	if s.templates == nil {
+		return Template{}, ErrTemplatesDisabled
+	}
+	return s.templates.Get(ctx, name, version)
+}
+
+func (s *Service) TemplateVersions(ctx context.Context, name string) ([]Template, error) {
+	if s.templates == nil {
+		return nil, ErrTemplatesDisabled
+	}
+	return s.templates.Versions(ctx, name)
+}
+
+// SaveTemplate adds a version of t on behalf of owner.
+func (s *Service) SaveTemplate(ctx context.Context, owner string, t Template) (Template, error) {
+	if s.templates == nil {
+		return Template{}, ErrTemplatesDisabled
+	}
+	t.Source = TemplateFromAPI
+	t.CreatedBy = owner
+	t.CreatedAt = time.Now().UTC()
+	return s.templates.Save(ctx, t)
+}
+
+func (s *Service) DeleteTemplate(ctx context.Context, name string) error {
+	if s.templates == nil {
+		return ErrTemplatesDisabled
+	}
+	return s.templates.Delete(ctx, name)
+}
+
+// applyTemplate writes the content of a prompt invoking a template: the
+// rendered template, followed by the prompt's own content, if any, after
+// a blank line.
+func (s *Service) applyTemplate(ctx context.Context, prompt Prompt) (Prompt, *TemplateUse, error) {
+	if prompt.Template == nil {
+		return prompt, nil, nil
+	}
+	if s.templates == nil {
+		return prompt, nil, ErrTemplatesDisabled
+	}
+	ref := prompt.Template
+	text, use, err := s.templates.Render(ctx, ref.Name, ref.Version, ref.Variables)
+	if err != nil {
+		return prompt, nil, err
+	}
+	if prompt.Content != "" {
+		text += "\n\n" + prompt.Content
+	}
+	prompt.Content = text
+	return prompt, &use, nil
+}
@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// stubLibrary renders "Review <file>." as version 2 of "review".
+type stubLibrary struct {
+	TemplateLibrary
+}
+
+func (stubLibrary) Render(ctx context.Context, name string, version int, vars map[string]any) (string, TemplateUse, error) {
+	if name != "review" {
+		return "", TemplateUse{}, ErrTemplateNotFound
+	}
+	return fmt.Sprintf("Review %v.", vars["file"]), TemplateUse{Name: name, Version: 2, Variables: vars}, nil
+}
+
+func TestService_Template(t *testing.T) {
+	ctx := context.Background()
+	llm := &MockLLM{ResponseChunks: []string{"Looks good."}}
+	s := NewService(NewHistoryManager(), llm, WithTemplates(stubLibrary{}))
+
+	ref := &TemplateRef{Name: "review", Variables: map[string]any{"file": "main.go"}}
+	stream, err := s.ProcessMessage(ctx, Prompt{Content: "Focus on errors.", Template: ref})
+	if err != nil {
+		t.Fatalf("ProcessMessage failed: %v", err)
+	}
+	for range stream {
+	}
+
+	history, _ := s.GetHistory(ctx, "", "")
+	if history[0].Content != "Review main.go.\n\nFocus on errors." {
+		t.Errorf("Expected the rendered template before the message, got %q", history[0].Content)
+	}
+	if use, _ := history[0].Metadata["template"].(*TemplateUse); use == nil || use.Name != "review" || use.Version != 2 {
+		t.Errorf("Expected the template version recorded, got %+v", history[0].Metadata)
+	}
+	if sent := llm.CapturedMessages[len(llm.CapturedMessages)-1].Content; sent != history[0].Content {
+		t.Errorf("Expected the rendered message sent to the model, got %q", sent)
+	}
+
+	if _, err := s.ProcessMessage(ctx, Prompt{Template: &TemplateRef{Name: "missing"}}); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+	if _, err := NewService(NewHistoryManager(), llm).ProcessMessage(ctx, Prompt{Template: ref}); !errors.Is(err, ErrTemplatesDisabled) {
+		t.Errorf("Expected ErrTemplatesDisabled without a library, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ToolDefinition describes a tool to the model.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	// Parameters is the JSON Schema of the tool's arguments object.
+	Parameters json.RawMessage
+}
+
+// ToolCall is the model's request to run a tool.
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments is the JSON arguments object as produced by the model.
+	Arguments string `json:"arguments"`
+}
+
+// ToolHandler runs a tool with its decoded arguments object and returns the
+// result for the model. An error is reported to the model, which may retry
+// or answer without the tool.
+type ToolHandler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool is a server-side capability the model can call.
+type Tool struct {
+	ToolDefinition
+	Handler ToolHandler
+	// Timeout bounds one call; zero uses defaultToolTimeout.
+	Timeout time.Duration
+	// Source names where the tool comes from, e.g. an MCP server. Tools
+	// with a source are only offered to personas that enable it.
+	Source string
+}
+
+// ToolLLMClient is an LLMClient that can offer tools to the model. The
+// final chunk of a stream carries the tool calls the model made, if any.
+type ToolLLMClient interface {
+	LLMClient
+	StreamChatWithTools(ctx context.Context, messages []Message, tools []ToolDefinition) (<-chan Chunk, error)
+}
+
+const (
+	defaultToolTimeout = 30 * time.Second
+	// defaultToolRounds is how many rounds of tool calls the model may make
+	// before it is asked to answer without tools.
+	defaultToolRounds = 5
+	// maxToolResult bounds a result sent back to the model, in bytes.
+	maxToolResult = 16 << 10
+)
+
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ErrUnknownTool is returned when calling a tool that is not registered.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// ToolRegistry holds the tools offered to the model. It is safe for
+// concurrent use.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool)}
+}
+
+// Register adds t. Names must be unique and parameters a JSON Schema
+// object.
+func (r *ToolRegistry) Register(t Tool) error {
+	if !toolName.MatchString(t.Name) {
+		return fmt.Errorf("invalid tool name %q: use up to 64 letters, digits, _ or -", t.Name)
+	}
+	if t.Handler == nil {
+		return fmt.Errorf("tool %q has no handler", t.Name)
+	}
+	if len(t.Parameters) == 0 {
+		t.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(t.Parameters, &schema); err != nil {
+		return fmt.Errorf("tool %q parameters must be a JSON Schema object: %w", t.Name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.tools[t.Name]; dup {
+		return fmt.Errorf("tool %q is already registered", t.Name)
+	}
+	r.tools[t.Name] = t
+	r.order = append(r.order, t.Name)
+	return nil
+}
+
+// Definitions lists the registered tools in registration order.
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]ToolDefinition, len(r.order))
+	for i, name := range r.order {
+		defs[i] = r.tools[name].ToolDefinition
+	}
+	return defs
+}
+
+// Available lists the tools without a source and those from sources, in
+// registration order.
+func (r *ToolRegistry) Available(sources []string) []ToolDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var defs []ToolDefinition
+	for _, name := range r.order {
+		if t := r.tools[name]; t.Source == "" || slices.Contains(sources, t.Source) {
+			defs = append(defs, t.ToolDefinition)
+		}
+	}
+	return defs
+}
+
+// Call runs the tool named by call. Arguments must be a JSON object holding
+// every property the schema lists as required; the handler checks the rest.
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) (string, error) {
+	r.mu.RLock()
+	t, ok := r.tools[call.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTool, call.Name)
+	}
+
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(args, &fields); err != nil {
+		return "", fmt.Errorf("arguments must be a JSON object: %w", err)
+	}
+	var schema struct {
+		Required []string `json:"required"`
+	}
+	json.Unmarshal(t.Parameters, &schema)
+	for _, name := range schema.Required {
+		if _, ok := fields[name]; !ok {
+			return "", fmt.Errorf("missing required argument %q", name)
+		}
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = defaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return t.Handler(ctx, args)
+}
+
+// ToolActivity reports a tool call to the client and is recorded in the
+// answer's metadata under "tools".
+type ToolActivity struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	// Status is "running" when the call starts, then "done" or "error".
+	Status     string `json:"status"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+}
+
+// toolDefinitions returns the tools to offer this turn, or nil when the
+// model cannot be offered any.
+func (s *Service) toolDefinitions(persona Persona) []ToolDefinition {
+	if s.tools == nil {
+		return nil
+	}
+	if _, ok := s.llm.(ToolLLMClient); !ok {
+		return nil
+	}
+	return s.tools.Available(persona.ToolSources)
+}
+
+// streamChat starts a completion, offering tools when there are any and
+// asking for format when the client can.
+func (s *Service) streamChat(ctx context.Context, messages []Message, tools []ToolDefinition, format *ResponseFormat) (<-chan Chunk, error) {
+	if c, ok := s.llm.(FormatLLMClient); ok && format != nil {
+		return c.StreamChatWithFormat(ctx, messages, tools, format)
+	}
+	if len(tools) > 0 {
+		return s.llm.(ToolLLMClient).StreamChatWithTools(ctx, messages, tools)
+	}
+	return s.llm.StreamChat(ctx, messages)
+}
+
+// runTool runs one call to one of the offered tools, reporting its
+// progress through report, and returns the message carrying its result
+// back to the model.
+func (s *Service) runTool(ctx context.Context, call ToolCall, offered []ToolDefinition, report func(ToolActivity)) (Message, ToolActivity) {
+	ctx, span := tracer.Start(ctx, "chat.tool "+call.Name)
+	defer span.End()
+
+	activity := ToolActivity{ID: call.ID, Name: call.Name, Arguments: call.Arguments, Status: "running"}
+	report(activity)
+
+	start := time.Now()
+	var (
+		result string
+		err    error
+	)
+	if slices.ContainsFunc(offered, func(d ToolDefinition) bool { return d.Name == call.Name }) {
+		result, err = s.tools.Call(ctx, call)
+	} else {
+		err = fmt.Errorf("%w %q", ErrUnknownTool, call.Name)
+	}
+	activity.DurationMS = time.Since(start).Milliseconds()
+	if len(result) > maxToolResult {
+		cut := maxToolResult
+		for cut > 0 && !utf8.RuneStart(result[cut]) {
+			cut--
+		}
+		result = result[:cut] + "\n[truncated]"
+	}
+	content := result
+	if err != nil {
+		span.RecordError(err)
+		activity.Status, activity.Error = "error", err.Error()
+		content = "error: " + err.Error()
+	} else {
+		activity.Status, activity.Result = "done", result
+	}
+	report(activity)
+	return Message{Role: RoleTool, Content: content, ToolCallID: call.ID}, activity
+}
+
+// toolNames lists the names of calls, for logs and spans.
+func toolNames(calls []ToolCall) []string {
+	names := make([]string, 0, len(calls))
+	for _, c := range calls {
+		if !slices.Contains(names, c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
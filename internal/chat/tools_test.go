@@ -0,0 +1,300 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"chat-service/internal/redact"
+)
+
+// scriptedLLM answers each call with the next script entry and records
+// what it was given.
+type scriptedLLM struct {
+	script   [][]Chunk
+	calls    [][]Message
+	toolSets [][]ToolDefinition
+}
+
+func (m *scriptedLLM) StreamChat(ctx context.Context, messages []Message) (<-chan Chunk, error) {
+	return m.StreamChatWithTools(ctx, messages, nil)
+}
+
+func (m *scriptedLLM) StreamChatWithTools(ctx context.Context, messages []Message, tools []ToolDefinition) (<-chan Chunk, error) {
+	m.calls = append(m.calls, append([]Message(nil), messages...))
+	m.toolSets = append(m.toolSets, tools)
+	chunks := m.script[min(len(m.calls), len(m.script))-1]
+	ch := make(chan Chunk, len(chunks))
+	for _, c := range chunks {
+		ch <- c
+	}
+	close(ch)
+	return ch, nil
+}
+
+func echoTool(t *testing.T) *ToolRegistry {
+	r := NewToolRegistry()
+	err := r.Register(Tool{
+		ToolDefinition: ToolDefinition{
+			Name:        "echo",
+			Description: "Repeats text",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}},"required":["text"]}`),
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct{ Text string }
+			json.Unmarshal(args, &in)
+			if in.Text == "fail" {
+				return "", errors.New("cannot echo that")
+			}
+			return in.Text, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestToolRegistry(t *testing.T) {
+	r := echoTool(t)
+	handler := func(context.Context, json.RawMessage) (string, error) { return "", nil }
+
+	for _, bad := range []Tool{
+		{ToolDefinition: ToolDefinition{Name: "has space"}, Handler: handler},
+		{ToolDefinition: ToolDefinition{Name: "echo"}, Handler: handler},
+		{ToolDefinition: ToolDefinition{Name: "schema", Parameters: json.RawMessage(`[1]`)}, Handler: handler},
+		{ToolDefinition: ToolDefinition{Name: "nohandler"}},
+	} {
+		if err := r.Register(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad.Name)
+		}
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		call    ToolCall
+		want    string
+		wantErr string
+	}{
+		{ToolCall{Name: "echo", Arguments: `{"text":"hi"}`}, "hi", ""},
+		{ToolCall{Name: "echo", Arguments: `{}`}, "", `missing required argument "text"`},
+		{ToolCall{Name: "echo", Arguments: `"hi"`}, "", "arguments must be a JSON object"},
+		{ToolCall{Name: "nope"}, "", "unknown tool"},
+	}
+	for _, tt := range tests {
+		got, err := r.Call(ctx, tt.call)
+		if got != tt.want || (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Call(%+v) = %q, %v; want %q, %q", tt.call, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if defs := r.Definitions(); len(defs) != 1 || defs[0].Name != "echo" {
+		t.Errorf("Unexpected definitions %+v", defs)
+	}
+}
+
+func TestService_Tools(t *testing.T) {
+	ctx := context.Background()
+	usage := &Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}
+
+	t.Run("runs tools until the model answers", func(t *testing.T) {
+		llm := &scriptedLLM{script: [][]Chunk{
+			{{FinishReason: "tool_calls", Usage: usage, ToolCalls: []ToolCall{
+				{ID: "c1", Name: "echo", Arguments: `{"text":"pong"}`},
+				{ID: "c2", Name: "echo", Arguments: `{"text":"fail"}`},
+			}}},
+			{{Content: "It said pong."}, {FinishReason: "stop", Usage: usage}},
+		}}
+		h := NewHistoryManager()
+		s := NewService(h, llm, WithTools(echoTool(t), 0))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "ping"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		var answer string
+		var statuses []string
+		for ev := range stream {
+			answer += ev.Content
+			if ev.Tool != nil {
+				statuses = append(statuses, ev.Tool.ID+":"+ev.Tool.Status)
+			}
+		}
+
+		if answer != "It said pong." {
+			t.Errorf("Expected the final answer, got %q", answer)
+		}
+		if got := strings.Join(statuses, " "); got != "c1:running c1:done c2:running c2:error" {
+			t.Errorf("Unexpected tool events %q", got)
+		}
+		replay := llm.calls[1][1:]
+		if len(replay) != 3 || len(replay[0].ToolCalls) != 2 || replay[1].Content != "pong" || replay[2].Content != "error: cannot echo that" || replay[2].ToolCallID != "c2" {
+			t.Errorf("Expected the calls and results to be sent back, got %+v", replay)
+		}
+
+		msgs, _ := h.GetAll(ctx, DefaultConversationID("alice"))
+		reply := msgs[len(msgs)-1]
+		activities, _ := reply.Metadata["tools"].([]ToolActivity)
+		if len(msgs) != 2 || len(activities) != 2 || activities[0].Result != "pong" || activities[1].Error == "" {
+			t.Errorf("Expected only the answer stored, with the calls in metadata, got %+v", msgs)
+		}
+		if reply.FinishReason != "stop" || reply.Usage.TotalTokens != 24 {
+			t.Errorf("Expected usage summed over rounds, got %+v %+v", reply.FinishReason, reply.Usage)
+		}
+	})
+
+	t.Run("stops offering tools after the last round", func(t *testing.T) {
+		loop := []Chunk{{ToolCalls: []ToolCall{{ID: "c", Name: "echo", Arguments: `{"text":"again"}`}}}}
+		llm := &scriptedLLM{script: [][]Chunk{loop, loop, {{Content: "Done."}}}}
+		s := NewService(NewHistoryManager(), llm, WithTools(echoTool(t), 2))
+
+		stream, _ := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "loop"})
+		for range stream {
+		}
+		if len(llm.calls) != 3 || llm.toolSets[1] == nil || llm.toolSets[2] != nil {
+			t.Errorf("Expected tools on 2 rounds then none, got %d calls", len(llm.calls))
+		}
+	})
+
+	t.Run("offers sourced tools only to personas enabling them", func(t *testing.T) {
+		registry := echoTool(t)
+		err := registry.Register(Tool{
+			ToolDefinition: ToolDefinition{Name: "files__read"},
+			Handler:        func(context.Context, json.RawMessage) (string, error) { return "contents", nil },
+			Source:         "files",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		personas := func(name string) (Persona, bool) {
+			if name == "reader" {
+				return Persona{Name: name, ToolSources: []string{"files"}}, true
+			}
+			return Persona{}, true
+		}
+		read := []Chunk{{ToolCalls: []ToolCall{{ID: "c", Name: "files__read"}}}}
+
+		for _, tt := range []struct {
+			persona string
+			tools   int
+			status  string
+		}{
+			{"", 1, "error"},
+			{"reader", 2, "done"},
+		} {
+			llm := &scriptedLLM{script: [][]Chunk{read, {{Content: "Done."}}}}
+			s := NewService(NewHistoryManager(), llm, WithTools(registry, 0), WithPersonas(personas))
+			stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "read", Persona: tt.persona})
+			if err != nil {
+				t.Fatalf("ProcessMessage failed: %v", err)
+			}
+			var status string
+			for ev := range stream {
+				if ev.Tool != nil {
+					status = ev.Tool.Status
+				}
+			}
+			if len(llm.toolSets[0]) != tt.tools || status != tt.status {
+				t.Errorf("Persona %q: expected %d tools and a %s call, got %d and %s", tt.persona, tt.tools, tt.status, len(llm.toolSets[0]), status)
+			}
+		}
+	})
+
+	t.Run("restores arguments and redacts results", func(t *testing.T) {
+		r, err := redact.New(redact.Options{Mode: redact.ModePlaceholder, Rehydrate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		llm := &scriptedLLM{script: [][]Chunk{
+			{{ToolCalls: []ToolCall{{ID: "c", Name: "echo", Arguments: `{"text":"[EMAIL_1] cc bob@example.com"}`}}}},
+			{{Content: "Copied [EMAIL_2]."}},
+		}}
+		h := NewHistoryManager()
+		s := NewService(h, llm, WithTools(echoTool(t), 0), WithRedactor(func() *redact.Redactor { return r }))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "Mail jane@example.com"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		var answer string
+		for ev := range stream {
+			answer += ev.Content
+		}
+
+		if got := llm.calls[1][2].Content; got != "[EMAIL_1] cc [EMAIL_2]" {
+			t.Errorf("Expected the tool result redacted for the model, got %q", got)
+		}
+		if answer != "Copied bob@example.com." {
+			t.Errorf("Expected the result's placeholder restored in the answer, got %q", answer)
+		}
+		msgs, _ := h.GetAll(ctx, DefaultConversationID("alice"))
+		activities, _ := msgs[len(msgs)-1].Metadata["tools"].([]ToolActivity)
+		if len(activities) != 1 || activities[0].Arguments != `{"text":"jane@example.com cc bob@example.com"}` {
+			t.Errorf("Expected the tool to get the original values, got %+v", activities)
+		}
+	})
+
+	t.Run("keeps placeholders in arguments without rehydration", func(t *testing.T) {
+		r, err := redact.New(redact.Options{Mode: redact.ModePlaceholder})
+		if err != nil {
+			t.Fatal(err)
+		}
+		llm := &scriptedLLM{script: [][]Chunk{
+			{{ToolCalls: []ToolCall{{ID: "c", Name: "echo", Arguments: `{"text":"[EMAIL_1]"}`}}}},
+			{{Content: "Copied [EMAIL_1]."}},
+		}}
+		h := NewHistoryManager()
+		s := NewService(h, llm, WithTools(echoTool(t), 0), WithRedactor(func() *redact.Redactor { return r }))
+
+		stream, err := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "Mail jane@example.com"})
+		if err != nil {
+			t.Fatalf("ProcessMessage failed: %v", err)
+		}
+		var answer string
+		for ev := range stream {
+			answer += ev.Content
+		}
+
+		if answer != "Copied [EMAIL_1]." {
+			t.Errorf("Expected the placeholder kept in the answer, got %q", answer)
+		}
+		msgs, _ := h.GetAll(ctx, DefaultConversationID("alice"))
+		activities, _ := msgs[len(msgs)-1].Metadata["tools"].([]ToolActivity)
+		if len(activities) != 1 || activities[0].Arguments != `{"text":"[EMAIL_1]"}` {
+			t.Errorf("Expected the tool to get the placeholder, got %+v", activities)
+		}
+	})
+
+	t.Run("truncates results on a rune boundary", func(t *testing.T) {
+		registry := NewToolRegistry()
+		err := registry.Register(Tool{
+			ToolDefinition: ToolDefinition{Name: "accents"},
+			Handler: func(context.Context, json.RawMessage) (string, error) {
+				return "x" + strings.Repeat("é", maxToolResult), nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		llm := &scriptedLLM{script: [][]Chunk{
+			{{ToolCalls: []ToolCall{{ID: "c", Name: "accents"}}}},
+			{{Content: "Done."}},
+		}}
+		s := NewService(NewHistoryManager(), llm, WithTools(registry, 0))
+		stream, _ := s.ProcessMessage(ctx, Prompt{Owner: "alice", Content: "accents"})
+		for range stream {
+		}
+		if got := llm.calls[1][2].Content; !utf8.ValidString(got) || !strings.HasSuffix(got, "é\n[truncated]") {
+			t.Errorf("Expected a valid truncated result, got %q", got[len(got)-20:])
+		}
+	})
+
+	t.Run("needs a tool-capable client", func(t *testing.T) {
+		s := NewService(NewHistoryManager(), &MockLLM{}, WithTools(echoTool(t), 0))
+		if defs := s.toolDefinitions(Persona{}); defs != nil {
+			t.Errorf("Expected no tools for a plain client, got %+v", defs)
+		}
+	})
+}
@@ -1,46 +1,682 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/moderation"
+	"chat-service/internal/redact"
+	"chat-service/internal/templates"
+	"chat-service/internal/tools"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Config holds the service settings. Values are layered, lowest precedence
+// first: built-in defaults, the config file, environment variables, flags.
 type Config struct {
-	Port           string
-	GroqAPIKey     string
-	AppModel       string
-	MaxTokens      int
-	APIKey         string
-	RateLimitRPS   int
-	RateLimitBurst int
+	Port        string `yaml:"port"`
+	GroqAPIKey  string `yaml:"groq_api_key"`
+	GroqBaseURL string `yaml:"groq_base_url"`
+	AppModel    string `yaml:"model"`
+	MaxTokens   int    `yaml:"max_tokens"`
+	APIKey      string `yaml:"api_key"`
+	// APIKeys lists additional accepted client keys, e.g. one per team, so
+	// keys can be rotated without downtime.
+	APIKeys []string `yaml:"api_keys"`
+	// AdminKeys are accepted client keys that may also change content
+	// shared by every client, such as documents.
+	AdminKeys      []string `yaml:"admin_keys"`
+	RateLimitRPS   int      `yaml:"rate_limit_rps"`
+	RateLimitBurst int      `yaml:"rate_limit_burst"`
+	OTLPEndpoint   string   `yaml:"otlp_endpoint"`
+	ServiceName    string   `yaml:"service_name"`
+	// HistoryDir is where conversations are persisted as JSON files. Empty
+	// keeps history in memory only.
+	HistoryDir string `yaml:"history_dir"`
+	// ShutdownTimeout is how long active chat streams may keep running
+	// after a termination signal before they are cut off.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Retention limits stored history; zero disables a limit. Conversations
+	// idle for longer than RetentionMaxAge or beyond an identity's
+	// RetentionMaxConversations newest are deleted, and longer conversations
+	// lose their oldest messages beyond RetentionMaxMessages. The limits are
+	// enforced every RetentionSweepInterval.
+	RetentionMaxAge           time.Duration `yaml:"retention_max_age"`
+	RetentionMaxMessages      int           `yaml:"retention_max_messages"`
+	RetentionMaxConversations int           `yaml:"retention_max_conversations"`
+	RetentionSweepInterval    time.Duration `yaml:"retention_sweep_interval"`
+
+	// EncryptionKeys are the key-encryption keys for history at rest, as
+	// "id:base64key" entries with the primary key first. They are only read
+	// from the environment so key material stays out of config files;
+	// EncryptionKeyFile names a file holding the same entries instead.
+	EncryptionKeys    []string `yaml:"-"`
+	EncryptionKeyFile string   `yaml:"encryption_key_file"`
+
+	// RedactionMode turns on redaction of personal data and secrets in
+	// prompts before they are sent to the model: mask, hash or placeholder.
+	// Empty leaves prompts untouched. RedactionDetectors picks built-in
+	// detectors (all when empty) and RedactionPatterns adds named regular
+	// expressions. RedactionRehydrate restores placeholders in answers.
+	RedactionMode      string            `yaml:"redaction_mode"`
+	RedactionDetectors []string          `yaml:"redaction_detectors"`
+	RedactionPatterns  map[string]string `yaml:"redaction_patterns"`
+	RedactionRehydrate bool              `yaml:"redaction_rehydrate"`
+
+	// ModerationRules are keyword and regex rules applied to user messages
+	// and answers. ModerationClassifier lists the stages, input and/or
+	// output, also reviewed by asking the model. Answers are reviewed in
+	// windows of ModerationWindow bytes.
+	ModerationRules      []ModerationRule `yaml:"moderation_rules"`
+	ModerationClassifier []string         `yaml:"moderation_classifier"`
+	ModerationWindow     int              `yaml:"moderation_window"`
+
+	// InjectionAction is what happens to user messages scoring at least
+	// InjectionThreshold as prompt injection: block, warn or tag. Empty
+	// turns detection off. InjectionClassifier adds the model as a second
+	// detector next to the built-in heuristics.
+	InjectionAction     string  `yaml:"injection_action"`
+	InjectionThreshold  float64 `yaml:"injection_threshold"`
+	InjectionClassifier bool    `yaml:"injection_classifier"`
+
+	// ToolMaxRounds is how many rounds of tool calls the model may make
+	// for one answer before it has to answer without tools.
+	ToolMaxRounds int `yaml:"tool_max_rounds"`
+	// BuiltinTools names the built-in tools to offer: calculator, clock
+	// and fetch. The fetch tool only reaches hosts on FetchAllowlist, reads
+	// at most FetchMaxBytes and gives up after FetchTimeout.
+	BuiltinTools   []string      `yaml:"builtin_tools"`
+	FetchAllowlist []string      `yaml:"fetch_allowlist"`
+	FetchMaxBytes  int           `yaml:"fetch_max_bytes"`
+	FetchTimeout   time.Duration `yaml:"fetch_timeout"`
+	// DocumentsDir is where uploaded documents are persisted as JSON
+	// files. Empty keeps them in memory. Documents are split into chunks of
+	// DocumentChunkSize bytes, each repeating DocumentChunkOverlap bytes of
+	// the one before, and the RetrievalTopK chunks most relevant to a user
+	// message are added to the context.
+	DocumentsDir         string `yaml:"documents_dir"`
+	DocumentChunkSize    int    `yaml:"document_chunk_size"`
+	DocumentChunkOverlap int    `yaml:"document_chunk_overlap"`
+	RetrievalTopK        int    `yaml:"retrieval_top_k"`
+
+	// EmbeddingProvider selects the embedding backend: openai (any
+	// OpenAI-compatible API), ollama or hash, a local hashing embedder.
+	// Empty turns embeddings off. EmbeddingBaseURL and EmbeddingModel
+	// default per provider; EmbeddingDimensions sets the hash size, or asks
+	// an OpenAI model for shorter vectors. With embeddings on, documents
+	// are also ranked by similarity, and chunks below
+	// RetrievalMinSimilarity are only retrieved on shared terms.
+	EmbeddingProvider      string  `yaml:"embedding_provider"`
+	EmbeddingBaseURL       string  `yaml:"embedding_base_url"`
+	EmbeddingAPIKey        string  `yaml:"embedding_api_key"`
+	EmbeddingModel         string  `yaml:"embedding_model"`
+	EmbeddingDimensions    int     `yaml:"embedding_dimensions"`
+	RetrievalMinSimilarity float64 `yaml:"retrieval_min_similarity"`
+
+	// ResponseCacheTTL turns on the response cache: answers are reused for
+	// the same question to the same persona for this long. At most
+	// ResponseCacheMaxEntries answers are kept. With embeddings on, a first
+	// question also reuses the answer to one at least
+	// ResponseCacheSimilarity similar.
+	ResponseCacheTTL        time.Duration `yaml:"response_cache_ttl"`
+	ResponseCacheMaxEntries int           `yaml:"response_cache_max_entries"`
+	ResponseCacheSimilarity float64       `yaml:"response_cache_similarity"`
+
+	// StructuredOutputMode is how a requested response format reaches the
+	// model: json_schema passes the schema as response_format, json_object
+	// asks for any JSON object, and prompt relies on the instruction alone.
+	// Answers are validated either way, and sent back to the model with
+	// the problems found up to StructuredOutputRetries times.
+	StructuredOutputMode    string `yaml:"structured_output_mode"`
+	StructuredOutputRetries int    `yaml:"structured_output_retries"`
+
+	// Templates maps a name to a prompt template chats can invoke.
+	// Templates saved through the API are persisted in TemplatesDir;
+	// empty keeps them in memory.
+	Templates    map[string]PromptTemplate `yaml:"templates"`
+	TemplatesDir string                    `yaml:"templates_dir"`
+
+	// MCPServers maps a name to an MCP server whose tools and prompts are
+	// offered to personas listing that name in their mcp_servers.
+	MCPServers map[string]MCPServer `yaml:"mcp_servers"`
+
+	// Personas maps a persona name to the settings applied to chats that
+	// select it; DefaultPersona is used when a request names none.
+	Personas       map[string]Persona `yaml:"personas"`
+	DefaultPersona string             `yaml:"default_persona"`
+
+	// ConfigFile is the file the configuration was loaded from, if any.
+	ConfigFile string `yaml:"-"`
+}
+
+// ModerationRule flags text containing any of its keywords (whole words,
+// any case) or matching any of its patterns.
+type ModerationRule struct {
+	Category string   `yaml:"category"`
+	Keywords []string `yaml:"keywords"`
+	Patterns []string `yaml:"patterns"`
+	// Stages limits the rule to input or output; empty applies to both.
+	Stages []string `yaml:"stages"`
+}
+
+// mcpServerName restricts server names to what can prefix a tool name.
+var mcpServerName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// MCPServer says how to reach an MCP server: Command starts it as a
+// subprocess speaking stdio, URL reaches it over streamable HTTP.
+type MCPServer struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Persona is a named assistant configuration.
+type Persona struct {
+	SystemPrompt string `yaml:"system_prompt"`
+	// MCPServers names the MCP servers whose tools the persona may use.
+	MCPServers []string `yaml:"mcp_servers"`
+}
+
+// PromptTemplate is a prompt template in Go's text/template syntax.
+// Version defaults to 1; raise it when changing the text, so conversations
+// can tell which text they were written from.
+type PromptTemplate struct {
+	Version     int                `yaml:"version"`
+	Description string             `yaml:"description"`
+	Text        string             `yaml:"text"`
+	Variables   []TemplateVariable `yaml:"variables"`
+}
+
+// TemplateVariable declares a template variable; see chat.Variable.
+type TemplateVariable struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+	Default     any    `yaml:"default"`
+}
+
+// ClientKeys returns every accepted client API key. An empty result means
+// authentication is disabled.
+func (c *Config) ClientKeys() []string {
+	keys := make([]string, 0, len(c.APIKeys)+len(c.AdminKeys)+1)
+	if c.APIKey != "" {
+		keys = append(keys, c.APIKey)
+	}
+	for _, k := range slices.Concat(c.APIKeys, c.AdminKeys) {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// RedactionOptions returns the redaction settings; they only apply when
+// RedactionMode is set.
+func (c *Config) RedactionOptions() redact.Options {
+	return redact.Options{
+		Mode:      redact.Mode(c.RedactionMode),
+		Detectors: c.RedactionDetectors,
+		Patterns:  c.RedactionPatterns,
+		Rehydrate: c.RedactionRehydrate,
+	}
+}
+
+// ModerationPolicy compiles the moderation rules.
+func (c *Config) ModerationPolicy() (*moderation.Policy, error) {
+	rules := make([]moderation.Rule, len(c.ModerationRules))
+	for i, r := range c.ModerationRules {
+		rules[i] = moderation.Rule{Category: r.Category, Keywords: r.Keywords, Patterns: r.Patterns}
+		for _, st := range r.Stages {
+			rules[i].Stages = append(rules[i].Stages, chat.ModerationStage(st))
+		}
+	}
+	return moderation.NewPolicy(rules)
 }
 
-func Load() *Config {
+// PromptTemplates returns the configured templates, by name.
+func (c *Config) PromptTemplates() []chat.Template {
+	list := make([]chat.Template, 0, len(c.Templates))
+	for _, name := range slices.Sorted(maps.Keys(c.Templates)) {
+		t := c.Templates[name]
+		vars := make([]chat.Variable, len(t.Variables))
+		for i, v := range t.Variables {
+			vars[i] = chat.Variable{Name: v.Name, Type: v.Type, Description: v.Description, Required: v.Required, Default: v.Default}
+		}
+		list = append(list, chat.Template{
+			Name:        name,
+			Version:     max(t.Version, 1),
+			Description: t.Description,
+			Text:        t.Text,
+			Variables:   vars,
+			Source:      chat.TemplateFromConfig,
+		})
+	}
+	return list
+}
+
+func defaults() *Config {
 	return &Config{
-		Port:           getEnv("PORT", "8080"),
-		GroqAPIKey:     getEnv("GROQ_API_KEY", ""),
-		AppModel:       getEnv("MODEL", "llama-3.3-70b-versatile"),
-		MaxTokens:      getEnvInt("MAX_TOKENS", 1024),
-		APIKey:         getEnv("API_KEY", ""),
-		RateLimitRPS:   getEnvInt("RATE_LIMIT_RPS", 10),   // Default 10 RPS
-		RateLimitBurst: getEnvInt("RATE_LIMIT_BURST", 20), // Default burst 20
+		Port:            "8080",
+		GroqBaseURL:     "https://api.groq.com/openai/v1",
+		AppModel:        "llama-3.3-70b-versatile",
+		MaxTokens:       1024,
+		RateLimitRPS:    10, // Default 10 RPS
+		RateLimitBurst:  20, // Default burst 20
+		ServiceName:     "chat-service",
+		ShutdownTimeout: 30 * time.Second,
+
+		RetentionSweepInterval: 10 * time.Minute,
+		ModerationWindow:       200,
+		InjectionThreshold:     0.5,
+		ToolMaxRounds:          5,
+		FetchMaxBytes:          1 << 20,
+		FetchTimeout:           10 * time.Second,
+		DocumentChunkSize:      1200,
+		DocumentChunkOverlap:   200,
+		RetrievalTopK:          4,
+		RetrievalMinSimilarity: 0.3,
+
+		ResponseCacheMaxEntries: 1000,
+		ResponseCacheSimilarity: 0.95,
+
+		StructuredOutputMode:    "json_object",
+		StructuredOutputRetries: 2,
+	}
+}
+
+// Load builds the configuration from defaults, an optional YAML or JSON file
+// (-config flag or CONFIG_FILE), environment variables and command-line
+// flags, then validates it. Every problem found is reported in one error.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("chat-service", flag.ContinueOnError)
+	var fl Config
+	configPath := fs.String("config", getEnv("CONFIG_FILE", ""), "path to a YAML or JSON config file")
+	fs.StringVar(&fl.Port, "port", "", "HTTP listen port")
+	fs.StringVar(&fl.AppModel, "model", "", "Groq model name")
+	fs.IntVar(&fl.MaxTokens, "max-tokens", 0, "maximum tokens per completion")
+	fs.IntVar(&fl.RateLimitRPS, "rate-limit-rps", 0, "requests per second allowed per client")
+	fs.IntVar(&fl.RateLimitBurst, "rate-limit-burst", 0, "burst size allowed per client")
+	fs.DurationVar(&fl.ShutdownTimeout, "shutdown-timeout", 0, "how long active streams may run after a shutdown signal")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaults()
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+		cfg.ConfigFile = *configPath
+	}
+
+	var errs []error
+	cfg.loadEnv(&errs)
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = fl.Port
+		case "model":
+			cfg.AppModel = fl.AppModel
+		case "max-tokens":
+			cfg.MaxTokens = fl.MaxTokens
+		case "rate-limit-rps":
+			cfg.RateLimitRPS = fl.RateLimitRPS
+		case "rate-limit-burst":
+			cfg.RateLimitBurst = fl.RateLimitBurst
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = fl.ShutdownTimeout
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the settings present in path. JSON is accepted as it is
+// valid YAML; unknown keys are rejected so typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv(errs *[]error) {
+	c.Port = getEnv("PORT", c.Port)
+	c.GroqAPIKey = getEnv("GROQ_API_KEY", c.GroqAPIKey)
+	c.GroqBaseURL = getEnv("GROQ_BASE_URL", c.GroqBaseURL)
+	c.AppModel = getEnv("MODEL", c.AppModel)
+	c.MaxTokens = getEnvInt("MAX_TOKENS", c.MaxTokens, errs)
+	c.APIKey = getEnv("API_KEY", c.APIKey)
+	c.APIKeys = getEnvList("API_KEYS", c.APIKeys)
+	c.AdminKeys = getEnvList("ADMIN_KEYS", c.AdminKeys)
+	c.RateLimitRPS = getEnvInt("RATE_LIMIT_RPS", c.RateLimitRPS, errs)
+	c.RateLimitBurst = getEnvInt("RATE_LIMIT_BURST", c.RateLimitBurst, errs)
+	c.OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", c.OTLPEndpoint) // Tracing export disabled when empty
+	c.ServiceName = getEnv("OTEL_SERVICE_NAME", c.ServiceName)
+	c.HistoryDir = getEnv("HISTORY_DIR", c.HistoryDir)
+	c.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout, errs)
+	c.DefaultPersona = getEnv("DEFAULT_PERSONA", c.DefaultPersona)
+	c.RetentionMaxAge = getEnvDuration("RETENTION_MAX_AGE", c.RetentionMaxAge, errs)
+	c.RetentionMaxMessages = getEnvInt("RETENTION_MAX_MESSAGES", c.RetentionMaxMessages, errs)
+	c.RetentionMaxConversations = getEnvInt("RETENTION_MAX_CONVERSATIONS", c.RetentionMaxConversations, errs)
+	c.RetentionSweepInterval = getEnvDuration("RETENTION_SWEEP_INTERVAL", c.RetentionSweepInterval, errs)
+	c.EncryptionKeys = getEnvList("ENCRYPTION_KEYS", c.EncryptionKeys)
+	c.EncryptionKeyFile = getEnv("ENCRYPTION_KEY_FILE", c.EncryptionKeyFile)
+	c.RedactionMode = getEnv("REDACTION_MODE", c.RedactionMode)
+	c.RedactionDetectors = getEnvList("REDACTION_DETECTORS", c.RedactionDetectors)
+	c.RedactionRehydrate = getEnvBool("REDACTION_REHYDRATE", c.RedactionRehydrate, errs)
+	c.ModerationClassifier = getEnvList("MODERATION_CLASSIFIER", c.ModerationClassifier)
+	c.ModerationWindow = getEnvInt("MODERATION_WINDOW", c.ModerationWindow, errs)
+	c.InjectionAction = getEnv("INJECTION_ACTION", c.InjectionAction)
+	c.InjectionThreshold = getEnvFloat("INJECTION_THRESHOLD", c.InjectionThreshold, errs)
+	c.InjectionClassifier = getEnvBool("INJECTION_CLASSIFIER", c.InjectionClassifier, errs)
+	c.ToolMaxRounds = getEnvInt("TOOL_MAX_ROUNDS", c.ToolMaxRounds, errs)
+	c.BuiltinTools = getEnvList("BUILTIN_TOOLS", c.BuiltinTools)
+	c.FetchAllowlist = getEnvList("FETCH_ALLOWLIST", c.FetchAllowlist)
+	c.FetchMaxBytes = getEnvInt("FETCH_MAX_BYTES", c.FetchMaxBytes, errs)
+	c.FetchTimeout = getEnvDuration("FETCH_TIMEOUT", c.FetchTimeout, errs)
+	c.DocumentsDir = getEnv("DOCUMENTS_DIR", c.DocumentsDir)
+	c.TemplatesDir = getEnv("TEMPLATES_DIR", c.TemplatesDir)
+	c.DocumentChunkSize = getEnvInt("DOCUMENT_CHUNK_SIZE", c.DocumentChunkSize, errs)
+	c.DocumentChunkOverlap = getEnvInt("DOCUMENT_CHUNK_OVERLAP", c.DocumentChunkOverlap, errs)
+	c.RetrievalTopK = getEnvInt("RETRIEVAL_TOP_K", c.RetrievalTopK, errs)
+	c.EmbeddingProvider = getEnv("EMBEDDING_PROVIDER", c.EmbeddingProvider)
+	c.EmbeddingBaseURL = getEnv("EMBEDDING_BASE_URL", c.EmbeddingBaseURL)
+	c.EmbeddingAPIKey = getEnv("EMBEDDING_API_KEY", c.EmbeddingAPIKey)
+	c.EmbeddingModel = getEnv("EMBEDDING_MODEL", c.EmbeddingModel)
+	c.EmbeddingDimensions = getEnvInt("EMBEDDING_DIMENSIONS", c.EmbeddingDimensions, errs)
+	c.RetrievalMinSimilarity = getEnvFloat("RETRIEVAL_MIN_SIMILARITY", c.RetrievalMinSimilarity, errs)
+	c.ResponseCacheTTL = getEnvDuration("RESPONSE_CACHE_TTL", c.ResponseCacheTTL, errs)
+	c.ResponseCacheMaxEntries = getEnvInt("RESPONSE_CACHE_MAX_ENTRIES", c.ResponseCacheMaxEntries, errs)
+	c.ResponseCacheSimilarity = getEnvFloat("RESPONSE_CACHE_SIMILARITY", c.ResponseCacheSimilarity, errs)
+	c.StructuredOutputMode = getEnv("STRUCTURED_OUTPUT_MODE", c.StructuredOutputMode)
+	c.StructuredOutputRetries = getEnvInt("STRUCTURED_OUTPUT_RETRIES", c.StructuredOutputRetries, errs)
+}
+
+// Validate reports every setting that would stop the service from serving
+// chat traffic, joined into a single error.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.GroqAPIKey == "" {
+		errs = append(errs, errors.New("GROQ_API_KEY is required"))
+	}
+	if err := validateURL(c.GroqBaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("GROQ_BASE_URL %w", err))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be between 1 and 65535 (got %q)", c.Port))
+	}
+	if c.AppModel == "" {
+		errs = append(errs, errors.New("MODEL is required"))
+	}
+	if c.MaxTokens <= 0 {
+		errs = append(errs, fmt.Errorf("MAX_TOKENS must be positive (got %d)", c.MaxTokens))
+	}
+	if c.RateLimitRPS <= 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_RPS must be positive (got %d)", c.RateLimitRPS))
+	}
+	if c.RateLimitBurst <= 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_BURST must be positive (got %d)", c.RateLimitBurst))
+	}
+	if c.OTLPEndpoint != "" {
+		if err := validateURL(c.OTLPEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT %w", err))
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive (got %s)", c.ShutdownTimeout))
 	}
+	if c.RetentionMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_MAX_AGE must not be negative (got %s)", c.RetentionMaxAge))
+	}
+	if c.RetentionMaxMessages < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_MAX_MESSAGES must not be negative (got %d)", c.RetentionMaxMessages))
+	}
+	if c.RetentionMaxConversations < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_MAX_CONVERSATIONS must not be negative (got %d)", c.RetentionMaxConversations))
+	}
+	if c.RetentionSweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_SWEEP_INTERVAL must be positive (got %s)", c.RetentionSweepInterval))
+	}
+	if len(c.EncryptionKeys) > 0 && c.EncryptionKeyFile != "" {
+		errs = append(errs, errors.New("set only one of ENCRYPTION_KEYS and ENCRYPTION_KEY_FILE"))
+	}
+	if c.RedactionMode != "" {
+		if _, err := redact.New(c.RedactionOptions()); err != nil {
+			errs = append(errs, fmt.Errorf("REDACTION_MODE: %w", err))
+		}
+	}
+	if _, err := c.ModerationPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, st := range c.ModerationClassifier {
+		if st != string(chat.StageInput) && st != string(chat.StageOutput) {
+			errs = append(errs, fmt.Errorf("MODERATION_CLASSIFIER stages must be input or output (got %q)", st))
+		}
+	}
+	if c.ModerationWindow <= 0 {
+		errs = append(errs, fmt.Errorf("MODERATION_WINDOW must be positive (got %d)", c.ModerationWindow))
+	}
+	switch chat.InjectionAction(c.InjectionAction) {
+	case "", chat.InjectionBlock, chat.InjectionWarn, chat.InjectionTag:
+	default:
+		errs = append(errs, fmt.Errorf("INJECTION_ACTION must be block, warn or tag (got %q)", c.InjectionAction))
+	}
+	if c.InjectionThreshold <= 0 || c.InjectionThreshold > 1 {
+		errs = append(errs, fmt.Errorf("INJECTION_THRESHOLD must be above 0 and at most 1 (got %g)", c.InjectionThreshold))
+	}
+	if c.ToolMaxRounds <= 0 {
+		errs = append(errs, fmt.Errorf("TOOL_MAX_ROUNDS must be positive (got %d)", c.ToolMaxRounds))
+	}
+	for _, name := range c.BuiltinTools {
+		if !slices.Contains(tools.Names(), name) {
+			errs = append(errs, fmt.Errorf("BUILTIN_TOOLS must name %s (got %q)", strings.Join(tools.Names(), ", "), name))
+		}
+	}
+	if slices.Contains(c.BuiltinTools, "fetch") && len(c.FetchAllowlist) == 0 {
+		errs = append(errs, errors.New("FETCH_ALLOWLIST is required for the fetch tool"))
+	}
+	if c.FetchMaxBytes <= 0 {
+		errs = append(errs, fmt.Errorf("FETCH_MAX_BYTES must be positive (got %d)", c.FetchMaxBytes))
+	}
+	if c.FetchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("FETCH_TIMEOUT must be positive (got %s)", c.FetchTimeout))
+	}
+	if c.DocumentChunkSize < 100 {
+		errs = append(errs, fmt.Errorf("DOCUMENT_CHUNK_SIZE must be at least 100 (got %d)", c.DocumentChunkSize))
+	}
+	if c.DocumentChunkOverlap < 0 || c.DocumentChunkOverlap >= c.DocumentChunkSize/2 {
+		errs = append(errs, fmt.Errorf("DOCUMENT_CHUNK_OVERLAP must be at least 0 and less than half of DOCUMENT_CHUNK_SIZE (got %d)", c.DocumentChunkOverlap))
+	}
+	if c.RetrievalTopK <= 0 {
+		errs = append(errs, fmt.Errorf("RETRIEVAL_TOP_K must be positive (got %d)", c.RetrievalTopK))
+	}
+	switch c.EmbeddingProvider {
+	case "", "openai", "ollama", "hash":
+	default:
+		errs = append(errs, fmt.Errorf("EMBEDDING_PROVIDER must be openai, ollama or hash (got %q)", c.EmbeddingProvider))
+	}
+	if c.EmbeddingBaseURL != "" {
+		if err := validateURL(c.EmbeddingBaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("EMBEDDING_BASE_URL %w", err))
+		}
+	}
+	if c.EmbeddingDimensions < 0 {
+		errs = append(errs, fmt.Errorf("EMBEDDING_DIMENSIONS must not be negative (got %d)", c.EmbeddingDimensions))
+	}
+	if c.RetrievalMinSimilarity < 0 || c.RetrievalMinSimilarity > 1 {
+		errs = append(errs, fmt.Errorf("RETRIEVAL_MIN_SIMILARITY must be between 0 and 1 (got %g)", c.RetrievalMinSimilarity))
+	}
+	if c.ResponseCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("RESPONSE_CACHE_TTL must not be negative (got %s)", c.ResponseCacheTTL))
+	}
+	if c.ResponseCacheMaxEntries <= 0 {
+		errs = append(errs, fmt.Errorf("RESPONSE_CACHE_MAX_ENTRIES must be positive (got %d)", c.ResponseCacheMaxEntries))
+	}
+	if c.ResponseCacheSimilarity <= 0 || c.ResponseCacheSimilarity > 1 {
+		errs = append(errs, fmt.Errorf("RESPONSE_CACHE_SIMILARITY must be above 0 and at most 1 (got %g)", c.ResponseCacheSimilarity))
+	}
+	switch c.StructuredOutputMode {
+	case "json_schema", "json_object", "prompt":
+	default:
+		errs = append(errs, fmt.Errorf("STRUCTURED_OUTPUT_MODE must be json_schema, json_object or prompt (got %q)", c.StructuredOutputMode))
+	}
+	if c.StructuredOutputRetries < 0 {
+		errs = append(errs, fmt.Errorf("STRUCTURED_OUTPUT_RETRIES must not be negative (got %d)", c.StructuredOutputRetries))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.MCPServers)) {
+		srv := c.MCPServers[name]
+		if !mcpServerName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("mcp_servers name %q must use letters, digits, _ or -", name))
+		}
+		if (srv.Command == "") == (srv.URL == "") {
+			errs = append(errs, fmt.Errorf("mcp_servers %q needs exactly one of command or url", name))
+		}
+		if srv.URL != "" {
+			if err := validateURL(srv.URL); err != nil {
+				errs = append(errs, fmt.Errorf("mcp_servers %q url %w", name, err))
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Personas)) {
+		for _, srv := range c.Personas[name].MCPServers {
+			if _, ok := c.MCPServers[srv]; !ok {
+				errs = append(errs, fmt.Errorf("persona %q uses MCP server %q, which is not in mcp_servers", name, srv))
+			}
+		}
+	}
+	for _, t := range c.PromptTemplates() {
+		if c.Templates[t.Name].Version < 0 {
+			errs = append(errs, fmt.Errorf("templates %q version must not be negative", t.Name))
+		}
+		if err := templates.Check(t); err != nil {
+			errs = append(errs, fmt.Errorf("templates %q: %w", t.Name, err))
+		}
+	}
+	if c.DefaultPersona != "" {
+		if _, ok := c.Personas[c.DefaultPersona]; !ok {
+			errs = append(errs, fmt.Errorf("DEFAULT_PERSONA %q is not defined in personas", c.DefaultPersona))
+		}
+	}
+	return errors.Join(errs...)
 }
 
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http(s) URL (got %q)", raw)
+	}
+	return nil
+}
+
+// getEnv treats an empty variable as unset, so `KEY=` lines in .env files and
+// unset ${KEY} interpolations in docker-compose do not mask lower layers.
 func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
 		return value
 	}
 	return fallback
 }
 
-func getEnvInt(key string, fallback int) int {
+func getEnvInt(key string, fallback int, errs *[]error) int {
 	strValue := getEnv(key, "")
 	if strValue == "" {
 		return fallback
 	}
-	if value, err := strconv.Atoi(strValue); err == nil {
-		return value
+	value, err := strconv.Atoi(strValue)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be an integer (got %q)", key, strValue))
+		return fallback
 	}
-	return fallback
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration, errs *[]error) time.Duration {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(strValue)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a duration such as 30s (got %q)", key, strValue))
+		return fallback
+	}
+	return value
+}
+
+func getEnvFloat(key string, fallback float64, errs *[]error) float64 {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(strValue, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a number (got %q)", key, strValue))
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool, errs *[]error) bool {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	value, err := strconv.ParseBool(strValue)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be true or false (got %q)", key, strValue))
+		return fallback
+	}
+	return value
+}
+
+// EnvList reads a comma-separated list from the environment as Load does,
+// for commands that can run without a valid configuration.
+func EnvList(key string) []string {
+	return getEnvList(key, nil)
+}
+
+func getEnvList(key string, fallback []string) []string {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	var values []string
+	for _, v := range strings.Split(strValue, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
 }
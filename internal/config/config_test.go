@@ -0,0 +1,201 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayering(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+groq_api_key: from-file
+model: file-model
+max_tokens: 256
+rate_limit_rps: 5
+shutdown_timeout: 45s
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("GROQ_API_KEY", "")
+	t.Setenv("MODEL", "env-model")
+	t.Setenv("RATE_LIMIT_RPS", "7")
+
+	cfg, err := Load([]string{"-rate-limit-rps", "9"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.GroqAPIKey != "from-file" {
+		t.Errorf("Expected key from file, got %q", cfg.GroqAPIKey)
+	}
+	if cfg.MaxTokens != 256 {
+		t.Errorf("Expected max tokens from file, got %d", cfg.MaxTokens)
+	}
+	if cfg.AppModel != "env-model" {
+		t.Errorf("Expected env to override file, got %q", cfg.AppModel)
+	}
+	if cfg.RateLimitRPS != 9 {
+		t.Errorf("Expected flag to override env, got %d", cfg.RateLimitRPS)
+	}
+	if cfg.ShutdownTimeout != 45*time.Second {
+		t.Errorf("Expected shutdown timeout from file, got %s", cfg.ShutdownTimeout)
+	}
+	if cfg.RateLimitBurst != 20 {
+		t.Errorf("Expected default burst, got %d", cfg.RateLimitBurst)
+	}
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{"groq_api_key": "json-key", "port": "9090"}`)
+	t.Setenv("GROQ_API_KEY", "")
+	t.Setenv("PORT", "")
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.GroqAPIKey != "json-key" || cfg.Port != "9090" {
+		t.Errorf("Expected values from JSON file, got key=%q port=%q", cfg.GroqAPIKey, cfg.Port)
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := writeFile(t, "config.yaml", "groq_api_key: k\nrate_limt_rps: 5\n")
+
+	if _, err := Load([]string{"-config", path}); err == nil {
+		t.Error("Expected error for misspelled key")
+	}
+}
+
+func TestLoadAggregatesErrors(t *testing.T) {
+	t.Setenv("GROQ_API_KEY", "")
+	t.Setenv("MAX_TOKENS", "lots")
+	t.Setenv("RATE_LIMIT_RPS", "0")
+	t.Setenv("RATE_LIMIT_BURST", "-1")
+	t.Setenv("RETENTION_MAX_MESSAGES", "-5")
+	t.Setenv("RETENTION_SWEEP_INTERVAL", "daily")
+	t.Setenv("REDACTION_MODE", "shred")
+	t.Setenv("MODERATION_CLASSIFIER", "input,everything")
+	t.Setenv("MODERATION_WINDOW", "0")
+	t.Setenv("INJECTION_ACTION", "quarantine")
+	t.Setenv("INJECTION_THRESHOLD", "high")
+	t.Setenv("TOOL_MAX_ROUNDS", "0")
+	t.Setenv("BUILTIN_TOOLS", "clock,fetch,shell")
+	t.Setenv("DOCUMENT_CHUNK_OVERLAP", "600")
+	t.Setenv("RETRIEVAL_TOP_K", "0")
+	t.Setenv("EMBEDDING_PROVIDER", "word2vec")
+	t.Setenv("RETRIEVAL_MIN_SIMILARITY", "2")
+	t.Setenv("RESPONSE_CACHE_TTL", "-1h")
+	t.Setenv("STRUCTURED_OUTPUT_MODE", "grammar")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+
+	for _, want := range []string{
+		"GROQ_API_KEY is required",
+		`MAX_TOKENS must be an integer (got "lots")`,
+		"RATE_LIMIT_RPS must be positive",
+		"RATE_LIMIT_BURST must be positive",
+		"RETENTION_MAX_MESSAGES must not be negative",
+		`RETENTION_SWEEP_INTERVAL must be a duration such as 30s (got "daily")`,
+		`unknown redaction mode "shred"`,
+		`MODERATION_CLASSIFIER stages must be input or output (got "everything")`,
+		"MODERATION_WINDOW must be positive",
+		`INJECTION_ACTION must be block, warn or tag (got "quarantine")`,
+		`INJECTION_THRESHOLD must be a number (got "high")`,
+		"TOOL_MAX_ROUNDS must be positive",
+		`BUILTIN_TOOLS must name calculator, clock, fetch (got "shell")`,
+		"FETCH_ALLOWLIST is required for the fetch tool",
+		"DOCUMENT_CHUNK_OVERLAP must be at least 0 and less than half of DOCUMENT_CHUNK_SIZE (got 600)",
+		"RETRIEVAL_TOP_K must be positive",
+		"EMBEDDING_PROVIDER must be openai, ollama or hash",
+		"RETRIEVAL_MIN_SIMILARITY must be between 0 and 1",
+		"RESPONSE_CACHE_TTL must not be negative",
+		`STRUCTURED_OUTPUT_MODE must be json_schema, json_object or prompt (got "grammar")`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadMCPServers(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+groq_api_key: k
+mcp_servers:
+  files:
+    command: mcp-files
+    args: [--root, /srv]
+  search:
+    url: https://mcp.example.com/mcp
+  broken:
+    command: x
+    url: ftp://example.com
+  bad.name:
+    url: http://localhost:9000
+personas:
+  researcher:
+    system_prompt: Dig deep.
+    mcp_servers: [search, wiki]
+`)
+	_, err := Load([]string{"-config", path})
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, want := range []string{
+		`mcp_servers "broken" needs exactly one of command or url`,
+		`mcp_servers "broken" url must be an http(s) URL`,
+		`mcp_servers name "bad.name" must use letters, digits, _ or -`,
+		`persona "researcher" uses MCP server "wiki", which is not in mcp_servers`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), `"files"`) || strings.Contains(err.Error(), `"search"`) {
+		t.Errorf("Expected valid servers to pass, got:\n%v", err)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+groq_api_key: k
+templates:
+  incident:
+    version: 2
+    description: Incident summary
+    text: "Summarise incident {{.id}} in {{.words}} words."
+    variables:
+      - name: id
+        required: true
+      - name: words
+        type: integer
+        default: 100
+  broken:
+    text: "{{.nothing}}"
+`)
+	_, err := Load([]string{"-config", path})
+	if err == nil || !strings.Contains(err.Error(), `templates "broken": invalid template`) {
+		t.Fatalf("Expected the broken template reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), `"incident"`) {
+		t.Errorf("Expected the valid template to pass, got:\n%v", err)
+	}
+
+	cfg := &Config{Templates: map[string]PromptTemplate{"a": {Text: "x"}}}
+	if got := cfg.PromptTemplates(); len(got) != 1 || got[0].Name != "a" || got[0].Version != 1 || got[0].Source != "config" {
+		t.Errorf("Expected version 1 by default, got %+v", got)
+	}
+}
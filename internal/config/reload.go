@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Provider hands out the configuration in effect right now. Components that
+// support hot reload take a Provider and call Current once per unit of work,
+// so each request sees a consistent snapshot. *Config is a Provider that
+// never changes.
+type Provider interface {
+	Current() *Config
+}
+
+// Current returns c itself, letting a plain Config be used as a static Provider.
+func (c *Config) Current() *Config {
+	return c
+}
+
+// Holder is a Provider whose configuration can be reloaded from the same
+// sources it was first loaded from. An invalid reload leaves the previous
+// configuration in place.
+type Holder struct {
+	args    []string
+	current atomic.Pointer[Config]
+
+	mu      sync.Mutex // serialises reloads
+	modTime time.Time
+}
+
+// NewHolder wraps an already loaded cfg; args are replayed on every reload.
+func NewHolder(cfg *Config, args []string) *Holder {
+	h := &Holder{args: args}
+	h.current.Store(cfg)
+	if info, err := os.Stat(cfg.ConfigFile); err == nil {
+		h.modTime = info.ModTime()
+	}
+	return h
+}
+
+func (h *Holder) Current() *Config {
+	return h.current.Load()
+}
+
+// restartOnly lists the settings read once at startup. A reload swaps them
+// in like the rest, but they take effect after a restart.
+var restartOnly = []string{
+	"Port", "OTLPEndpoint", "ServiceName", "HistoryDir",
+	"EncryptionKeys", "EncryptionKeyFile",
+	"ModerationWindow", "ToolMaxRounds",
+	"BuiltinTools", "FetchAllowlist", "FetchMaxBytes", "FetchTimeout",
+	"MCPServers",
+	"DocumentsDir", "DocumentChunkSize", "DocumentChunkOverlap", "RetrievalTopK",
+	"EmbeddingProvider", "EmbeddingBaseURL", "EmbeddingAPIKey", "EmbeddingModel", "EmbeddingDimensions", "RetrievalMinSimilarity",
+	"ResponseCacheTTL", "ResponseCacheMaxEntries", "ResponseCacheSimilarity",
+	"StructuredOutputRetries", "TemplatesDir",
+}
+
+// restartChanges returns the config file names of the restart-only
+// settings that differ between prev and next.
+func restartChanges(prev, next *Config) []string {
+	var changed []string
+	p, n := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for _, name := range restartOnly {
+		if reflect.DeepEqual(p.FieldByName(name).Interface(), n.FieldByName(name).Interface()) {
+			continue
+		}
+		field, _ := p.Type().FieldByName(name)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			key = name
+		}
+		changed = append(changed, key)
+	}
+	return changed
+}
+
+// Reload loads and validates the configuration again and swaps it in
+// atomically. Settings only read at startup (see restartOnly) are swapped
+// too but take effect after a restart, which is logged.
+func (h *Holder) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	next, err := Load(h.args)
+	if err != nil {
+		return fmt.Errorf("reload rejected, keeping previous configuration: %w", err)
+	}
+
+	if changed := restartChanges(h.current.Load(), next); len(changed) > 0 {
+		slog.Warn("Settings changed that apply after a restart", "settings", changed)
+	}
+
+	if info, err := os.Stat(next.ConfigFile); err == nil {
+		h.modTime = info.ModTime()
+	}
+	h.current.Store(next)
+	return nil
+}
+
+// Watch polls the config file every interval and reloads when its
+// modification time changes, until ctx is cancelled. Results are reported
+// to onReload. It returns immediately when no config file is in use.
+func (h *Holder) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	path := h.Current().ConfigFile
+	if path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			// Record the new time before reloading so a broken file is
+			// reported once rather than on every tick.
+			h.mu.Lock()
+			changed := !info.ModTime().Equal(h.modTime)
+			h.modTime = info.ModTime()
+			h.mu.Unlock()
+			if changed {
+				onReload(h.Reload())
+			}
+		}
+	}
+}
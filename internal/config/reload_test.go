@@ -0,0 +1,93 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHolderReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "groq_api_key: k\nrate_limit_rps: 5\n")
+	t.Setenv("GROQ_API_KEY", "")
+	t.Setenv("RATE_LIMIT_RPS", "")
+	args := []string{"-config", path}
+
+	cfg, err := Load(args)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	h := NewHolder(cfg, args)
+
+	t.Run("Valid Reload", func(t *testing.T) {
+		os.WriteFile(path, []byte("groq_api_key: k\nrate_limit_rps: 50\napi_keys: [a, b]\n"), 0o600)
+		if err := h.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+		if got := h.Current().RateLimitRPS; got != 50 {
+			t.Errorf("Expected reloaded rate limit 50, got %d", got)
+		}
+		if got := len(h.Current().ClientKeys()); got != 2 {
+			t.Errorf("Expected 2 client keys, got %d", got)
+		}
+	})
+
+	t.Run("Invalid Reload Keeps Previous", func(t *testing.T) {
+		before := h.Current()
+		os.WriteFile(path, []byte("groq_api_key: k\nrate_limit_rps: -1\n"), 0o600)
+		if err := h.Reload(); err == nil {
+			t.Fatal("Expected reload to be rejected")
+		}
+		if h.Current() != before {
+			t.Error("Expected previous configuration to be kept")
+		}
+	})
+}
+
+func TestRestartChanges(t *testing.T) {
+	prev := &Config{Port: "8080", FetchAllowlist: []string{"a.example", "b.example"}, EncryptionKeys: []string{"k"}, RateLimitRPS: 5}
+	next := &Config{Port: "8080", FetchAllowlist: []string{"a.example"}, EncryptionKeys: []string{"k", "l"}, RateLimitRPS: 50}
+	if got := strings.Join(restartChanges(prev, next), ","); got != "EncryptionKeys,fetch_allowlist" {
+		t.Errorf("Expected the allowlist and keys reported, got %q", got)
+	}
+	for _, name := range restartOnly {
+		if _, ok := reflect.TypeOf(Config{}).FieldByName(name); !ok {
+			t.Errorf("Unknown setting %q", name)
+		}
+	}
+}
+
+func TestHolderWatch(t *testing.T) {
+	path := writeFile(t, "config.yaml", "groq_api_key: k\nmodel: first\n")
+	t.Setenv("GROQ_API_KEY", "")
+	t.Setenv("MODEL", "")
+	args := []string{"-config", path}
+
+	cfg, err := Load(args)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	h := NewHolder(cfg, args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 1)
+	go h.Watch(ctx, 10*time.Millisecond, func(err error) { reloaded <- err })
+
+	os.WriteFile(path, []byte("groq_api_key: k\nmodel: second\n"), 0o600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for file change to be picked up")
+	}
+	if got := h.Current().AppModel; got != "second" {
+		t.Errorf("Expected model 'second', got %q", got)
+	}
+}
@@ -0,0 +1,189 @@
+// Package encryption implements envelope encryption: content is sealed with
+// AES-256-GCM under a data key, and data keys are stored wrapped by a
+// key-encryption key (KEK) from a Keyring.
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of KEKs and data keys in bytes (AES-256).
+const KeySize = 32
+
+// ErrUnknownKey is returned when a wrapped key names a KEK that is not in
+// the keyring.
+var ErrUnknownKey = errors.New("unknown key-encryption key")
+
+// WrappedKey is a data key encrypted under the KEK named by KeyID.
+type WrappedKey struct {
+	KeyID string `json:"kek_id"`
+	Key   []byte `json:"wrapped_key"`
+}
+
+// Keyring holds the KEKs. The primary KEK wraps new data keys; the others
+// can still unwrap keys wrapped before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseKeyring builds a keyring from entries of the form "id:base64key".
+// The first entry is the primary KEK.
+func ParseKeyring(entries []string) (*Keyring, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no encryption keys given")
+	}
+	k := &Keyring{keys: make(map[string][]byte, len(entries))}
+	for i, entry := range entries {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption key %d must look like id:base64key", i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes of base64", id, KeySize)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %q is listed twice", id)
+		}
+		k.keys[id] = key
+		if i == 0 {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the KEKs from entries, or from path when entries is
+// empty. The file holds one "id:base64key" entry per line; blank lines and
+// lines starting with # are ignored. It returns nil, nil when neither
+// source is set, meaning encryption is disabled.
+func LoadKeyring(entries []string, path string) (*Keyring, error) {
+	if len(entries) == 0 && path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open encryption key file: %w", err)
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+	}
+	if len(entries) == 0 && path == "" {
+		return nil, nil
+	}
+	return ParseKeyring(entries)
+}
+
+// PrimaryID names the KEK used to wrap new data keys.
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+// NewDataKey returns a random data key and its wrapped form.
+func (k *Keyring) NewDataKey() ([]byte, WrappedKey, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, WrappedKey{}, err
+	}
+	w, err := k.Wrap(key)
+	if err != nil {
+		return nil, WrappedKey{}, err
+	}
+	return key, w, nil
+}
+
+// Wrap encrypts a data key under the primary KEK.
+func (k *Keyring) Wrap(key []byte) (WrappedKey, error) {
+	sealed, err := seal(k.keys[k.primary], key, []byte(k.primary))
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{KeyID: k.primary, Key: sealed}, nil
+}
+
+// Unwrap decrypts a data key with the KEK it was wrapped under.
+func (k *Keyring) Unwrap(w WrappedKey) ([]byte, error) {
+	kek, ok := k.keys[w.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, w.KeyID)
+	}
+	key, err := open(kek, w.Key, []byte(w.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %q: %w", w.KeyID, err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext under a data key and returns it base64 encoded.
+// aad binds the ciphertext to its context, e.g. the record it belongs to,
+// so it cannot be moved elsewhere undetected.
+func Seal(key []byte, plaintext, aad string) (string, error) {
+	sealed, err := seal(key, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open reverses Seal; aad must match the value used to seal.
+func Open(key []byte, ciphertext, aad string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	plaintext, err := open(key, sealed, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// seal returns nonce || AES-GCM ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
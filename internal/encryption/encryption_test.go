@@ -0,0 +1,73 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func TestSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	sealed, err := Seal(key, "secret", "conv/msg")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if got, err := Open(key, sealed, "conv/msg"); err != nil || got != "secret" {
+		t.Errorf("Expected round trip, got %q, %v", got, err)
+	}
+	if _, err := Open(key, sealed, "conv/other"); err == nil {
+		t.Error("Expected ciphertext moved to another record to be rejected")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, _ := ParseKeyring([]string{"k1:" + testKey(1)})
+	key, wrapped, err := old.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+
+	rotated, _ := ParseKeyring([]string{"k2:" + testKey(2), "k1:" + testKey(1)})
+	if rotated.PrimaryID() != "k2" {
+		t.Errorf("Expected first key to be primary, got %q", rotated.PrimaryID())
+	}
+	got, err := rotated.Unwrap(wrapped)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Expected old data key to unwrap after rotation, got %v", err)
+	}
+	rewrapped, _ := rotated.Wrap(got)
+	if rewrapped.KeyID != "k2" {
+		t.Errorf("Expected rewrap under the primary key, got %q", rewrapped.KeyID)
+	}
+
+	retired, _ := ParseKeyring([]string{"k2:" + testKey(2)})
+	if _, err := retired.Unwrap(wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey after retiring k1, got %v", err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	if k, err := LoadKeyring(nil, ""); k != nil || err != nil {
+		t.Errorf("Expected encryption off without keys, got %v, %v", k, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte("# rotated 2026-01\nk2:"+testKey(2)+"\n\nk1:"+testKey(1)+"\n"), 0o600)
+	k, err := LoadKeyring(nil, path)
+	if err != nil || k.PrimaryID() != "k2" {
+		t.Fatalf("Expected k2 primary from file, got %v", err)
+	}
+
+	for _, bad := range [][]string{{"nokey"}, {"k1:short"}, {"k1:" + testKey(1), "k1:" + testKey(2)}} {
+		if _, err := LoadKeyring(bad, ""); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
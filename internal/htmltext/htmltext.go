@@ -0,0 +1,83 @@
+// Package htmltext reduces HTML documents to readable text.
+package htmltext
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skipped elements hold no readable text.
+var skipped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Iframe: true, atom.Head: true,
+}
+
+// blocks start a new line.
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Tr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Pre: true, atom.Blockquote: true, atom.Table: true, atom.Ul: true, atom.Ol: true,
+	atom.Dt: true, atom.Dd: true, atom.Hr: true, atom.Main: true, atom.Nav: true,
+}
+
+// Extract returns the title and readable text of an HTML document, one
+// line per block element.
+func Extract(doc string) (title, text string) {
+	z := html.NewTokenizer(strings.NewReader(doc))
+	var (
+		lines   []string
+		line    strings.Builder
+		skip    int
+		inTitle bool
+	)
+	breakLine := func() {
+		if s := strings.Join(strings.Fields(line.String()), " "); s != "" {
+			lines = append(lines, s)
+		}
+		line.Reset()
+	}
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			breakLine()
+			return title, strings.Join(lines, "\n")
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch {
+			case tok.DataAtom == atom.Title:
+				inTitle = true
+			case skipped[tok.DataAtom]:
+				if tok.Type != html.SelfClosingTagToken {
+					skip++
+				}
+			case blocks[tok.DataAtom]:
+				breakLine()
+				if tok.DataAtom == atom.Li {
+					line.WriteString("- ")
+				}
+			case tok.DataAtom == atom.Td || tok.DataAtom == atom.Th:
+				line.WriteString(" ")
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			switch {
+			case tok.DataAtom == atom.Title:
+				inTitle = false
+			case skipped[tok.DataAtom]:
+				skip = max(0, skip-1)
+			case blocks[tok.DataAtom]:
+				breakLine()
+			}
+		case html.TextToken:
+			switch {
+			case inTitle:
+				title = strings.Join(strings.Fields(string(z.Text())), " ")
+			case skip == 0:
+				line.Write(z.Text())
+			}
+		}
+	}
+}
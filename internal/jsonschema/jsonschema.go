@@ -0,0 +1,535 @@
+// Package jsonschema validates JSON values against a JSON Schema. It covers
+// the keywords used to describe structured output: types, properties,
+// items, enums, bounds, patterns, composition and local $ref. Other
+// keywords, such as format and annotations, are ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxProblems bounds the problems reported for one value.
+	maxProblems = 20
+	// maxDepth bounds schema nesting while validating.
+	maxDepth = 100
+	// maxSteps bounds the schema nodes visited for one value. Recursive
+	// references under anyOf or oneOf can otherwise take exponential time
+	// on values that do not match.
+	maxSteps = 100_000
+)
+
+var types = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// Schema is a compiled schema. It is safe for concurrent use.
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// ValidationError lists where and how a value does not match a schema.
+type ValidationError struct {
+	// Problems are "path: message" entries, the path being a JSON pointer
+	// into the value.
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Compile parses a schema and checks that its keywords are well formed and
+// its references resolve.
+func Compile(data []byte) (*Schema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	graph := make(map[string][]string)
+	if err := s.check(root, "#", graph); err != nil {
+		return nil, err
+	}
+	if err := findCycle(graph); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check walks a schema node, compiling patterns and resolving references.
+// It records in graph, by JSON pointer, every schema and the schemas it
+// applies to the same value: its $ref, allOf, anyOf, oneOf and not.
+func (s *Schema) check(node any, at string, graph map[string][]string) error {
+	graph[at] = nil
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: a schema must be an object or a boolean", at)
+	}
+
+	switch t := obj["type"].(type) {
+	case nil:
+	case string:
+		if !slices.Contains(types, t) {
+			return fmt.Errorf("%s: unknown type %q", at, t)
+		}
+	case []any:
+		for _, v := range t {
+			if name, ok := v.(string); !ok || !slices.Contains(types, name) {
+				return fmt.Errorf("%s: unknown type %v", at, v)
+			}
+		}
+	default:
+		return fmt.Errorf("%s: type must be a string or an array", at)
+	}
+
+	for _, kw := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+		"minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties"} {
+		if v, ok := obj[kw]; ok {
+			if _, ok := v.(float64); !ok {
+				return fmt.Errorf("%s: %s must be a number", at, kw)
+			}
+		}
+	}
+	if v, ok := obj["enum"]; ok {
+		if _, ok := v.([]any); !ok {
+			return fmt.Errorf("%s: enum must be an array", at)
+		}
+	}
+	if v, ok := obj["required"]; ok {
+		list, ok := v.([]any)
+		for _, name := range list {
+			if _, isString := name.(string); !isString {
+				ok = false
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: required must be an array of strings", at)
+		}
+	}
+	if v, ok := obj["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: pattern must be a string", at)
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", at, err)
+		}
+		s.patterns[p] = re
+	}
+	if v, ok := obj["$ref"]; ok {
+		ref, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: $ref must be a string", at)
+		}
+		if _, err := s.resolve(ref); err != nil {
+			return fmt.Errorf("%s: %w", at, err)
+		}
+		graph[at] = append(graph[at], ref)
+	}
+
+	if _, ok := obj["items"].([]any); ok {
+		return fmt.Errorf("%s: items must be a schema; use prefixItems for tuples", at)
+	}
+	for _, kw := range []string{"items", "additionalProperties", "not"} {
+		if sub, ok := obj[kw]; ok {
+			if err := s.check(sub, at+"/"+kw, graph); err != nil {
+				return err
+			}
+			if kw == "not" {
+				graph[at] = append(graph[at], at+"/not")
+			}
+		}
+	}
+	for _, kw := range []string{"properties", "$defs", "definitions"} {
+		if v, ok := obj[kw]; ok {
+			subs, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: %s must be an object", at, kw)
+			}
+			for name, sub := range subs {
+				if err := s.check(sub, at+"/"+kw+"/"+escape(name), graph); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, kw := range []string{"allOf", "anyOf", "oneOf", "prefixItems"} {
+		if v, ok := obj[kw]; ok {
+			subs, ok := v.([]any)
+			if !ok || (len(subs) == 0 && kw != "prefixItems") {
+				return fmt.Errorf("%s: %s must be a non-empty array", at, kw)
+			}
+			for i, sub := range subs {
+				sat := fmt.Sprintf("%s/%s/%d", at, kw, i)
+				if err := s.check(sub, sat, graph); err != nil {
+					return err
+				}
+				if kw != "prefixItems" {
+					graph[at] = append(graph[at], sat)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findCycle rejects references that lead back to a schema without
+// descending into the value, as validating against one would never end.
+func findCycle(graph map[string][]string) error {
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := make(map[string]int, len(graph))
+	var visit func(at string) error
+	visit = func(at string) error {
+		switch state[at] {
+		case visiting:
+			return fmt.Errorf("%s: references loop back here without descending into the value", at)
+		case done:
+			return nil
+		}
+		state[at] = visiting
+		for _, next := range graph[at] {
+			if _, ok := graph[next]; !ok {
+				return fmt.Errorf("%s: reference %q does not point to a schema", at, next)
+			}
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[at] = done
+		return nil
+	}
+	for _, at := range slices.Sorted(maps.Keys(graph)) {
+		if err := visit(at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve follows a reference within the schema, such as "#/$defs/item".
+func (s *Schema) resolve(ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local references are supported (got %q)", ref)
+	}
+	node := s.root
+	for _, token := range strings.Split(ref, "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch n := node.(type) {
+		case map[string]any:
+			node = n[token]
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("reference %q does not resolve", ref)
+			}
+			node = n[i]
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, fmt.Errorf("reference %q does not resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate checks a value decoded by encoding/json into an any.
+func (s *Schema) Validate(value any) error {
+	var problems []string
+	budget := maxSteps
+	s.validate(s.root, value, "", 0, &budget, &problems)
+	if budget < 0 {
+		return &ValidationError{Problems: []string{"/: the schema is too complex to check this value against"}}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// ValidateJSON parses data and validates it.
+func (s *Schema) ValidateJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &ValidationError{Problems: []string{"not valid JSON: " + err.Error()}}
+	}
+	return s.Validate(value)
+}
+
+// validate checks value against node, spending one step of budget per
+// node visited.
+func (s *Schema) validate(node, value any, path string, depth int, budget *int, problems *[]string) {
+	report := func(format string, args ...any) {
+		if len(*problems) < maxProblems {
+			where := path
+			if where == "" {
+				where = "/"
+			}
+			*problems = append(*problems, where+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	if *budget--; *budget < 0 {
+		report("the schema is too complex to check")
+		return
+	}
+	if depth > maxDepth {
+		report("schema nesting is too deep")
+		return
+	}
+	if b, ok := node.(bool); ok {
+		if !b {
+			report("no value is allowed here")
+		}
+		return
+	}
+	obj, _ := node.(map[string]any)
+
+	if ref, ok := obj["$ref"].(string); ok {
+		// Checked when compiled.
+		target, _ := s.resolve(ref)
+		s.validate(target, value, path, depth+1, budget, problems)
+	}
+
+	if t, ok := obj["type"]; ok && !matchesType(t, value) {
+		report("must be %s, not %s", typeList(t), typeOf(value))
+		return
+	}
+	if enum, ok := obj["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return equal(e, value) }) {
+		report("must be one of %s", compact(enum))
+	}
+	if c, ok := obj["const"]; ok && !equal(c, value) {
+		report("must be %s", compact(c))
+	}
+
+	switch v := value.(type) {
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := obj["minLength"].(float64); ok && n < min {
+			report("must be at least %g characters long", min)
+		}
+		if max, ok := obj["maxLength"].(float64); ok && n > max {
+			report("must be at most %g characters long", max)
+		}
+		if p, ok := obj["pattern"].(string); ok && !s.patterns[p].MatchString(v) {
+			report("must match the pattern %q", p)
+		}
+	case float64:
+		if min, ok := obj["minimum"].(float64); ok && v < min {
+			report("must be at least %g", min)
+		}
+		if max, ok := obj["maximum"].(float64); ok && v > max {
+			report("must be at most %g", max)
+		}
+		if min, ok := obj["exclusiveMinimum"].(float64); ok && v <= min {
+			report("must be greater than %g", min)
+		}
+		if max, ok := obj["exclusiveMaximum"].(float64); ok && v >= max {
+			report("must be less than %g", max)
+		}
+		if m, ok := obj["multipleOf"].(float64); ok && m > 0 {
+			if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				report("must be a multiple of %g", m)
+			}
+		}
+	case []any:
+		n := float64(len(v))
+		if min, ok := obj["minItems"].(float64); ok && n < min {
+			report("must have at least %g items", min)
+		}
+		if max, ok := obj["maxItems"].(float64); ok && n > max {
+			report("must have at most %g items", max)
+		}
+		if unique, _ := obj["uniqueItems"].(bool); unique {
+		outer:
+			for i := range v {
+				for j := range i {
+					if equal(v[i], v[j]) {
+						report("items %d and %d must not be equal", j, i)
+						break outer
+					}
+				}
+			}
+		}
+		prefix, _ := obj["prefixItems"].([]any)
+		for i, item := range v {
+			if i < len(prefix) {
+				s.validate(prefix[i], item, path+"/"+strconv.Itoa(i), depth+1, budget, problems)
+			} else if items, ok := obj["items"]; ok {
+				s.validate(items, item, path+"/"+strconv.Itoa(i), depth+1, budget, problems)
+			}
+		}
+	case map[string]any:
+		n := float64(len(v))
+		if min, ok := obj["minProperties"].(float64); ok && n < min {
+			report("must have at least %g properties", min)
+		}
+		if max, ok := obj["maxProperties"].(float64); ok && n > max {
+			report("must have at most %g properties", max)
+		}
+		required, _ := obj["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				report("missing required property %q", name)
+			}
+		}
+		props, _ := obj["properties"].(map[string]any)
+		additional, hasAdditional := obj["additionalProperties"]
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			at := path + "/" + escape(k)
+			if sub, ok := props[k]; ok {
+				s.validate(sub, v[k], at, depth+1, budget, problems)
+			} else if hasAdditional {
+				if b, ok := additional.(bool); ok && !b {
+					report("property %q is not allowed", k)
+				} else {
+					s.validate(additional, v[k], at, depth+1, budget, problems)
+				}
+			}
+		}
+	}
+
+	if all, ok := obj["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(sub, value, path, depth+1, budget, problems)
+		}
+	}
+	if anyOf, ok := obj["anyOf"].([]any); ok && s.matching(anyOf, value, path, depth, 1, budget) == 0 {
+		report("must match at least one of the anyOf schemas")
+	}
+	if oneOf, ok := obj["oneOf"].([]any); ok {
+		if n := s.matching(oneOf, value, path, depth, 2, budget); n != 1 {
+			report("must match exactly one of the oneOf schemas, matched %s", map[int]string{0: "none", 2: "more than one"}[n])
+		}
+	}
+	if not, ok := obj["not"]; ok && s.matching([]any{not}, value, path, depth, 1, budget) == 1 {
+		report("must not match the not schema")
+	}
+}
+
+// matching counts the schemas value is valid against, stopping at limit.
+func (s *Schema) matching(schemas []any, value any, path string, depth, limit int, budget *int) int {
+	n := 0
+	for _, sub := range schemas {
+		var problems []string
+		s.validate(sub, value, path, depth+1, budget, &problems)
+		if len(problems) == 0 {
+			if n++; n == limit {
+				break
+			}
+		}
+	}
+	return n
+}
+
+func matchesType(t, value any) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, value)
+	case []any:
+		return slices.ContainsFunc(t, func(name any) bool { return isType(name.(string), value) })
+	}
+	return true
+}
+
+func isType(name string, value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case float64:
+		return name == "number" || (name == "integer" && v == math.Trunc(v) && !math.IsInf(v, 0))
+	case []any:
+		return name == "array"
+	case map[string]any:
+		return name == "object"
+	}
+	return false
+}
+
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeList(t any) string {
+	if names, ok := t.([]any); ok {
+		parts := make([]string, len(names))
+		for i, n := range names {
+			parts[i] = n.(string)
+		}
+		return strings.Join(parts, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// escape encodes a key as a JSON pointer token.
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// equal compares decoded JSON values; numbers are all float64.
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func compact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// Problems returns the problems listed by a *ValidationError, or the error
+// text of any other error.
+func Problems(err error) []string {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Problems
+	}
+	if err == nil {
+		return nil
+	}
+	return []string{err.Error()}
+}
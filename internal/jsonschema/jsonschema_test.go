@@ -0,0 +1,117 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"email": {"type": ["string", "null"]},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3, "uniqueItems": true},
+		"address": {"$ref": "#/$defs/address"},
+		"contact": {"oneOf": [{"required": ["phone"]}, {"required": ["fax"]}]}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {
+		"address": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	valid := `{"name":"Ada","age":36,"email":null,"role":"admin","tags":["a","b"],"address":{"city":"London"},"contact":{"phone":"1"}}`
+	if err := s.ValidateJSON([]byte(valid)); err != nil {
+		t.Errorf("Expected a valid value, got %v", err)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`[]`, "/: must be object, not array"},
+		{`{"age":1}`, `/: missing required property "name"`},
+		{`{"name":"ada","age":1}`, `/name: must match the pattern "^[A-Z]"`},
+		{`{"name":"Ada","age":1.5}`, "/age: must be integer, not number"},
+		{`{"name":"Ada","age":150}`, "/age: must be less than 150"},
+		{`{"name":"Ada","age":1,"role":"owner"}`, `/role: must be one of ["admin","user"]`},
+		{`{"name":"Ada","age":1,"tags":["a","a"]}`, "/tags: items 0 and 1 must not be equal"},
+		{`{"name":"Ada","age":1,"tags":["a",2]}`, "/tags/1: must be string, not integer"},
+		{`{"name":"Ada","age":1,"address":{}}`, `/address: missing required property "city"`},
+		{`{"name":"Ada","age":1,"extra":true}`, `/: property "extra" is not allowed`},
+		{`{"name":"Ada","age":1,"contact":{"phone":"1","fax":"2"}}`, "/contact: must match exactly one of the oneOf schemas, matched more than one"},
+		{`{"name":`, "not valid JSON"},
+	}
+	for _, tt := range tests {
+		err := s.ValidateJSON([]byte(tt.value))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected %q for %s, got %v", tt.want, tt.value, err)
+		}
+	}
+
+	err = s.ValidateJSON([]byte(`{"name":"","age":-1}`))
+	if problems := Problems(err); len(problems) != 3 {
+		t.Errorf("Expected every problem reported, got %q", problems)
+	}
+}
+
+func TestCompileRejectsMalformedSchemas(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "text"}`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`{"required": "name"}`,
+		`{"anyOf": []}`,
+		`{"properties": {"a": 1}}`,
+		`[]`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("Expected %s rejected", schema)
+		}
+	}
+}
+
+func TestRecursiveRef(t *testing.T) {
+	s, err := Compile([]byte(`{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#"}}}}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if err := s.ValidateJSON([]byte(`{"children":[{"children":[]},{"children":[{"children":1}]}]}`)); err == nil || !strings.Contains(err.Error(), "/children/1/children/0/children") {
+		t.Errorf("Expected a nested problem, got %v", err)
+	}
+	for _, loop := range []string{
+		`{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`,
+		`{"$defs":{"a":{"anyOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}},"$ref":"#/$defs/a"}`,
+		`{"not":{"allOf":[{"$ref":"#"}]}}`,
+		`{"$defs":{"not":{"$ref":"#/$defs"}},"$ref":"#/$defs"}`,
+	} {
+		if _, err := Compile([]byte(loop)); err == nil {
+			t.Errorf("Expected %s rejected rather than followed forever", loop)
+		}
+	}
+}
+
+func TestValidationBudget(t *testing.T) {
+	// Each level tries both branches when the innermost value is wrong.
+	s, err := Compile([]byte(`{"$defs":{"a":{"anyOf":[{"type":"array","items":{"$ref":"#/$defs/a"}},{"type":"array","items":{"$ref":"#/$defs/a"}},{"type":"integer"}]}},"$ref":"#/$defs/a"}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if err := s.ValidateJSON([]byte(strings.Repeat("[", 8) + "1" + strings.Repeat("]", 8))); err != nil {
+		t.Errorf("Expected a matching value, got %v", err)
+	}
+	deep := strings.Repeat("[", 40) + `"x"` + strings.Repeat("]", 40)
+	if err := s.ValidateJSON([]byte(deep)); err == nil || !strings.Contains(err.Error(), "too complex") {
+		t.Errorf("Expected validation to stop, got %v", err)
+	}
+}
@@ -3,56 +3,203 @@ package llm
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"chat-service/internal/chat"
 	"chat-service/internal/config"
+	"chat-service/internal/requestid"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const groqAPIURL = "https://api.groq.com/openai/v1/chat/completions"
+var tracer = otel.Tracer("chat-service/internal/llm")
 
+// Client talks to Groq's OpenAI-compatible API. Key, endpoint, model and
+// token limit are read from the current configuration on every call, so a
+// reload applies to the next request.
 type Client struct {
-	apiKey    string
-	model     string
-	maxTokens int
-	client    *http.Client
+	cfg    config.Provider
+	client *http.Client
+
+	health healthCache
 }
 
-func NewClient(cfg *config.Config) *Client {
+func NewClient(cfg config.Provider) *Client {
 	return &Client{
-		apiKey:    cfg.GroqAPIKey,
-		model:     cfg.AppModel,
-		maxTokens: cfg.MaxTokens,
-		client:    &http.Client{},
+		cfg:    cfg,
+		client: &http.Client{},
 	}
 }
 
+func baseURL(cfg *config.Config) string {
+	return strings.TrimSuffix(cfg.GroqBaseURL, "/")
+}
+
+type groqMessage struct {
+	Role       chat.Role      `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []groqToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type groqToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function groqFunctionCall `json:"function"`
+}
+
+type groqFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type groqTool struct {
+	Type     string       `json:"type"`
+	Function groqFunction `json:"function"`
+}
+
+type groqFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
 type groqRequest struct {
-	Model     string         `json:"model"`
-	Messages  []chat.Message `json:"messages"`
-	MaxTokens int            `json:"max_tokens,omitempty"`
-	Stream    bool           `json:"stream"`
+	Model          string               `json:"model"`
+	Messages       []groqMessage        `json:"messages"`
+	Tools          []groqTool           `json:"tools,omitempty"`
+	ResponseFormat *chat.ResponseFormat `json:"response_format,omitempty"`
+	MaxTokens      int                  `json:"max_tokens,omitempty"`
+	Stream         bool                 `json:"stream"`
 }
 
+// maxToolCalls bounds the tool call index accepted from a stream.
+const maxToolCalls = 64
+
 type groqStreamResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Delta struct {
 			Content string `json:"content"`
+			// ToolCalls arrive in fragments: the first delta for an index
+			// carries the ID and name, later ones append to the arguments.
+			ToolCalls []struct {
+				Index    int              `json:"index"`
+				ID       string           `json:"id"`
+				Function groqFunctionCall `json:"function"`
+			} `json:"tool_calls"`
 		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	// Groq reports usage on the final chunk under x_groq; OpenAI-compatible
+	// servers use the top-level field.
+	Usage *chat.Usage `json:"usage"`
+	XGroq *struct {
+		Usage *chat.Usage `json:"usage"`
+	} `json:"x_groq"`
+}
+
+// StreamChat sends messages to Groq and returns a channel of streamed content,
+// ending with a chunk that carries the model, finish reason and usage.
+// The request carries the W3C trace context from ctx, and the client span
+// stays open until the stream is drained so it reflects the full generation.
+func (c *Client) StreamChat(ctx context.Context, messages []chat.Message) (<-chan chat.Chunk, error) {
+	return c.StreamChatWithTools(ctx, messages, nil)
+}
+
+// StreamChatWithTools is StreamChat offering tools to the model. Tool calls
+// are assembled from the streamed fragments and delivered on the final
+// chunk.
+func (c *Client) StreamChatWithTools(ctx context.Context, messages []chat.Message, tools []chat.ToolDefinition) (<-chan chat.Chunk, error) {
+	return c.StreamChatWithFormat(ctx, messages, tools, nil)
+}
+
+// StreamChatWithFormat is StreamChatWithTools asking for format as the
+// request's response_format, as far as StructuredOutputMode allows.
+func (c *Client) StreamChatWithFormat(ctx context.Context, messages []chat.Message, tools []chat.ToolDefinition, format *chat.ResponseFormat) (<-chan chat.Chunk, error) {
+	start := time.Now()
+	cfg := c.cfg.Current()
+	format = responseFormat(cfg, format, tools)
+	ctx, span := tracer.Start(ctx, "chat "+cfg.AppModel,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.GenAIProviderNameGroq,
+			semconv.GenAIOperationNameChat,
+			semconv.GenAIRequestModel(cfg.AppModel),
+			semconv.GenAIRequestMaxTokens(cfg.MaxTokens),
+			attribute.Int("llm.request.messages", len(messages)),
+			attribute.Int("llm.request.tools", len(tools)),
+		),
+	)
+	if format != nil {
+		span.SetAttributes(attribute.String("llm.request.response_format", format.Type))
+	}
+
+	stream, err := c.startStream(ctx, cfg, messages, tools, format)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+	return c.relay(span, start, stream), nil
 }
 
-// StreamChat sends messages to Groq and returns a channel of streamed content.
-func (c *Client) StreamChat(messages []chat.Message) (<-chan string, error) {
+// responseFormat returns the response_format to send for format, or nil.
+// Providers reject a response format alongside tools, so the model is left
+// to its instructions while tools are offered.
+func responseFormat(cfg *config.Config, format *chat.ResponseFormat, tools []chat.ToolDefinition) *chat.ResponseFormat {
+	if format == nil || len(tools) > 0 {
+		return nil
+	}
+	switch cfg.StructuredOutputMode {
+	case "json_schema":
+		return format
+	case "json_object":
+		return &chat.ResponseFormat{Type: chat.FormatJSONObject}
+	}
+	return nil
+}
+
+func (c *Client) startStream(ctx context.Context, cfg *config.Config, messages []chat.Message, tools []chat.ToolDefinition, format *chat.ResponseFormat) (io.ReadCloser, error) {
+	// Only role, content and tool calls go upstream; the rest of
+	// chat.Message is ours.
+	wire := make([]groqMessage, len(messages))
+	for i, m := range messages {
+		wire[i] = groqMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			wire[i].ToolCalls = append(wire[i].ToolCalls, groqToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: groqFunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+	}
+
 	reqBody := groqRequest{
-		Model:     c.model,
-		Messages:  messages,
-		MaxTokens: c.maxTokens,
-		Stream:    true,
+		Model:          cfg.AppModel,
+		Messages:       wire,
+		ResponseFormat: format,
+		MaxTokens:      cfg.MaxTokens,
+		Stream:         true,
+	}
+	for _, t := range tools {
+		reqBody.Tools = append(reqBody.Tools, groqTool{
+			Type:     "function",
+			Function: groqFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -60,13 +207,17 @@ func (c *Client) StreamChat(messages []chat.Message) (<-chan string, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", groqAPIURL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL(cfg)+"/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Authorization", "Bearer "+cfg.GroqAPIKey)
 	req.Header.Set("Content-Type", "application/json")
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -75,16 +226,30 @@ func (c *Client) StreamChat(messages []chat.Message) (<-chan string, error) {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		slog.WarnContext(ctx, "Groq request rejected", "status", resp.StatusCode)
 		return nil, fmt.Errorf("groq api error (status %d): %s", resp.StatusCode, string(body))
 	}
 
-	streamChan := make(chan string)
+	return resp.Body, nil
+}
+
+// relay parses the SSE body into content chunks and ends span once the
+// upstream stream is exhausted, recording time to first token (measured from
+// start) and usage. Tool call fragments are collected for the final chunk.
+func (c *Client) relay(span trace.Span, start time.Time, body io.ReadCloser) <-chan chat.Chunk {
+	streamChan := make(chan chat.Chunk)
 
 	go func() {
-		defer resp.Body.Close()
+		defer span.End()
+		defer body.Close()
 		defer close(streamChan)
 
-		scanner := bufio.NewScanner(resp.Body)
+		var (
+			chunks int
+			final  chat.Chunk
+		)
+
+		scanner := bufio.NewScanner(body)
 		for scanner.Scan() {
 			line := scanner.Text()
 
@@ -94,7 +259,7 @@ func (c *Client) StreamChat(messages []chat.Message) (<-chan string, error) {
 
 			data := strings.TrimPrefix(line, "data: ")
 			if strings.TrimSpace(data) == "[DONE]" {
-				return
+				break
 			}
 
 			var streamResp groqStreamResponse
@@ -102,14 +267,71 @@ func (c *Client) StreamChat(messages []chat.Message) (<-chan string, error) {
 				continue
 			}
 
+			if streamResp.Model != "" {
+				final.Model = streamResp.Model
+			}
+			if streamResp.XGroq != nil && streamResp.XGroq.Usage != nil {
+				final.Usage = streamResp.XGroq.Usage
+			} else if streamResp.Usage != nil {
+				final.Usage = streamResp.Usage
+			}
+
 			if len(streamResp.Choices) > 0 {
-				content := streamResp.Choices[0].Delta.Content
-				if content != "" {
-					streamChan <- content
+				choice := streamResp.Choices[0]
+				if choice.FinishReason != nil {
+					final.FinishReason = *choice.FinishReason
+				}
+				for _, tc := range choice.Delta.ToolCalls {
+					if tc.Index < 0 || tc.Index >= maxToolCalls {
+						continue
+					}
+					for len(final.ToolCalls) <= tc.Index {
+						final.ToolCalls = append(final.ToolCalls, chat.ToolCall{})
+					}
+					call := &final.ToolCalls[tc.Index]
+					if tc.ID != "" {
+						call.ID = tc.ID
+					}
+					if tc.Function.Name != "" {
+						call.Name = tc.Function.Name
+					}
+					call.Arguments += tc.Function.Arguments
+				}
+				if choice.Delta.Content != "" {
+					if chunks == 0 {
+						span.SetAttributes(attribute.Float64("llm.ttft_ms", float64(time.Since(start).Microseconds())/1000))
+					}
+					chunks++
+					streamChan <- chat.Chunk{Content: choice.Delta.Content}
 				}
 			}
 		}
+
+		span.SetAttributes(
+			attribute.Int("llm.response.chunks", chunks),
+			attribute.Int("llm.response.tool_calls", len(final.ToolCalls)),
+		)
+		if final.Model != "" {
+			span.SetAttributes(semconv.GenAIResponseModel(final.Model))
+		}
+		if final.FinishReason != "" {
+			span.SetAttributes(semconv.GenAIResponseFinishReasons(final.FinishReason))
+		}
+		if final.Usage != nil {
+			span.SetAttributes(
+				semconv.GenAIUsageInputTokens(final.Usage.PromptTokens),
+				semconv.GenAIUsageOutputTokens(final.Usage.CompletionTokens),
+			)
+		}
+		if err := scanner.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		if final.Model != "" || final.FinishReason != "" || final.Usage != nil || len(final.ToolCalls) > 0 {
+			streamChan <- final
+		}
 	}()
 
-	return streamChan, nil
+	return streamChan
 }
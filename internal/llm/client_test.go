@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/config"
+	"chat-service/internal/requestid"
+)
+
+func TestStreamChat(t *testing.T) {
+	var gotRequestID string
+	var gotBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestID = r.Header.Get(requestid.Header)
+		json.NewDecoder(r.Body).Decode(&gotBody)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"model":"m-1","choices":[{"delta":{"content":"Hel"}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"model":"m-1","choices":[{"delta":{"content":"lo"}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"model":"m-1","choices":[{"delta":{},"finish_reason":"stop"}],"x_groq":{"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	c := NewClient(&config.Config{GroqAPIKey: "k", GroqBaseURL: server.URL, AppModel: "m-1", MaxTokens: 10})
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	stream, err := c.StreamChat(ctx, []chat.Message{{ID: "internal", Role: chat.RoleUser, Content: "Hi", Metadata: map[string]any{"x": 1}}})
+	if err != nil {
+		t.Fatalf("StreamChat failed: %v", err)
+	}
+
+	var content string
+	var final chat.Chunk
+	for chunk := range stream {
+		content += chunk.Content
+		if chunk.Usage != nil {
+			final = chunk
+		}
+	}
+
+	if content != "Hello" {
+		t.Errorf("Expected 'Hello', got %q", content)
+	}
+	if final.Model != "m-1" || final.FinishReason != "stop" || final.Usage.TotalTokens != 7 {
+		t.Errorf("Expected final chunk with model, finish reason and usage, got %+v", final)
+	}
+	if gotRequestID != "req-1" {
+		t.Errorf("Expected request id to be forwarded, got %q", gotRequestID)
+	}
+	msg := gotBody["messages"].([]any)[0].(map[string]any)
+	if len(msg) != 2 {
+		t.Errorf("Expected only role and content upstream, got %v", msg)
+	}
+}
+
+func TestStreamChatWithTools(t *testing.T) {
+	var gotBody groqRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotBody)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"clock","arguments":""}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"zone\":"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"UTC\"}"}},{"index":1,"id":"call_2","function":{"name":"calculator","arguments":"{}"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	c := NewClient(&config.Config{GroqAPIKey: "k", GroqBaseURL: server.URL, AppModel: "m-1"})
+	tools := []chat.ToolDefinition{{Name: "clock", Description: "Current time", Parameters: json.RawMessage(`{"type":"object"}`)}}
+	messages := []chat.Message{
+		{Role: chat.RoleUser, Content: "time?"},
+		{Role: chat.RoleAssistant, ToolCalls: []chat.ToolCall{{ID: "call_0", Name: "clock", Arguments: "{}"}}},
+		{Role: chat.RoleTool, Content: "12:00", ToolCallID: "call_0"},
+	}
+	stream, err := c.StreamChatWithTools(context.Background(), messages, tools)
+	if err != nil {
+		t.Fatalf("StreamChatWithTools failed: %v", err)
+	}
+	var final chat.Chunk
+	for chunk := range stream {
+		final = chunk
+	}
+
+	want := []chat.ToolCall{{ID: "call_1", Name: "clock", Arguments: `{"zone":"UTC"}`}, {ID: "call_2", Name: "calculator", Arguments: "{}"}}
+	if final.FinishReason != "tool_calls" || len(final.ToolCalls) != 2 || final.ToolCalls[0] != want[0] || final.ToolCalls[1] != want[1] {
+		t.Errorf("Expected assembled tool calls %+v, got %+v", want, final)
+	}
+	if len(gotBody.Tools) != 1 || gotBody.Tools[0].Type != "function" || gotBody.Tools[0].Function.Name != "clock" {
+		t.Errorf("Expected the tool definition upstream, got %+v", gotBody.Tools)
+	}
+	if call := gotBody.Messages[1].ToolCalls; len(call) != 1 || call[0].ID != "call_0" || gotBody.Messages[2].ToolCallID != "call_0" {
+		t.Errorf("Expected tool calls and results to be replayed upstream, got %+v", gotBody.Messages)
+	}
+}
+
+func TestStreamChatWithFormat(t *testing.T) {
+	var gotBody groqRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody = groqRequest{}
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"content":"{}"},"finish_reason":"stop"}]}`+"\n\n")
+	}))
+	defer server.Close()
+
+	format := &chat.ResponseFormat{Type: chat.FormatJSONSchema, JSONSchema: &chat.JSONSchemaFormat{Name: "answer", Schema: json.RawMessage(`{"type":"object"}`)}}
+	tools := []chat.ToolDefinition{{Name: "clock", Parameters: json.RawMessage(`{"type":"object"}`)}}
+	for _, tt := range []struct {
+		mode  string
+		tools []chat.ToolDefinition
+		want  string
+	}{
+		{"json_schema", nil, chat.FormatJSONSchema},
+		{"json_object", nil, chat.FormatJSONObject},
+		{"prompt", nil, ""},
+		{"json_schema", tools, ""},
+	} {
+		c := NewClient(&config.Config{GroqBaseURL: server.URL, AppModel: "m-1", StructuredOutputMode: tt.mode})
+		stream, err := c.StreamChatWithFormat(context.Background(), []chat.Message{{Role: chat.RoleUser, Content: "hi"}}, tt.tools, format)
+		if err != nil {
+			t.Fatalf("StreamChatWithFormat failed: %v", err)
+		}
+		for range stream {
+		}
+
+		var got string
+		if gotBody.ResponseFormat != nil {
+			got = gotBody.ResponseFormat.Type
+		}
+		if got != tt.want {
+			t.Errorf("Expected response_format %q in %s mode with %d tools, got %q", tt.want, tt.mode, len(tt.tools), got)
+		}
+		if got == chat.FormatJSONSchema && gotBody.ResponseFormat.JSONSchema.Name != "answer" {
+			t.Errorf("Expected the schema passed through, got %+v", gotBody.ResponseFormat.JSONSchema)
+		}
+	}
+}
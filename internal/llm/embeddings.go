@@ -0,0 +1,297 @@
+package llm
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/config"
+	"chat-service/internal/requestid"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for the embedding providers.
+const (
+	defaultOpenAIBaseURL  = "https://api.openai.com/v1"
+	defaultOpenAIModel    = "text-embedding-3-small"
+	defaultOllamaBaseURL  = "http://localhost:11434"
+	defaultOllamaModel    = "nomic-embed-text"
+	defaultHashDimensions = 256
+
+	// embedTimeout bounds one embedding request, which unlike a chat
+	// stream has no reason to run long.
+	embedTimeout = 60 * time.Second
+)
+
+// NewEmbedder returns the embedder selected by EmbeddingProvider, or nil
+// when none is configured.
+func NewEmbedder(cfg *config.Config) (chat.Embedder, error) {
+	switch cfg.EmbeddingProvider {
+	case "":
+		return nil, nil
+	case "openai":
+		return NewOpenAIEmbedder(
+			cmp.Or(cfg.EmbeddingBaseURL, defaultOpenAIBaseURL),
+			cfg.EmbeddingAPIKey,
+			cmp.Or(cfg.EmbeddingModel, defaultOpenAIModel),
+			cfg.EmbeddingDimensions,
+		), nil
+	case "ollama":
+		return NewOllamaEmbedder(
+			cmp.Or(cfg.EmbeddingBaseURL, defaultOllamaBaseURL),
+			cmp.Or(cfg.EmbeddingModel, defaultOllamaModel),
+		), nil
+	case "hash":
+		return NewHashEmbedder(cfg.EmbeddingDimensions), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.EmbeddingProvider)
+	}
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint.
+type OpenAIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimensions int
+	client     *http.Client
+}
+
+// NewOpenAIEmbedder returns an embedder for the API at baseURL, such as
+// https://api.openai.com/v1. An empty apiKey sends no Authorization header,
+// for local servers; dimensions of 0 keep the model's own size.
+func NewOpenAIEmbedder(baseURL, apiKey, model string, dimensions int) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		dimensions: dimensions,
+		client:     &http.Client{Timeout: embedTimeout},
+	}
+}
+
+func (e *OpenAIEmbedder) Model() string { return e.model }
+
+type openAIEmbeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, span := startEmbedSpan(ctx, semconv.GenAIProviderNameOpenAI, e.model, len(texts))
+	defer span.End()
+
+	headers := map[string]string{}
+	if e.apiKey != "" {
+		headers["Authorization"] = "Bearer " + e.apiKey
+	}
+	var resp openAIEmbeddingResponse
+	err := postJSON(ctx, e.client, e.baseURL+"/embeddings", headers,
+		openAIEmbeddingRequest{Model: e.model, Input: texts, Dimensions: e.dimensions}, &resp)
+	if err != nil {
+		return nil, endEmbedSpan(span, err)
+	}
+
+	// Entries carry their input's index and need not arrive in order.
+	vecs := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vecs) {
+			return nil, endEmbedSpan(span, fmt.Errorf("embedding api returned index %d for %d inputs", d.Index, len(texts)))
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	for i, v := range vecs {
+		if v == nil {
+			return nil, endEmbedSpan(span, fmt.Errorf("embedding api returned no vector for input %d", i))
+		}
+	}
+	span.SetAttributes(semconv.GenAIUsageInputTokens(resp.Usage.PromptTokens))
+	return vecs, nil
+}
+
+// OllamaEmbedder calls Ollama's /api/embed endpoint.
+type OllamaEmbedder struct {
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+// NewOllamaEmbedder returns an embedder for the Ollama server at baseURL,
+// such as http://localhost:11434.
+func NewOllamaEmbedder(baseURL, model string) *OllamaEmbedder {
+	return &OllamaEmbedder{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: embedTimeout},
+	}
+}
+
+func (e *OllamaEmbedder) Model() string { return e.model }
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, span := startEmbedSpan(ctx, semconv.GenAIProviderNameKey.String("ollama"), e.model, len(texts))
+	defer span.End()
+
+	var resp ollamaEmbedResponse
+	if err := postJSON(ctx, e.client, e.baseURL+"/api/embed", nil, ollamaEmbedRequest{Model: e.model, Input: texts}, &resp); err != nil {
+		return nil, endEmbedSpan(span, err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, endEmbedSpan(span, fmt.Errorf("ollama returned %d embeddings for %d inputs", len(resp.Embeddings), len(texts)))
+	}
+	span.SetAttributes(semconv.GenAIUsageInputTokens(resp.PromptEvalCount))
+	return resp.Embeddings, nil
+}
+
+func startEmbedSpan(ctx context.Context, provider attribute.KeyValue, model string, inputs int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "embeddings "+model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			provider,
+			semconv.GenAIOperationNameEmbeddings,
+			semconv.GenAIRequestModel(model),
+			attribute.Int("llm.request.inputs", inputs),
+		),
+	)
+}
+
+func endEmbedSpan(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// postJSON sends body to url and decodes a 200 response into out. Like
+// chat requests, it carries the request ID and trace context from ctx.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body, out any) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("embedding api error (status %d): %s", resp.StatusCode, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// HashEmbedder embeds texts locally by feature hashing: each word, and
+// each character trigram at half weight, adds ±1 to a dimension picked by
+// its hash. It needs no model and always gives the same vector for the
+// same text, which suits tests and offline use; texts are only similar
+// when they share words or word fragments.
+type HashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder returns a hashing embedder with the given number of
+// dimensions; 0 uses 256.
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = defaultHashDimensions
+	}
+	return &HashEmbedder{dimensions: dimensions}
+}
+
+func (e *HashEmbedder) Model() string { return fmt.Sprintf("hash-%d", e.dimensions) }
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i, text := range texts {
+		vecs[i] = e.embed(text)
+	}
+	return vecs, nil
+}
+
+func (e *HashEmbedder) embed(text string) []float32 {
+	vec := make([]float32, e.dimensions)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%uint64(e.dimensions)] += weight
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		add(w, 1)
+		padded := []rune("#" + w + "#")
+		for j := 0; j+3 <= len(padded); j++ {
+			add("\x00"+string(padded[j:j+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for j := range vec {
+			vec[j] *= scale
+		}
+	}
+	return vec
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chat-service/internal/config"
+)
+
+func TestOpenAIEmbedder(t *testing.T) {
+	var gotAuth, gotPath string
+	var gotBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotPath = r.Header.Get("Authorization"), r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		// Out of order, as the API allows.
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}],"usage":{"prompt_tokens":4}}`)
+	}))
+	defer server.Close()
+
+	e, _ := NewEmbedder(&config.Config{EmbeddingProvider: "openai", EmbeddingBaseURL: server.URL + "/v1/", EmbeddingAPIKey: "k", EmbeddingDimensions: 2})
+	vecs, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(vecs) != 2 || vecs[0][0] != 1 || vecs[1][1] != 1 {
+		t.Errorf("Expected vectors in input order, got %v", vecs)
+	}
+	if gotAuth != "Bearer k" || gotPath != "/v1/embeddings" || gotBody["model"] != defaultOpenAIModel || gotBody["dimensions"] != 2.0 {
+		t.Errorf("Unexpected request %q %q %v", gotAuth, gotPath, gotBody)
+	}
+
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[{"index":0,"embedding":[1]}]}`)
+	}))
+	defer missing.Close()
+	if _, err := NewOpenAIEmbedder(missing.URL, "", "m", 0).Embed(context.Background(), []string{"a", "b"}); err == nil {
+		t.Error("Expected an error when a vector is missing")
+	}
+}
+
+func TestOllamaEmbedder(t *testing.T) {
+	var gotPath string
+	var gotBody ollamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		if gotBody.Model == "missing" {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"model":"nomic-embed-text","embeddings":[[0.5,0.5]]}`)
+	}))
+	defer server.Close()
+
+	e, _ := NewEmbedder(&config.Config{EmbeddingProvider: "ollama", EmbeddingBaseURL: server.URL})
+	vecs, err := e.Embed(context.Background(), []string{"a"})
+	if err != nil || len(vecs) != 1 || vecs[0][0] != 0.5 {
+		t.Fatalf("Expected one vector, got %v, %v", vecs, err)
+	}
+	if gotPath != "/api/embed" || gotBody.Model != defaultOllamaModel || e.Model() != defaultOllamaModel {
+		t.Errorf("Unexpected request %q %+v", gotPath, gotBody)
+	}
+	if _, err := NewOllamaEmbedder(server.URL, "missing").Embed(context.Background(), []string{"a"}); err == nil {
+		t.Error("Expected an error for a missing model")
+	}
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(0)
+	vecs, _ := e.Embed(context.Background(), []string{
+		"Restart the primary database",
+		"restarting the database!",
+		"Lunch is at noon",
+		"",
+	})
+	again, _ := e.Embed(context.Background(), []string{"Restart the primary database"})
+
+	if len(vecs[0]) != defaultHashDimensions || e.Model() != "hash-256" {
+		t.Fatalf("Expected %d dimensions, got %d (%s)", defaultHashDimensions, len(vecs[0]), e.Model())
+	}
+	if cosine(vecs[0], again[0]) < 0.9999 {
+		t.Error("Expected the same vector for the same text")
+	}
+	if related, unrelated := cosine(vecs[0], vecs[1]), cosine(vecs[0], vecs[2]); related <= unrelated || related < 0.4 {
+		t.Errorf("Expected related texts closer, got %.2f vs %.2f", related, unrelated)
+	}
+	for _, v := range vecs[3] {
+		if v != 0 {
+			t.Fatalf("Expected a zero vector for empty text, got %v", vecs[3])
+		}
+	}
+}
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot / math.Max(norm(a)*norm(b), 1e-12)
+}
+
+func norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
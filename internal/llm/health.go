@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"chat-service/internal/config"
+)
+
+// healthTTL is how long an upstream probe result is reused, so frequent
+// readiness checks do not turn into a steady stream of calls to Groq.
+const healthTTL = 30 * time.Second
+
+type healthCache struct {
+	mu        sync.Mutex
+	target    string // endpoint and key the cached result belongs to
+	checkedAt time.Time
+	err       error
+}
+
+// CheckHealth verifies that Groq is reachable and accepts the configured key
+// by listing models. Results, including failures, are cached for healthTTL
+// unless a reload changes the endpoint or key.
+func (c *Client) CheckHealth(ctx context.Context) error {
+	cfg := c.cfg.Current()
+	target := baseURL(cfg) + "\x00" + cfg.GroqAPIKey
+
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+
+	if c.health.target == target && time.Since(c.health.checkedAt) < healthTTL {
+		return c.health.err
+	}
+
+	c.health.err = c.probeModels(ctx, cfg)
+	c.health.target = target
+	c.health.checkedAt = time.Now()
+	return c.health.err
+}
+
+func (c *Client) probeModels(ctx context.Context, cfg *config.Config) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL(cfg)+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.GroqAPIKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("groq unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("groq model list returned status %d", resp.StatusCode)
+	}
+	return nil
+}
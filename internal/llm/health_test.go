@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"chat-service/internal/config"
+)
+
+func TestCheckHealth(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/models" {
+			t.Errorf("Expected probe of /models, got %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+
+	t.Run("Healthy And Cached", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		c := NewClient(&config.Config{GroqAPIKey: "good", GroqBaseURL: server.URL})
+
+		for i := 0; i < 3; i++ {
+			if err := c.CheckHealth(context.Background()); err != nil {
+				t.Fatalf("Expected healthy upstream, got %v", err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("Expected 1 upstream probe, got %d", n)
+		}
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		c := NewClient(&config.Config{GroqAPIKey: "bad", GroqBaseURL: server.URL})
+		if err := c.CheckHealth(context.Background()); err == nil {
+			t.Error("Expected error for rejected key")
+		}
+	})
+}
@@ -0,0 +1,279 @@
+// Package mcp is a Model Context Protocol client. It connects to MCP
+// servers over stdio or streamable HTTP, discovers their tools and prompts,
+// and registers them as chat tools.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// protocolVersion is the MCP revision this client speaks.
+const protocolVersion = "2025-06-18"
+
+// Server says how to reach an MCP server: Command starts it as a
+// subprocess speaking stdio, URL reaches it over streamable HTTP.
+type Server struct {
+	Command string
+	Args    []string
+	Env     map[string]string
+	URL     string
+	Headers map[string]string
+}
+
+// Client is a connection to one MCP server. It is safe for concurrent use.
+type Client struct {
+	name   string
+	t      transport
+	nextID atomic.Int64
+
+	info         serverInfo
+	capabilities struct {
+		Tools   *struct{} `json:"tools"`
+		Prompts *struct{} `json:"prompts"`
+	}
+}
+
+type serverInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Connect starts or reaches the server and completes the MCP handshake.
+func Connect(ctx context.Context, name string, s Server) (*Client, error) {
+	var (
+		t   transport
+		err error
+	)
+	switch {
+	case s.URL != "":
+		t = newHTTPTransport(s.URL, s.Headers, nil)
+	case s.Command != "":
+		if t, err = startStdio(s.Command, s.Args, s.Env); err != nil {
+			return nil, fmt.Errorf("mcp server %q: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("mcp server %q needs a command or a URL", name)
+	}
+
+	c := &Client{name: name, t: t}
+	if err := c.initialize(ctx); err != nil {
+		t.close()
+		return nil, fmt.Errorf("mcp server %q: %w", name, err)
+	}
+	return c, nil
+}
+
+// Name is the name the server was configured under.
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var res struct {
+		ProtocolVersion string     `json:"protocolVersion"`
+		ServerInfo      serverInfo `json:"serverInfo"`
+		Capabilities    json.RawMessage
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "chat-service", "version": "1.0"},
+	}, &res)
+	if err != nil {
+		return fmt.Errorf("initialize failed: %w", err)
+	}
+	if len(res.Capabilities) > 0 {
+		json.Unmarshal(res.Capabilities, &c.capabilities)
+	}
+	c.info = res.ServerInfo
+	if ht, ok := c.t.(*httpTransport); ok {
+		ht.mu.Lock()
+		ht.version = res.ProtocolVersion
+		ht.mu.Unlock()
+	}
+	return c.notify(ctx, "notifications/initialized")
+}
+
+// call sends a request and decodes its result into out.
+func (c *Client) call(ctx context.Context, method string, params, out any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	resp, err := c.t.send(ctx, &message{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("malformed %s result: %w", method, err)
+	}
+	return nil
+}
+
+func (c *Client) notify(ctx context.Context, method string) error {
+	_, err := c.t.send(ctx, &message{JSONRPC: "2.0", Method: method})
+	return err
+}
+
+// Close ends the connection, stopping a stdio server.
+func (c *Client) Close() error {
+	return c.t.close()
+}
+
+// Tool is a tool offered by a server.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// Tools lists the server's tools.
+func (c *Client) Tools(ctx context.Context) ([]Tool, error) {
+	if c.capabilities.Tools == nil {
+		return nil, nil
+	}
+	var tools []Tool
+	err := c.list(ctx, "tools/list", func(raw json.RawMessage) error {
+		var page struct {
+			Tools []Tool `json:"tools"`
+		}
+		err := json.Unmarshal(raw, &page)
+		tools = append(tools, page.Tools...)
+		return err
+	})
+	return tools, err
+}
+
+// maxPages bounds paginated listings, in case a server keeps returning
+// cursors.
+const maxPages = 100
+
+// list walks a paginated listing, passing each page's result to add.
+func (c *Client) list(ctx context.Context, method string, add func(json.RawMessage) error) error {
+	cursor := ""
+	for range maxPages {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page json.RawMessage
+		if err := c.call(ctx, method, params, &page); err != nil {
+			return err
+		}
+		if err := add(page); err != nil {
+			return fmt.Errorf("malformed %s result: %w", method, err)
+		}
+		var next struct {
+			NextCursor string `json:"nextCursor"`
+		}
+		json.Unmarshal(page, &next)
+		if cursor = next.NextCursor; cursor == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s returned more than %d pages", method, maxPages)
+}
+
+// content is one item of a tool result or prompt message.
+type content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"resource"`
+}
+
+func (ct content) String() string {
+	switch {
+	case ct.Type == "text":
+		return ct.Text
+	case ct.Resource != nil && ct.Resource.Text != "":
+		return ct.Resource.Text
+	case ct.Resource != nil:
+		return "[resource " + ct.Resource.URI + "]"
+	default:
+		return "[" + ct.Type + "]"
+	}
+}
+
+// CallTool runs a tool and returns its result as text. A result the
+// server marks as an error is returned as an error.
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (string, error) {
+	var res struct {
+		Content           []content       `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	}
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &res); err != nil {
+		return "", err
+	}
+	parts := make([]string, len(res.Content))
+	for i, ct := range res.Content {
+		parts[i] = ct.String()
+	}
+	text := strings.Join(parts, "\n")
+	if text == "" && len(res.StructuredContent) > 0 {
+		text = string(res.StructuredContent)
+	}
+	if res.IsError {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+// Prompt is a prompt template offered by a server.
+type Prompt struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Arguments   []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Required    bool   `json:"required"`
+	} `json:"arguments"`
+}
+
+// Prompts lists the server's prompts.
+func (c *Client) Prompts(ctx context.Context) ([]Prompt, error) {
+	if c.capabilities.Prompts == nil {
+		return nil, nil
+	}
+	var prompts []Prompt
+	err := c.list(ctx, "prompts/list", func(raw json.RawMessage) error {
+		var page struct {
+			Prompts []Prompt `json:"prompts"`
+		}
+		err := json.Unmarshal(raw, &page)
+		prompts = append(prompts, page.Prompts...)
+		return err
+	})
+	return prompts, err
+}
+
+// GetPrompt renders a prompt with args, one "role: text" paragraph per
+// message.
+func (c *Client) GetPrompt(ctx context.Context, name string, args map[string]string) (string, error) {
+	var res struct {
+		Messages []struct {
+			Role    string  `json:"role"`
+			Content content `json:"content"`
+		} `json:"messages"`
+	}
+	if err := c.call(ctx, "prompts/get", map[string]any{"name": name, "arguments": args}, &res); err != nil {
+		return "", err
+	}
+	parts := make([]string, len(res.Messages))
+	for i, m := range res.Messages {
+		parts[i] = m.Role + ": " + m.Content.String()
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,212 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+// serve answers one request like a small MCP server with an echo tool, a
+// failing tool and a greeting prompt. Tools are listed over two pages.
+func serve(m message) *message {
+	reply := func(result any) *message {
+		data, _ := json.Marshal(result)
+		return &message{JSONRPC: "2.0", ID: m.ID, Result: data}
+	}
+	var params struct {
+		Name      string          `json:"name"`
+		Cursor    string          `json:"cursor"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if raw, ok := m.Params.(map[string]any); ok {
+		data, _ := json.Marshal(raw)
+		json.Unmarshal(data, &params)
+	}
+
+	switch m.Method {
+	case "initialize":
+		return reply(map[string]any{
+			"protocolVersion": protocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}, "prompts": map[string]any{}},
+			"serverInfo":      map[string]string{"name": "test", "version": "0.1"},
+		})
+	case "tools/list":
+		if params.Cursor == "" {
+			return reply(map[string]any{"nextCursor": "2", "tools": []map[string]any{
+				{"name": "echo", "description": "Repeats text", "inputSchema": map[string]any{
+					"type": "object", "properties": map[string]any{"text": map[string]string{"type": "string"}}, "required": []string{"text"},
+				}},
+			}})
+		}
+		return reply(map[string]any{"tools": []map[string]any{
+			{"name": "broken", "inputSchema": map[string]any{"type": "object"}},
+			{"name": "bad name", "inputSchema": map[string]any{"type": "object"}},
+		}})
+	case "tools/call":
+		var args struct{ Text string }
+		json.Unmarshal(params.Arguments, &args)
+		if params.Name == "broken" {
+			return reply(map[string]any{"isError": true, "content": []map[string]string{{"type": "text", "text": "it broke"}}})
+		}
+		return reply(map[string]any{"content": []map[string]any{
+			{"type": "text", "text": args.Text},
+			{"type": "image", "data": "..."},
+		}})
+	case "prompts/list":
+		return reply(map[string]any{"prompts": []map[string]any{
+			{"name": "greet", "description": "Greets someone", "arguments": []map[string]any{{"name": "who", "required": true}}},
+		}})
+	case "prompts/get":
+		var args struct{ Who string }
+		json.Unmarshal(params.Arguments, &args)
+		return reply(map[string]any{"messages": []map[string]any{
+			{"role": "user", "content": map[string]string{"type": "text", "text": "Say hello to " + args.Who}},
+		}})
+	}
+	return &message{JSONRPC: "2.0", ID: m.ID, Error: &RPCError{Code: -32601, Message: "method not found"}}
+}
+
+// TestMain lets the test binary act as a stdio MCP server for
+// TestStdioServer.
+func TestMain(m *testing.M) {
+	if os.Getenv("MCP_TEST_SERVER") == "1" {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			var msg message
+			if json.Unmarshal(sc.Bytes(), &msg) != nil || len(msg.ID) == 0 {
+				continue
+			}
+			data, _ := json.Marshal(serve(msg))
+			fmt.Printf("%s\n", data)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// checkServer registers the server's tools and prompts and exercises them
+// through the registry.
+func checkServer(t *testing.T, c *Client) {
+	t.Helper()
+	ctx := context.Background()
+	registry := chat.NewToolRegistry()
+	if err := Register(ctx, registry, c); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	var names []string
+	for _, d := range registry.Available([]string{"test"}) {
+		names = append(names, d.Name)
+	}
+	if got := strings.Join(names, " "); got != "test__echo test__broken test__prompt_greet" {
+		t.Errorf("Unexpected tools %q", got)
+	}
+	if defs := registry.Available(nil); len(defs) != 0 {
+		t.Errorf("Expected server tools to need their source, got %+v", defs)
+	}
+
+	tests := []struct {
+		call    chat.ToolCall
+		want    string
+		wantErr string
+	}{
+		{chat.ToolCall{Name: "test__echo", Arguments: `{"text":"hi"}`}, "hi\n[image]", ""},
+		{chat.ToolCall{Name: "test__broken"}, "", "it broke"},
+		{chat.ToolCall{Name: "test__prompt_greet", Arguments: `{"who":"Ada"}`}, "user: Say hello to Ada", ""},
+		{chat.ToolCall{Name: "test__prompt_greet", Arguments: `{}`}, "", `missing required argument "who"`},
+	}
+	for _, tt := range tests {
+		got, err := registry.Call(ctx, tt.call)
+		if got != tt.want || (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Call(%+v) = %q, %v; want %q, %q", tt.call, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var deleted bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var m message
+				if r.Method == http.MethodDelete {
+					deleted = r.Header.Get("Mcp-Session-Id") == "s1"
+					return
+				}
+				json.NewDecoder(r.Body).Decode(&m)
+				if m.Method == "initialize" {
+					w.Header().Set("Mcp-Session-Id", "s1")
+				} else if r.Header.Get("Mcp-Session-Id") != "s1" || r.Header.Get("MCP-Protocol-Version") != protocolVersion {
+					http.Error(w, "missing session", http.StatusBadRequest)
+					return
+				}
+				if len(m.ID) == 0 {
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+				data, _ := json.Marshal(serve(m))
+				if !stream {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write(data)
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			}))
+			defer srv.Close()
+
+			c, err := Connect(context.Background(), "test", Server{URL: srv.URL})
+			if err != nil {
+				t.Fatalf("Connect failed: %v", err)
+			}
+			checkServer(t, c)
+			c.Close()
+			if !deleted {
+				t.Error("Expected the session to be ended on close")
+			}
+		})
+	}
+}
+
+func TestStdioServer(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Connect(context.Background(), "test", Server{
+		Command: exe,
+		Args:    []string{"-test.run=^$"},
+		Env:     map[string]string{"MCP_TEST_SERVER": "1"},
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	checkServer(t, c)
+}
+
+func TestConnectFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	for _, s := range []Server{
+		{URL: srv.URL},
+		{Command: "/nonexistent/mcp-server"},
+		{},
+	} {
+		if _, err := Connect(context.Background(), "bad", s); err == nil {
+			t.Errorf("Expected Connect(%+v) to fail", s)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"chat-service/internal/chat"
+)
+
+// Register adds the server's tools and prompts to registry, with the
+// server's name as their source. Tools are named "<server>__<tool>" and
+// prompts "<server>__prompt_<prompt>"; calling a prompt returns its
+// rendered messages. Entries whose names the registry rejects are logged
+// and skipped.
+func Register(ctx context.Context, registry *chat.ToolRegistry, c *Client) error {
+	tools, err := c.Tools(ctx)
+	if err != nil {
+		return err
+	}
+	prompts, err := c.Prompts(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tools {
+		name := t.Name
+		add(registry, c, chat.Tool{
+			ToolDefinition: chat.ToolDefinition{
+				Name:        c.name + "__" + name,
+				Description: t.Description,
+				Parameters:  t.InputSchema,
+			},
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				return c.CallTool(ctx, name, args)
+			},
+		})
+	}
+	for _, p := range prompts {
+		name := p.Name
+		add(registry, c, chat.Tool{
+			ToolDefinition: chat.ToolDefinition{
+				Name:        c.name + "__prompt_" + name,
+				Description: p.Description,
+				Parameters:  promptSchema(p),
+			},
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var values map[string]string
+				if err := json.Unmarshal(args, &values); err != nil {
+					return "", err
+				}
+				return c.GetPrompt(ctx, name, values)
+			},
+		})
+	}
+	slog.Info("Connected MCP server", "server", c.name, "name", c.info.Name,
+		"version", c.info.Version, "tools", len(tools), "prompts", len(prompts))
+	return nil
+}
+
+func add(registry *chat.ToolRegistry, c *Client, t chat.Tool) {
+	t.Source = c.name
+	if err := registry.Register(t); err != nil {
+		slog.Warn("Skipping MCP tool", "server", c.name, "error", err)
+	}
+}
+
+// promptSchema describes a prompt's arguments, which are all strings.
+func promptSchema(p Prompt) json.RawMessage {
+	props := map[string]any{}
+	required := []string{}
+	for _, a := range p.Arguments {
+		props[a.Name] = map[string]string{"type": "string", "description": a.Description}
+		if a.Required {
+			required = append(required, a.Name)
+		}
+	}
+	schema, _ := json.Marshal(map[string]any{"type": "object", "properties": props, "required": required})
+	return schema
+}
@@ -0,0 +1,336 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// message is a JSON-RPC 2.0 request, notification or response.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is an error returned by an MCP server.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// isResponse reports whether m answers a request, rather than being a
+// request or notification from the server.
+func (m *message) isResponse() bool {
+	return m.Method == "" && len(m.ID) > 0
+}
+
+// transport carries messages to a server. send returns the response to a
+// request, or nil for a notification.
+type transport interface {
+	send(ctx context.Context, m *message) (*message, error)
+	close() error
+}
+
+const (
+	// maxMessage bounds one message from a server.
+	maxMessage = 16 << 20
+	// stdioGrace is how long a stdio server may take to exit.
+	stdioGrace = 2 * time.Second
+)
+
+// stdioTransport talks to a server started as a subprocess, with one JSON
+// message per line on its stdin and stdout.
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *message
+	done    chan struct{}
+	err     error // why the server's stdout closed
+}
+
+func startStdio(command string, args []string, env map[string]string) (*stdioTransport, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", command, err)
+	}
+
+	t := &stdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[string]chan *message),
+		done:    make(chan struct{}),
+	}
+	go t.read(stdout)
+	return t, nil
+}
+
+// read delivers responses to waiting requests and answers pings until the
+// server's stdout closes.
+func (t *stdioTransport) read(stdout io.Reader) {
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64<<10), maxMessage)
+	for sc.Scan() {
+		var m message
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			continue
+		}
+		switch {
+		case m.isResponse():
+			t.mu.Lock()
+			ch := t.pending[string(m.ID)]
+			delete(t.pending, string(m.ID))
+			t.mu.Unlock()
+			if ch != nil {
+				ch <- &m
+			}
+		case m.Method != "" && len(m.ID) > 0:
+			reply := &message{JSONRPC: "2.0", ID: m.ID, Result: json.RawMessage("{}")}
+			if m.Method != "ping" {
+				reply = &message{JSONRPC: "2.0", ID: m.ID, Error: &RPCError{Code: -32601, Message: "method not found"}}
+			}
+			t.write(reply)
+		}
+	}
+
+	t.mu.Lock()
+	t.err = sc.Err()
+	if t.err == nil {
+		t.err = errors.New("server closed its output")
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *stdioTransport) write(m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdioTransport) send(ctx context.Context, m *message) (*message, error) {
+	if len(m.ID) == 0 {
+		return nil, t.write(m)
+	}
+	ch := make(chan *message, 1)
+	t.mu.Lock()
+	t.pending[string(m.ID)] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, string(m.ID))
+		t.mu.Unlock()
+	}()
+
+	if err := t.write(m); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// close ends the server by closing its stdin, as the protocol asks, and
+// kills it if it has not exited after stdioGrace.
+func (t *stdioTransport) close() error {
+	t.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- t.cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(stdioGrace):
+		t.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// httpTransport talks to a server over the streamable HTTP transport: each
+// message is POSTed, and the response comes back as JSON or as an SSE
+// stream.
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+	version   string
+}
+
+func newHTTPTransport(url string, headers map[string]string, client *http.Client) *httpTransport {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &httpTransport{url: url, headers: headers, client: client}
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.version != "" {
+		req.Header.Set("MCP-Protocol-Version", t.version)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *httpTransport) send(ctx context.Context, m *message) (*message, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode == http.StatusAccepted && len(m.ID) == 0 {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if len(m.ID) == 0 {
+		return nil, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		var out message
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxMessage)).Decode(&out); err != nil {
+			return nil, fmt.Errorf("malformed response: %w", err)
+		}
+		return &out, nil
+	}
+	return readEvents(resp.Body, m.ID)
+}
+
+// readEvents reads an SSE stream until the response to the request with
+// the given ID. Other messages on the stream, such as progress
+// notifications, are skipped.
+func readEvents(body io.Reader, id json.RawMessage) (*message, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64<<10), maxMessage)
+	var data strings.Builder
+	// dispatch handles the event collected so far.
+	dispatch := func() *message {
+		var m message
+		err := json.Unmarshal([]byte(data.String()), &m)
+		data.Reset()
+		if err == nil && m.isResponse() && bytes.Equal(m.ID, id) {
+			return &m
+		}
+		return nil
+	}
+	for sc.Scan() {
+		line := sc.Text()
+		if v, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(v, " "))
+			continue
+		}
+		if line == "" && data.Len() > 0 {
+			if m := dispatch(); m != nil {
+				return m, nil
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if data.Len() > 0 {
+		if m := dispatch(); m != nil {
+			return m, nil
+		}
+	}
+	return nil, errors.New("stream ended without a response")
+}
+
+// close ends the session, if the server started one.
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	session := t.sessionID
+	t.mu.Unlock()
+	if session == "" {
+		return nil
+	}
+	req, err := t.newRequest(context.Background(), http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to end MCP session", "url", t.url, "error", err)
+		return nil
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package moderation provides chat.Moderator implementations: a keyword
+// and regular expression policy engine, and an adapter that asks a model
+// to classify text.
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"chat-service/internal/chat"
+)
+
+// Rule flags text containing any of its keywords or matching any of its
+// patterns.
+type Rule struct {
+	Category string
+	// Keywords match whole words, ignoring case.
+	Keywords []string
+	Patterns []string
+	// Stages limits the rule to input or output; empty applies to both.
+	Stages []chat.ModerationStage
+}
+
+type compiledRule struct {
+	category string
+	re       *regexp.Regexp
+	stages   []chat.ModerationStage
+}
+
+// Policy is a keyword and regular expression policy engine.
+type Policy struct {
+	rules []compiledRule
+}
+
+// NewPolicy compiles rules into a Policy.
+func NewPolicy(rules []Rule) (*Policy, error) {
+	p := &Policy{}
+	for i, r := range rules {
+		if r.Category == "" {
+			return nil, fmt.Errorf("moderation rule %d needs a category", i+1)
+		}
+		for _, st := range r.Stages {
+			if st != chat.StageInput && st != chat.StageOutput {
+				return nil, fmt.Errorf("moderation rule %q: unknown stage %q", r.Category, st)
+			}
+		}
+		alternatives := make([]string, 0, len(r.Keywords)+len(r.Patterns))
+		for _, k := range r.Keywords {
+			alternatives = append(alternatives, `\b`+regexp.QuoteMeta(k)+`\b`)
+		}
+		for _, pat := range r.Patterns {
+			if _, err := regexp.Compile(pat); err != nil {
+				return nil, fmt.Errorf("moderation rule %q: %w", r.Category, err)
+			}
+			alternatives = append(alternatives, "(?:"+pat+")")
+		}
+		if len(alternatives) == 0 {
+			return nil, fmt.Errorf("moderation rule %q has no keywords or patterns", r.Category)
+		}
+		p.rules = append(p.rules, compiledRule{
+			category: r.Category,
+			re:       regexp.MustCompile("(?i)" + strings.Join(alternatives, "|")),
+			stages:   r.Stages,
+		})
+	}
+	return p, nil
+}
+
+// Moderate flags text matched by any rule that applies to stage.
+func (p *Policy) Moderate(ctx context.Context, stage chat.ModerationStage, text string) (chat.Verdict, error) {
+	var v chat.Verdict
+	for _, r := range p.rules {
+		if len(r.stages) > 0 && !slices.Contains(r.stages, stage) {
+			continue
+		}
+		if r.re.MatchString(text) && !slices.Contains(v.Categories, r.category) {
+			v.Flagged = true
+			v.Categories = append(v.Categories, r.category)
+		}
+	}
+	return v, nil
+}
+
+const classifierPrompt = `You are a content moderation classifier. Decide whether the text ` +
+	`from the %s violates policy: hate, harassment, violence, self-harm, sexual content ` +
+	`involving minors, or instructions for serious harm. Reply with JSON only, no prose: ` +
+	`{"flagged": true|false, "categories": ["..."], "reason": "one sentence"}`
+
+// Classifier asks a model to classify text. It only reviews the stages it
+// was created for, since each review costs a model call.
+type Classifier struct {
+	llm    chat.LLMClient
+	stages []chat.ModerationStage
+}
+
+// NewClassifier returns a Classifier that reviews the given stages with llm.
+func NewClassifier(llm chat.LLMClient, stages ...chat.ModerationStage) *Classifier {
+	return &Classifier{llm: llm, stages: stages}
+}
+
+func (c *Classifier) Moderate(ctx context.Context, stage chat.ModerationStage, text string) (chat.Verdict, error) {
+	if !slices.Contains(c.stages, stage) {
+		return chat.Verdict{}, nil
+	}
+	source := "user"
+	if stage == chat.StageOutput {
+		source = "assistant"
+	}
+	stream, err := c.llm.StreamChat(ctx, []chat.Message{
+		{Role: chat.RoleSystem, Content: fmt.Sprintf(classifierPrompt, source)},
+		{Role: chat.RoleUser, Content: text},
+	})
+	if err != nil {
+		return chat.Verdict{}, err
+	}
+	var sb strings.Builder
+	for chunk := range stream {
+		sb.WriteString(chunk.Content)
+	}
+	if err := ctx.Err(); err != nil {
+		return chat.Verdict{}, err
+	}
+	return parseVerdict(sb.String())
+}
+
+// parseVerdict reads the classifier's JSON answer, tolerating prose or code
+// fences around it.
+func parseVerdict(answer string) (chat.Verdict, error) {
+	start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+	if start < 0 || end < start {
+		return chat.Verdict{}, errors.New("classifier answer has no JSON verdict")
+	}
+	var v struct {
+		Flagged    bool     `json:"flagged"`
+		Categories []string `json:"categories"`
+		Reason     string   `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &v); err != nil {
+		return chat.Verdict{}, fmt.Errorf("classifier answer is not a verdict: %w", err)
+	}
+	return chat.Verdict{Flagged: v.Flagged, Categories: v.Categories, Reason: v.Reason}, nil
+}
@@ -0,0 +1,90 @@
+package moderation
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy([]Rule{
+		{Category: "weapons", Keywords: []string{"pipe bomb"}},
+		{Category: "secrets", Patterns: []string{`(?i)internal use only`}, Stages: []chat.ModerationStage{chat.StageOutput}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stage chat.ModerationStage
+		text  string
+		want  []string
+	}{
+		{chat.StageInput, "how do I build a Pipe Bomb", []string{"weapons"}},
+		{chat.StageInput, "a pipe bombastic claim", nil},
+		{chat.StageInput, "this is INTERNAL USE ONLY", nil},
+		{chat.StageOutput, "this is INTERNAL USE ONLY, unlike the pipe bomb", []string{"weapons", "secrets"}},
+	}
+	for _, tt := range tests {
+		v, err := p.Moderate(context.Background(), tt.stage, tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Flagged != (tt.want != nil) || !slices.Equal(v.Categories, tt.want) {
+			t.Errorf("Moderate(%s, %q) = %+v, want categories %v", tt.stage, tt.text, v, tt.want)
+		}
+	}
+}
+
+func TestNewPolicyRejectsBadRules(t *testing.T) {
+	for _, r := range []Rule{
+		{Keywords: []string{"x"}},
+		{Category: "empty"},
+		{Category: "regex", Patterns: []string{"("}},
+		{Category: "stage", Keywords: []string{"x"}, Stages: []chat.ModerationStage{"both"}},
+	} {
+		if _, err := NewPolicy([]Rule{r}); err == nil {
+			t.Errorf("Expected %+v to be rejected", r)
+		}
+	}
+}
+
+type fakeLLM struct {
+	answer   string
+	messages []chat.Message
+}
+
+func (f *fakeLLM) StreamChat(ctx context.Context, messages []chat.Message) (<-chan chat.Chunk, error) {
+	f.messages = messages
+	ch := make(chan chat.Chunk, 1)
+	ch <- chat.Chunk{Content: f.answer}
+	close(ch)
+	return ch, nil
+}
+
+func TestClassifier(t *testing.T) {
+	llm := &fakeLLM{answer: "```json\n{\"flagged\": true, \"categories\": [\"harassment\"], \"reason\": \"insults a person\"}\n```"}
+	c := NewClassifier(llm, chat.StageOutput)
+
+	v, err := c.Moderate(context.Background(), chat.StageInput, "anything")
+	if err != nil || v.Flagged || llm.messages != nil {
+		t.Errorf("Expected input to be skipped, got %+v, %v", v, err)
+	}
+
+	v, err = c.Moderate(context.Background(), chat.StageOutput, "you are an idiot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Flagged || v.Categories[0] != "harassment" || v.Reason != "insults a person" {
+		t.Errorf("Unexpected verdict %+v", v)
+	}
+	if llm.messages[1].Content != "you are an idiot" {
+		t.Errorf("Expected the text to be sent as the user message, got %+v", llm.messages)
+	}
+
+	llm.answer = "I cannot help with that."
+	if _, err := c.Moderate(context.Background(), chat.StageOutput, "x"); err == nil {
+		t.Error("Expected an error for an answer without a verdict")
+	}
+}
@@ -0,0 +1,75 @@
+package rag
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// split breaks text into chunks of at most size bytes. Chunks end at the
+// last paragraph break, line break, sentence end or space in their second
+// half, in that order of preference, and each chunk after the first starts
+// with up to overlap bytes of the one before, from a word boundary.
+func split(text string, size, overlap int) []string {
+	text = normalize(text)
+	var chunks []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			chunks = append(chunks, s)
+		}
+	}
+	for start := 0; start < len(text); {
+		end := start + size
+		if end >= len(text) {
+			add(text[start:])
+			break
+		}
+		end = cut(text, start, end)
+		add(text[start:end])
+
+		next := end
+		if overlap > 0 {
+			from := max(start+1, end-overlap)
+			if i := strings.IndexAny(text[from:end], " \n"); i >= 0 {
+				next = from + i + 1
+			}
+		}
+		start = next
+	}
+	return chunks
+}
+
+// cut returns where to end the chunk text[start:end].
+func cut(text string, start, end int) int {
+	mid := start + (end-start)/2
+	for _, sep := range []string{"\n\n", "\n", ". ", " "} {
+		if i := strings.LastIndex(text[mid:end], sep); i >= 0 {
+			return mid + i + len(sep)
+		}
+	}
+	for end > mid && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return end
+}
+
+// normalize trims trailing space from lines and collapses runs of blank
+// lines, so paragraph breaks are always "\n\n".
+func normalize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	out := lines[:0]
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r\f\v")
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
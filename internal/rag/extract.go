@@ -0,0 +1,63 @@
+package rag
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/htmltext"
+)
+
+// extract returns the title, if the document names one, and the text of
+// data read as contentType: plain text, Markdown, HTML or PDF.
+func extract(contentType string, data []byte) (title, text string, err error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", "", fmt.Errorf("%w %q", chat.ErrUnsupportedDocument, contentType)
+	}
+	switch mediaType {
+	case "text/plain":
+		text = string(data)
+	case "text/markdown", "text/x-markdown":
+		text = string(data)
+		title = markdownTitle(text)
+	case "text/html", "application/xhtml+xml":
+		title, text = htmltext.Extract(string(data))
+	case "application/pdf":
+		if text, err = pdfText(data); err != nil {
+			return "", "", fmt.Errorf("%w: %v", chat.ErrUnreadableDocument, err)
+		}
+	default:
+		return "", "", fmt.Errorf("%w %q: use text/plain, text/markdown, text/html or application/pdf", chat.ErrUnsupportedDocument, mediaType)
+	}
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "�")
+	}
+	return title, text, nil
+}
+
+// markdownTitle returns the first level-one heading of a Markdown document.
+func markdownTitle(text string) string {
+	for line := range strings.Lines(text) {
+		if h, ok := strings.CutPrefix(line, "# "); ok {
+			return strings.TrimSpace(h)
+		}
+	}
+	return ""
+}
+
+// snippet shortens text to its first n bytes, cut at a word boundary.
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= n {
+		return text
+	}
+	cut := strings.LastIndexByte(text[:n], ' ')
+	if cut <= 0 {
+		for cut = n; cut > 0 && !utf8.RuneStart(text[cut]); cut-- {
+		}
+	}
+	return text[:cut] + "…"
+}
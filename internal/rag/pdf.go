@@ -0,0 +1,787 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF text extraction. This reads the text drawn by each page's content
+// streams, decoding strings through the fonts' ToUnicode maps where they
+// have one. It handles uncompressed and Flate-compressed streams and
+// object streams; it needs no cross-reference table, so damaged files
+// often still work. Scanned pages hold images, not text, and encrypted
+// files are rejected.
+
+const (
+	// maxPDFStream bounds one decompressed stream.
+	maxPDFStream = 64 << 20
+	// maxPDFDepth bounds nesting of page trees and references.
+	maxPDFDepth = 32
+)
+
+// PDF objects are represented as:
+//
+//	null → nil, booleans → bool, numbers → float64, strings → pdfString,
+//	names → pdfName, arrays → []any, dictionaries → pdfDict,
+//	streams → *pdfStream, references → pdfRef, operators → pdfKeyword.
+type (
+	pdfString  []byte
+	pdfName    string
+	pdfKeyword string
+	pdfDict    map[pdfName]any
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+var (
+	objHeader  = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	encryptKey = regexp.MustCompile(`/Encrypt\b`)
+)
+
+type pdfDoc struct {
+	objs map[int]any
+}
+
+// pdfText returns the text of the PDF in data, pages separated by blank
+// lines.
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return "", errors.New("not a PDF file")
+	}
+	if encryptKey.Match(data) {
+		return "", errors.New("encrypted PDFs are not supported")
+	}
+	d := &pdfDoc{objs: make(map[int]any)}
+	d.load(data)
+
+	var pages []string
+	for _, page := range d.pages() {
+		if text := d.pageText(page); text != "" {
+			pages = append(pages, text)
+		}
+	}
+	if len(pages) == 0 {
+		return "", errors.New("no text found; scanned pages need OCR first")
+	}
+	return strings.Join(pages, "\n\n"), nil
+}
+
+// load reads every indirect object, later definitions replacing earlier
+// ones as incremental updates do, then unpacks object streams.
+func (d *pdfDoc) load(data []byte) {
+	for _, m := range objHeader.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		p := &pdfParser{b: data, pos: m[1]}
+		v, err := p.object()
+		if err != nil {
+			continue
+		}
+		if dict, ok := v.(pdfDict); ok {
+			if s := p.stream(dict); s != nil {
+				v = s
+			}
+		}
+		d.objs[num] = v
+	}
+
+	for _, v := range d.objs {
+		s, ok := v.(*pdfStream)
+		if !ok || s.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		body, err := d.decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := d.resolve(s.dict["N"]).(float64)
+		first, _ := d.resolve(s.dict["First"]).(float64)
+		if int(first) > len(body) {
+			continue
+		}
+		header := &pdfParser{b: body[:int(first)]}
+		for range int(n) {
+			num, err1 := header.object()
+			off, err2 := header.object()
+			numF, ok1 := num.(float64)
+			offF, ok2 := off.(float64)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 {
+				break
+			}
+			if _, defined := d.objs[int(numF)]; defined {
+				continue
+			}
+			pos := int(first) + int(offF)
+			if pos < 0 || pos >= len(body) {
+				continue
+			}
+			p := &pdfParser{b: body, pos: pos}
+			if v, err := p.object(); err == nil {
+				d.objs[int(numF)] = v
+			}
+		}
+	}
+}
+
+// resolve follows references.
+func (d *pdfDoc) resolve(v any) any {
+	for range maxPDFDepth {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = d.objs[ref.num]
+	}
+	return nil
+}
+
+func (d *pdfDoc) dict(v any) pdfDict {
+	switch v := d.resolve(v).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return nil
+}
+
+// decode returns the decompressed content of s. Only FlateDecode is
+// supported, which is what text content uses in practice.
+func (d *pdfDoc) decode(s *pdfStream) ([]byte, error) {
+	var filters []any
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case nil:
+	case pdfName:
+		filters = []any{f}
+	case []any:
+		filters = f
+	}
+	data := s.raw
+	for _, f := range filters {
+		if d.resolve(f) != pdfName("FlateDecode") {
+			return nil, fmt.Errorf("unsupported filter %v", f)
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// Truncated streams are common; keep what inflated.
+		data, err = io.ReadAll(io.LimitReader(r, maxPDFStream))
+		if err != nil && len(data) == 0 {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// page is a page dictionary with the resources it inherits.
+type page struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages lists the pages in order by walking the page tree from its root.
+// Without a usable tree, every page object is taken in object order.
+func (d *pdfDoc) pages() []page {
+	var out []page
+	seen := make(map[int]bool)
+	var walk func(v any, resources pdfDict, depth int)
+	walk = func(v any, resources pdfDict, depth int) {
+		if ref, ok := v.(pdfRef); ok {
+			if seen[ref.num] {
+				return
+			}
+			seen[ref.num] = true
+		}
+		node := d.dict(v)
+		if node == nil || depth > maxPDFDepth {
+			return
+		}
+		if r := d.dict(node["Resources"]); r != nil {
+			resources = r
+		}
+		switch node["Type"] {
+		case pdfName("Pages"):
+			kids, _ := d.resolve(node["Kids"]).([]any)
+			for _, kid := range kids {
+				walk(kid, resources, depth+1)
+			}
+		case pdfName("Page"):
+			out = append(out, page{node, resources})
+		}
+	}
+
+	nums := make([]int, 0, len(d.objs))
+	for num := range d.objs {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+	for _, num := range nums {
+		if node := d.dict(d.objs[num]); node["Type"] == pdfName("Pages") && node["Parent"] == nil {
+			walk(node, nil, 0)
+			if len(out) > 0 {
+				return out
+			}
+		}
+	}
+	for _, num := range nums {
+		if node := d.dict(d.objs[num]); node["Type"] == pdfName("Page") {
+			out = append(out, page{node, d.dict(node["Resources"])})
+		}
+	}
+	return out
+}
+
+// pageText interprets the page's content streams and returns the text they
+// draw, with line breaks where the text moves to a new line.
+func (d *pdfDoc) pageText(pg page) string {
+	var content []byte
+	contents := d.resolve(pg.dict["Contents"])
+	streams, ok := contents.([]any)
+	if !ok {
+		streams = []any{contents}
+	}
+	for _, s := range streams {
+		if s, ok := d.resolve(s).(*pdfStream); ok {
+			if data, err := d.decode(s); err == nil {
+				content = append(append(content, data...), '\n')
+			}
+		}
+	}
+
+	fonts := make(map[pdfName]*cmap)
+	for name, f := range d.dict(pg.resources["Font"]) {
+		if s, ok := d.resolve(d.dict(f)["ToUnicode"]).(*pdfStream); ok {
+			if data, err := d.decode(s); err == nil {
+				fonts[name] = parseCMap(data)
+			}
+		}
+	}
+
+	var (
+		sb       strings.Builder
+		font     *cmap
+		lineY    float64
+		operands []any
+	)
+	write := func(s pdfString) {
+		sb.WriteString(font.decode(s))
+	}
+	space := func() {
+		if str := sb.String(); str != "" && !strings.HasSuffix(str, " ") && !strings.HasSuffix(str, "\n") {
+			sb.WriteByte(' ')
+		}
+	}
+	newline := func() {
+		if str := sb.String(); str != "" && !strings.HasSuffix(str, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	moveTo := func(y float64) {
+		if diff := y - lineY; diff > 0.5 || diff < -0.5 {
+			newline()
+		} else {
+			space()
+		}
+		lineY = y
+	}
+	num := func(i int) float64 {
+		if i < 0 || i >= len(operands) {
+			return 0
+		}
+		f, _ := operands[i].(float64)
+		return f
+	}
+
+	p := &pdfParser{b: content}
+	for {
+		v, err := p.object()
+		if err != nil {
+			break
+		}
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "Tf":
+			if len(operands) > 0 {
+				name, _ := operands[0].(pdfName)
+				font = fonts[name]
+			}
+		case "Td", "TD":
+			moveTo(lineY + num(1))
+		case "Tm":
+			moveTo(num(5))
+		case "T*":
+			newline()
+		case "Tj":
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					write(s)
+				}
+			}
+		case "'", `"`:
+			newline()
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					write(s)
+				}
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				parts, _ := operands[len(operands)-1].([]any)
+				for _, part := range parts {
+					switch part := part.(type) {
+					case pdfString:
+						write(part)
+					case float64:
+						// A large negative adjustment is how many
+						// generators draw the gap between words.
+						if part < -200 {
+							space()
+						}
+					}
+				}
+			}
+		case "ID":
+			p.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+
+	lines := strings.Split(sb.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(normalize(strings.Join(lines, "\n")))
+}
+
+// cmap maps character codes to text, from a font's ToUnicode stream.
+type cmap struct {
+	widths []int // code lengths in bytes, from the codespace ranges
+	chars  map[string]string
+}
+
+// maxCMapRange bounds the codes one bfrange entry expands to.
+const maxCMapRange = 1 << 16
+
+func parseCMap(data []byte) *cmap {
+	m := &cmap{chars: make(map[string]string)}
+	p := &pdfParser{b: data}
+	var operands []any
+	for {
+		v, err := p.object()
+		if err != nil {
+			break
+		}
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if lo, ok := operands[i].(pdfString); ok && !slices.Contains(m.widths, len(lo)) {
+					m.widths = append(m.widths, len(lo))
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					m.chars[string(src)] = utf16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				from, to := codeValue(lo), codeValue(hi)
+				if to < from || to-from >= maxCMapRange {
+					continue
+				}
+				for c := from; c <= to; c++ {
+					var text string
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						// Each code maps to the destination incremented by
+						// its offset in the range.
+						next := slices.Clone(dst)
+						if len(next) > 0 {
+							next[len(next)-1] += byte(c - from)
+						}
+						text = utf16BE(next)
+					case []any:
+						if int(c-from) < len(dst) {
+							if s, ok := dst[c-from].(pdfString); ok {
+								text = utf16BE(s)
+							}
+						}
+					}
+					m.chars[string(codeBytes(c, len(lo)))] = text
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	slices.Sort(m.widths)
+	return m
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func codeBytes(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+// decode turns a string drawn in the font into text. Without a map, the
+// bytes are taken as PDFDocEncoding, which matches Latin-1 for text, or
+// as UTF-16 when marked so.
+func (m *cmap) decode(s pdfString) string {
+	if m == nil || len(m.chars) == 0 {
+		if bytes.HasPrefix(s, []byte{0xFE, 0xFF}) {
+			return utf16BE(s[2:])
+		}
+		runes := make([]rune, 0, len(s))
+		for _, c := range s {
+			if c >= 0x20 || c == '\t' {
+				runes = append(runes, rune(c))
+			}
+		}
+		return string(runes)
+	}
+	widths := m.widths
+	if len(widths) == 0 {
+		widths = []int{1, 2}
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		n := widths[0]
+		for _, w := range widths {
+			if i+w <= len(s) {
+				if text, ok := m.chars[string(s[i:i+w])]; ok {
+					sb.WriteString(text)
+					n = w
+					break
+				}
+			}
+		}
+		i += n
+	}
+	return sb.String()
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfParser reads PDF objects and content stream tokens from b.
+type pdfParser struct {
+	b   []byte
+	pos int
+}
+
+var errPDFEnd = errors.New("unexpected end of PDF data")
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.b) {
+		c := p.b[p.pos]
+		switch {
+		case isPDFSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.b) && p.b[p.pos] != '\n' && p.b[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token reads a run of regular characters.
+func (p *pdfParser) token() string {
+	start := p.pos
+	for p.pos < len(p.b) && !isPDFSpace(p.b[p.pos]) && !isPDFDelim(p.b[p.pos]) {
+		p.pos++
+	}
+	return string(p.b[start:p.pos])
+}
+
+func (p *pdfParser) object() (any, error) {
+	return p.objectDepth(0)
+}
+
+func (p *pdfParser) objectDepth(depth int) (any, error) {
+	if depth > maxPDFDepth {
+		return nil, errors.New("PDF objects nested too deeply")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.b) {
+		return nil, errPDFEnd
+	}
+	switch c := p.b[p.pos]; {
+	case c == '/':
+		p.pos++
+		return pdfName(unescapeName(p.token())), nil
+	case c == '(':
+		return p.literalString()
+	case c == '<' && p.pos+1 < len(p.b) && p.b[p.pos+1] == '<':
+		p.pos += 2
+		dict := make(pdfDict)
+		for {
+			p.skipSpace()
+			if p.pos+1 < len(p.b) && p.b[p.pos] == '>' && p.b[p.pos+1] == '>' {
+				p.pos += 2
+				return dict, nil
+			}
+			k, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if name, ok := k.(pdfName); ok {
+				dict[name] = v
+			}
+		}
+	case c == '<':
+		return p.hexString()
+	case c == '[':
+		p.pos++
+		var arr []any
+		for {
+			p.skipSpace()
+			if p.pos < len(p.b) && p.b[p.pos] == ']' {
+				p.pos++
+				return arr, nil
+			}
+			v, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		// Stray delimiters become operators so callers can skip them.
+		p.pos++
+		return pdfKeyword([]byte{c}), nil
+	}
+
+	tok := p.token()
+	if tok == "" {
+		p.pos++
+		return pdfKeyword(""), nil
+	}
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		return pdfKeyword(tok), nil
+	}
+	// An integer may start a reference: "12 0 R".
+	if !strings.ContainsAny(tok, ".eE") {
+		save := p.pos
+		p.skipSpace()
+		gen := p.token()
+		p.skipSpace()
+		if g, err := strconv.Atoi(gen); err == nil && p.pos < len(p.b) && p.b[p.pos] == 'R' &&
+			(p.pos+1 == len(p.b) || isPDFSpace(p.b[p.pos+1]) || isPDFDelim(p.b[p.pos+1])) {
+			p.pos++
+			return pdfRef{int(f), g}, nil
+		}
+		p.pos = save
+	}
+	return f, nil
+}
+
+func unescapeName(s string) string {
+	if !strings.Contains(s, "#") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func (p *pdfParser) literalString() (pdfString, error) {
+	p.pos++ // (
+	var out []byte
+	depth := 1
+	for p.pos < len(p.b) {
+		c := p.b[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return out, nil
+			}
+		case '\\':
+			if p.pos >= len(p.b) {
+				return out, nil
+			}
+			e := p.b[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// A line continuation.
+				if p.pos < len(p.b) && p.b[p.pos] == '\n' {
+					p.pos++
+				}
+			case '\n':
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := int(e - '0')
+				for range 2 {
+					if p.pos < len(p.b) && p.b[p.pos] >= '0' && p.b[p.pos] <= '7' {
+						v = v*8 + int(p.b[p.pos]-'0')
+						p.pos++
+					}
+				}
+				out = append(out, byte(v))
+			default:
+				out = append(out, e)
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func (p *pdfParser) hexString() (pdfString, error) {
+	p.pos++ // <
+	var digits []byte
+	for p.pos < len(p.b) && p.b[p.pos] != '>' {
+		if c := p.b[p.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		p.pos++
+	}
+	p.pos++ // >
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return out, nil
+}
+
+// stream reads the stream data following a dictionary, if there is one.
+func (p *pdfParser) stream(dict pdfDict) *pdfStream {
+	p.skipSpace()
+	if !bytes.HasPrefix(p.b[p.pos:], []byte("stream")) {
+		return nil
+	}
+	p.pos += len("stream")
+	if p.pos < len(p.b) && p.b[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.b) && p.b[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+	// Trust /Length when it is a direct number that lands on endstream;
+	// otherwise look for the keyword.
+	if n, ok := dict["Length"].(float64); ok && n >= 0 && start+int(n) <= len(p.b) {
+		end := start + int(n)
+		rest := bytes.TrimLeft(p.b[end:], " \r\n")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return &pdfStream{dict, p.b[start:end]}
+		}
+	}
+	i := bytes.Index(p.b[start:], []byte("endstream"))
+	if i < 0 {
+		return nil
+	}
+	raw := bytes.TrimRight(p.b[start:start+i], "\r\n")
+	return &pdfStream{dict, raw}
+}
+
+// skipInlineImage skips the binary data of an inline image, after its ID
+// operator, up to its EI operator.
+func (p *pdfParser) skipInlineImage() {
+	for p.pos+2 <= len(p.b) {
+		i := bytes.Index(p.b[p.pos:], []byte("EI"))
+		if i < 0 {
+			p.pos = len(p.b)
+			return
+		}
+		end := p.pos + i
+		p.pos = end + 2
+		if end > 0 && isPDFSpace(p.b[end-1]) && (p.pos == len(p.b) || isPDFSpace(p.b[p.pos])) {
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func deflate(s string) string {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.String()
+}
+
+// buildPDF writes objects as a PDF file. Objects are numbered from 1;
+// a stream object is given as [dictionary, data].
+func buildPDF(objects ...any) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		switch obj := obj.(type) {
+		case string:
+			b.WriteString(obj)
+		case [2]string:
+			fmt.Fprintf(&b, "<< %s /Length %d >>\nstream\n%s\nendstream", obj[0], len(obj[1]), obj[1])
+		}
+		b.WriteString("\nendobj\n")
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestPDFText(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+1 beginbfchar <0001> <0048> endbfchar
+1 beginbfrange <0002> <0003> <0069> endbfrange
+endcmap`
+	// Object 7, the font using the map, is only in the object stream.
+	font := "<< /Type /Font /Subtype /Type0 /BaseFont /Custom /ToUnicode 9 0 R >>"
+	objStm := "7 0 " + font
+
+	data := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 6 0 R] /Count 2 /Resources << /Font << /F1 4 0 R /F2 7 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		[2]string{"", "BT /F1 12 Tf 72 700 Td (Restart the \\(primary\\) database) Tj 0 -14 Td [(with)-300(care)] TJ ET"},
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R >>",
+		"null",
+		[2]string{"/Filter /FlateDecode", deflate("BT /F2 12 Tf 1 0 0 1 72 700 Tm <000100020003> Tj ET")},
+		[2]string{"/Filter /FlateDecode", deflate(cmap)},
+		[2]string{fmt.Sprintf("/Type /ObjStm /N 1 /First %d /Filter /FlateDecode", len("7 0 ")), deflate(objStm)},
+	)
+	// The placeholder object 7 must not hide the one in the object stream.
+	data = bytes.Replace(data, []byte("7 0 obj\nnull\nendobj\n"), nil, 1)
+
+	text, err := pdfText(data)
+	if err != nil {
+		t.Fatalf("pdfText failed: %v", err)
+	}
+	want := "Restart the (primary) database\nwith care\n\nHij"
+	if text != want {
+		t.Errorf("Expected %q, got %q", want, text)
+	}
+
+	for _, bad := range [][]byte{
+		[]byte("hello"),
+		buildPDF("<< /Type /Catalog >>"),
+		append(buildPDF("<< /Type /Catalog >>"), "trailer << /Encrypt 5 0 R >>"...),
+	} {
+		if _, err := pdfText(bad); err == nil {
+			t.Errorf("Expected an error for %q", strings.ToValidUTF8(string(bad[:min(40, len(bad))]), "?"))
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Package rag is a local document index for retrieval-augmented
+// generation. Documents are read as text, split into overlapping chunks
+// and ranked with BM25; with an Embedder, keyword and embedding rankings
+// are fused.
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"chat-service/internal/chat"
+)
+
+const (
+	defaultChunkSize = 1200
+	// embedBatch is how many chunks are embedded per request.
+	embedBatch = 64
+	// candidates is how many chunks each ranking contributes to fusion.
+	candidates = 50
+)
+
+// validID restricts document IDs to characters that are safe as file names.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Options configures an Index.
+type Options struct {
+	// Dir is where documents are persisted as JSON files; empty keeps them
+	// in memory only.
+	Dir string
+	// ChunkSize is in bytes; zero uses the default. ChunkOverlap is how
+	// many bytes a chunk repeats from the one before, less than half of
+	// ChunkSize.
+	ChunkSize    int
+	ChunkOverlap int
+	// Embedder, when set, adds embedding similarity to keyword ranking.
+	// Chunks whose similarity to the query is below MinSimilarity are not
+	// retrieved on similarity alone.
+	Embedder      chat.Embedder
+	MinSimilarity float64
+}
+
+// Index is a chat.DocumentIndex kept in memory and optionally persisted. It
+// is safe for concurrent use.
+type Index struct {
+	opts Options
+
+	mu     sync.RWMutex
+	docs   map[string]*record
+	df     map[string]int // chunks containing each term
+	chunks int
+	length int // total terms over all chunks
+}
+
+// record is a document with its chunks, as persisted.
+type record struct {
+	Document chat.Document `json:"document"`
+	Chunks   []string      `json:"chunks"`
+	Vectors  [][]float32   `json:"vectors,omitempty"`
+	// Model is the embedding model the vectors came from.
+	Model string `json:"embedding_model,omitempty"`
+
+	tf      []map[string]int
+	lengths []int
+}
+
+// Open returns an index over the documents persisted in opts.Dir, if set.
+// With an Embedder, documents persisted without vectors, or with vectors
+// from another model, are embedded again; a failure is logged and leaves
+// them to keyword ranking.
+func Open(ctx context.Context, opts Options) (*Index, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	opts.ChunkOverlap = max(0, min(opts.ChunkOverlap, opts.ChunkSize/2-1))
+	x := &Index{opts: opts, docs: make(map[string]*record), df: make(map[string]int)}
+	if opts.Dir == "" {
+		return x, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create documents dir: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(opts.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if opts.Embedder != nil && (rec.Model != opts.Embedder.Model() || len(rec.Vectors) != len(rec.Chunks)) {
+			if rec.Vectors, err = x.embed(ctx, rec.Chunks); err != nil {
+				slog.Warn("Failed to embed document", "document_id", rec.Document.ID, "error", err)
+			} else {
+				rec.Model = opts.Embedder.Model()
+				if err := x.persist(&rec); err != nil {
+					return nil, err
+				}
+			}
+		}
+		x.insert(&rec)
+	}
+	return x, nil
+}
+
+// Add reads data as doc.ContentType, splits it into chunks and indexes
+// them under doc.ID. An empty title is taken from the document.
+func (x *Index) Add(ctx context.Context, doc chat.Document, data []byte) (chat.Document, error) {
+	if !validID.MatchString(doc.ID) {
+		return chat.Document{}, fmt.Errorf("invalid document id %q", doc.ID)
+	}
+	title, text, err := extract(doc.ContentType, data)
+	if err != nil {
+		return chat.Document{}, err
+	}
+	chunks := split(text, x.opts.ChunkSize, x.opts.ChunkOverlap)
+	if len(chunks) == 0 {
+		return chat.Document{}, fmt.Errorf("%w: no text found", chat.ErrUnreadableDocument)
+	}
+	if doc.Title == "" {
+		doc.Title = title
+	}
+	if doc.Title == "" {
+		doc.Title = snippet(chunks[0], 80)
+	}
+	doc.Size = len(data)
+	doc.Chunks = len(chunks)
+
+	rec := &record{Document: doc, Chunks: chunks}
+	if x.opts.Embedder != nil {
+		if rec.Vectors, err = x.embed(ctx, chunks); err != nil {
+			return chat.Document{}, fmt.Errorf("failed to embed document: %w", err)
+		}
+		rec.Model = x.opts.Embedder.Model()
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.persist(rec); err != nil {
+		return chat.Document{}, err
+	}
+	if old, ok := x.docs[doc.ID]; ok {
+		x.drop(old)
+	}
+	x.insert(rec)
+	return doc, nil
+}
+
+// List returns the documents, newest first.
+func (x *Index) List(ctx context.Context) ([]chat.Document, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	docs := make([]chat.Document, 0, len(x.docs))
+	for _, rec := range x.docs {
+		docs = append(docs, rec.Document)
+	}
+	slices.SortFunc(docs, func(a, b chat.Document) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return docs, nil
+}
+
+func (x *Index) Get(ctx context.Context, id string) (chat.Document, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	rec, ok := x.docs[id]
+	if !ok {
+		return chat.Document{}, chat.ErrDocumentNotFound
+	}
+	return rec.Document, nil
+}
+
+func (x *Index) Delete(ctx context.Context, id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	rec, ok := x.docs[id]
+	if !ok {
+		return chat.ErrDocumentNotFound
+	}
+	if x.opts.Dir != "" {
+		if err := os.Remove(x.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete document: %w", err)
+		}
+	}
+	x.drop(rec)
+	return nil
+}
+
+// Disown clears UploadedBy on the documents owner uploaded.
+func (x *Index) Disown(ctx context.Context, owner string) ([]string, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ids := make([]string, 0)
+	for id, rec := range x.docs {
+		if rec.Document.UploadedBy != owner {
+			continue
+		}
+		rec.Document.UploadedBy = ""
+		if err := x.persist(rec); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// hit is a chunk considered for a query.
+type hit struct {
+	rec   *record
+	chunk int
+	score float64
+}
+
+// Retrieve returns the k chunks most relevant to query, best first. Only
+// chunks sharing a term with the query, or similar enough to it by
+// embedding, are returned. An embedding failure falls back to keyword
+// ranking.
+func (x *Index) Retrieve(ctx context.Context, query string, k int) ([]chat.Passage, error) {
+	var qvec []float32
+	if x.opts.Embedder != nil {
+		vecs, err := x.opts.Embedder.Embed(ctx, []string{query})
+		if err == nil && len(vecs) == 1 {
+			qvec = vecs[0]
+		} else {
+			slog.WarnContext(ctx, "Failed to embed query, using keyword ranking", "error", err)
+		}
+	}
+	qterms := queryTerms(query)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.chunks == 0 {
+		return nil, nil
+	}
+	avgLen := float64(x.length) / float64(x.chunks)
+	var keyword, semantic []hit
+	for _, rec := range x.docs {
+		for i := range rec.Chunks {
+			if s := bm25(qterms, rec.tf[i], rec.lengths[i], x.df, x.chunks, avgLen); s > 0 {
+				keyword = append(keyword, hit{rec, i, s})
+			}
+			if qvec != nil && rec.Model == x.opts.Embedder.Model() && i < len(rec.Vectors) {
+				if s := cosine(qvec, rec.Vectors[i]); s >= x.opts.MinSimilarity && s > 0 {
+					semantic = append(semantic, hit{rec, i, s})
+				}
+			}
+		}
+	}
+
+	ranked := top(keyword, candidates)
+	if qvec != nil {
+		ranked = fuse(ranked, top(semantic, candidates))
+	}
+	ranked = ranked[:min(k, len(ranked))]
+	passages := make([]chat.Passage, len(ranked))
+	for i, h := range ranked {
+		passages[i] = chat.Passage{
+			DocumentID: h.rec.Document.ID,
+			Title:      h.rec.Document.Title,
+			Chunk:      h.chunk,
+			Text:       h.rec.Chunks[h.chunk],
+			Score:      h.score,
+		}
+	}
+	return passages, nil
+}
+
+// top sorts hits best first, breaking ties by document and position so
+// results are stable, and keeps at most n.
+func top(hits []hit, n int) []hit {
+	slices.SortFunc(hits, func(a, b hit) int {
+		return cmp.Or(
+			cmp.Compare(b.score, a.score),
+			cmp.Compare(a.rec.Document.ID, b.rec.Document.ID),
+			cmp.Compare(a.chunk, b.chunk),
+		)
+	})
+	return hits[:min(n, len(hits))]
+}
+
+// fuse merges rankings by reciprocal rank fusion: a chunk scores the sum
+// of 1/(rrfK+rank) over the rankings it appears in.
+func fuse(rankings ...[]hit) []hit {
+	type key struct {
+		id    string
+		chunk int
+	}
+	fused := make(map[key]*hit)
+	var order []key
+	for _, ranking := range rankings {
+		for rank, h := range ranking {
+			k := key{h.rec.Document.ID, h.chunk}
+			if fused[k] == nil {
+				fused[k] = &hit{rec: h.rec, chunk: h.chunk}
+				order = append(order, k)
+			}
+			fused[k].score += 1 / float64(rrfK+rank+1)
+		}
+	}
+	hits := make([]hit, len(order))
+	for i, k := range order {
+		hits[i] = *fused[k]
+	}
+	return top(hits, len(hits))
+}
+
+// insert indexes rec. The caller holds the write lock, or owns x.
+func (x *Index) insert(rec *record) {
+	rec.tf = make([]map[string]int, len(rec.Chunks))
+	rec.lengths = make([]int, len(rec.Chunks))
+	for i, c := range rec.Chunks {
+		tf := make(map[string]int)
+		ts := terms(c)
+		for _, t := range ts {
+			if tf[t] == 0 {
+				x.df[t]++
+			}
+			tf[t]++
+		}
+		rec.tf[i], rec.lengths[i] = tf, len(ts)
+		x.length += len(ts)
+	}
+	x.chunks += len(rec.Chunks)
+	x.docs[rec.Document.ID] = rec
+}
+
+// drop removes rec from the index. The caller holds the write lock.
+func (x *Index) drop(rec *record) {
+	for i, tf := range rec.tf {
+		for t := range tf {
+			if x.df[t]--; x.df[t] == 0 {
+				delete(x.df, t)
+			}
+		}
+		x.length -= rec.lengths[i]
+	}
+	x.chunks -= len(rec.Chunks)
+	delete(x.docs, rec.Document.ID)
+}
+
+func (x *Index) embed(ctx context.Context, chunks []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(chunks))
+	for start := 0; start < len(chunks); start += embedBatch {
+		batch := chunks[start:min(start+embedBatch, len(chunks))]
+		vecs, err := x.opts.Embedder.Embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(vecs) != len(batch) {
+			return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vecs), len(batch))
+		}
+		vectors = append(vectors, vecs...)
+	}
+	return vectors, nil
+}
+
+func (x *Index) path(id string) string {
+	return filepath.Join(x.opts.Dir, id+".json")
+}
+
+// persist writes rec atomically via a temp file and rename. It does
+// nothing for an in-memory index.
+func (x *Index) persist(rec *record) error {
+	if x.opts.Dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	id := rec.Document.ID
+	tmp, err := os.CreateTemp(x.opts.Dir, "."+id+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), x.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-service/internal/chat"
+)
+
+const runbook = `# Database failover
+
+When the primary database stops answering, promote the replica.
+
+Check replication lag first. Restarting the primary during a failover loses writes.`
+
+func addDoc(t *testing.T, x *Index, id, contentType, content string) chat.Document {
+	t.Helper()
+	doc, err := x.Add(context.Background(), chat.Document{ID: id, ContentType: contentType, CreatedAt: time.Now()}, []byte(content))
+	if err != nil {
+		t.Fatalf("Add(%s) failed: %v", id, err)
+	}
+	return doc
+}
+
+func TestSplit(t *testing.T) {
+	var paragraphs []string
+	for i := range 20 {
+		paragraphs = append(paragraphs, strings.Repeat("word ", 10+i)+"end.")
+	}
+	text := strings.Join(paragraphs, "\n\n\n")
+
+	chunks := split(text, 200, 40)
+	if len(chunks) < 5 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		if len(c) > 200 {
+			t.Errorf("Chunk %d has %d bytes", i, len(c))
+		}
+		if strings.Contains(c, "\n\n\n") {
+			t.Errorf("Expected blank lines collapsed in chunk %d", i)
+		}
+	}
+	if got := strings.Count(strings.Join(chunks, " "), "end."); got < len(paragraphs) {
+		t.Errorf("Expected every paragraph kept, found %d of %d", got, len(paragraphs))
+	}
+	if !strings.HasPrefix(chunks[1], "word") {
+		t.Errorf("Expected chunks to start on a word, got %q", chunks[1][:10])
+	}
+
+	if got := split("  \n\n ", 100, 10); len(got) != 0 {
+		t.Errorf("Expected no chunks for blank text, got %q", got)
+	}
+	if got := split(strings.Repeat("é", 100), 51, 0); len(got) < 2 || !strings.HasPrefix(got[1], "é") {
+		t.Errorf("Expected cuts on rune boundaries, got %q", got)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	x, err := Open(ctx, Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := addDoc(t, x, "db", "text/markdown", runbook)
+	if doc.Title != "Database failover" || doc.Chunks != 1 || doc.Size != len(runbook) {
+		t.Errorf("Unexpected document %+v", doc)
+	}
+	addDoc(t, x, "vpn", "text/html; charset=utf-8", `<html><head><title>VPN access</title></head>
+		<body><p>Request VPN access through the IT portal.</p><script>track()</script></body></html>`)
+	addDoc(t, x, "misc", "text/plain", "Lunch is served at noon. The database team eats at one.")
+
+	passages, err := x.Retrieve(ctx, "How do I restart the primary database?", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passages) != 2 || passages[0].DocumentID != "db" || passages[1].DocumentID != "misc" {
+		t.Fatalf("Expected the runbook first, got %+v", passages)
+	}
+	if !strings.Contains(passages[0].Text, "promote the replica") || passages[0].Title != "Database failover" {
+		t.Errorf("Unexpected passage %+v", passages[0])
+	}
+	if passages, _ := x.Retrieve(ctx, "how do we do it?", 3); len(passages) != 0 {
+		t.Errorf("Expected stopwords alone to match nothing, got %+v", passages)
+	}
+	if passages, _ := x.Retrieve(ctx, "vpn portal", 3); len(passages) != 1 || strings.Contains(passages[0].Text, "track") {
+		t.Errorf("Expected the VPN page without its script, got %+v", passages)
+	}
+
+	for _, tt := range []struct {
+		contentType, content string
+		want                 error
+	}{
+		{"image/png", "\x89PNG", chat.ErrUnsupportedDocument},
+		{"", "text", chat.ErrUnsupportedDocument},
+		{"text/plain", " \n ", chat.ErrUnreadableDocument},
+		{"application/pdf", "not a pdf", chat.ErrUnreadableDocument},
+	} {
+		_, err := x.Add(ctx, chat.Document{ID: "bad", ContentType: tt.contentType}, []byte(tt.content))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Add(%q) = %v, want %v", tt.contentType, err, tt.want)
+		}
+	}
+
+	if err := x.Delete(ctx, "misc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Delete(ctx, "misc"); !errors.Is(err, chat.ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+
+	if _, err := x.Add(ctx, chat.Document{ID: "mine", ContentType: "text/plain", UploadedBy: "alice"}, []byte("Rotate keys yearly.")); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := x.Disown(ctx, "alice"); err != nil || len(ids) != 1 || ids[0] != "mine" {
+		t.Errorf("Expected alice's document disowned, got %v, %v", ids, err)
+	}
+
+	reopened, err := Open(ctx, Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, _ := reopened.List(ctx)
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 documents after reopening, got %+v", docs)
+	}
+	if doc, _ := reopened.Get(ctx, "mine"); doc.UploadedBy != "" {
+		t.Errorf("Expected the uploader cleared on disk, got %+v", doc)
+	}
+	if passages, _ := reopened.Retrieve(ctx, "replica", 1); len(passages) != 1 || passages[0].DocumentID != "db" {
+		t.Errorf("Expected the index rebuilt on open, got %+v", passages)
+	}
+}
+
+// topicEmbedder embeds texts by which topics they mention, so related
+// words without shared terms end up similar.
+type topicEmbedder struct {
+	model string
+	calls int
+}
+
+func (e *topicEmbedder) Model() string { return e.model }
+
+func (e *topicEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	topics := [][]string{{"database", "replica", "postgres"}, {"vpn", "network"}}
+	vecs := make([][]float32, len(texts))
+	for i, text := range texts {
+		vecs[i] = make([]float32, len(topics))
+		for j, words := range topics {
+			for _, w := range words {
+				if strings.Contains(strings.ToLower(text), w) {
+					vecs[i][j]++
+				}
+			}
+		}
+	}
+	return vecs, nil
+}
+
+func TestIndexEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	embedder := &topicEmbedder{model: "topics-v1"}
+	x, _ := Open(ctx, Options{Dir: dir, Embedder: embedder, MinSimilarity: 0.5})
+	addDoc(t, x, "db", "text/markdown", runbook)
+	addDoc(t, x, "vpn", "text/plain", "Connect to the VPN before opening the network dashboard.")
+
+	passages, err := x.Retrieve(ctx, "postgres is down", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passages) != 1 || passages[0].DocumentID != "db" {
+		t.Errorf("Expected the runbook found by similarity alone, got %+v", passages)
+	}
+	if passages, _ := x.Retrieve(ctx, "dashboard", 2); len(passages) != 1 || passages[0].DocumentID != "vpn" {
+		t.Errorf("Expected keyword matches kept, got %+v", passages)
+	}
+	if embedder.calls != 4 {
+		t.Errorf("Expected one embedding call per document and query, got %d", embedder.calls)
+	}
+
+	embedder.calls = 0
+	Open(ctx, Options{Dir: dir, Embedder: embedder})
+	if embedder.calls != 0 {
+		t.Errorf("Expected stored vectors reused, got %d calls", embedder.calls)
+	}
+	embedder.model = "topics-v2"
+	reopened, _ := Open(ctx, Options{Dir: dir, Embedder: embedder, MinSimilarity: 0.5})
+	if embedder.calls != 2 {
+		t.Errorf("Expected documents embedded again for a new model, got %d calls", embedder.calls)
+	}
+	if passages, _ := reopened.Retrieve(ctx, "postgres is down", 1); len(passages) != 1 || passages[0].DocumentID != "db" {
+		t.Errorf("Expected similarity search after re-embedding, got %+v", passages)
+	}
+}
@@ -0,0 +1,105 @@
+package rag
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: k1 limits how much repeated terms count, b how much long
+// chunks are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// rrfK dampens the weight of top ranks when fusing keyword and
+	// embedding rankings.
+	rrfK = 60
+)
+
+// stopwords are left out of queries; they match nearly every chunk.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "can": true, "do": true, "does": true, "for": true, "from": true, "how": true,
+	"i": true, "if": true, "in": true, "is": true, "it": true, "me": true, "my": true,
+	"of": true, "on": true, "or": true, "our": true, "should": true, "so": true, "that": true,
+	"the": true, "this": true, "to": true, "us": true, "was": true, "we": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true, "will": true,
+	"with": true, "you": true, "your": true,
+}
+
+// terms splits s into lowercase words of letters and digits, with plural
+// endings removed so "restarts" matches "restart".
+func terms(s string) []string {
+	ws := words(s)
+	for i, w := range ws {
+		ws[i] = stem(w)
+	}
+	return ws
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func stem(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// queryTerms returns the distinct terms of a query, without stopwords.
+func queryTerms(q string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, t := range words(q) {
+		if stopwords[t] {
+			continue
+		}
+		if t = stem(t); !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// bm25 scores a chunk of length n with term frequencies tf against query
+// terms, given how many of total chunks contain each term and their
+// average length.
+func bm25(query []string, tf map[string]int, n int, df map[string]int, total int, avgLen float64) float64 {
+	var score float64
+	for _, t := range query {
+		f := float64(tf[t])
+		if f == 0 {
+			continue
+		}
+		d := float64(df[t])
+		idf := math.Log(1 + (float64(total)-d+0.5)/(d+0.5))
+		score += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(n)/avgLen))
+	}
+	return score
+}
+
+// cosine returns the cosine similarity of a and b, or 0 when they differ
+// in length, e.g. after switching embedding models.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,326 @@
+// Package redact finds personal data and secrets in text and replaces them
+// before the text leaves the service.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Mode selects what a detected value is replaced with.
+type Mode string
+
+const (
+	// ModeMask replaces values with a fixed marker naming the detector.
+	ModeMask Mode = "mask"
+	// ModeHash replaces values with a keyed hash, so equal values stay
+	// recognisably equal without being revealed.
+	ModeHash Mode = "hash"
+	// ModePlaceholder replaces values with numbered placeholders that can
+	// be restored in the model's answer.
+	ModePlaceholder Mode = "placeholder"
+)
+
+// Options configures a Redactor.
+type Options struct {
+	Mode Mode
+	// Detectors names the built-in detectors to run; empty runs them all.
+	Detectors []string
+	// Patterns adds custom detectors, mapping a name to a regular
+	// expression.
+	Patterns map[string]string
+	// Rehydrate restores placeholders in answers; it needs ModePlaceholder.
+	Rehydrate bool
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// valid filters out matches that only look like the data, e.g. card
+	// numbers failing the Luhn check. Nil accepts every match.
+	valid func(string) bool
+}
+
+// builtins are listed in priority order: where matches overlap, the
+// earlier detector wins, so a key is not half-redacted as a phone number.
+var builtins = []detector{
+	{
+		name: "secret",
+		pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----` +
+			`|\b(?:sk-[A-Za-z0-9_-]{20,}|gsk_[A-Za-z0-9]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})\b` +
+			`|(?i:bearer)\s+[A-Za-z0-9._~+/-]{20,}=*`),
+	},
+	{
+		name:    "card",
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   luhn,
+	},
+	{
+		name:    "email",
+		pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	},
+	{
+		name: "ip",
+		pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b` +
+			`|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`),
+		valid: validIP,
+	},
+	{
+		name:    "phone",
+		pattern: regexp.MustCompile(`\+?\(?\d[\d ().-]{7,}\d`),
+		valid: func(s string) bool {
+			n := countDigits(s)
+			return n >= 9 && n <= 15 && !isoDate.MatchString(s)
+		},
+	},
+}
+
+// isoDate matches timestamps, which would otherwise pass as phone numbers.
+var isoDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+// validIP accepts addresses, but not IPv6 look-alikes such as the "d::"
+// of "std::vector", by requiring some digits.
+func validIP(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return false
+	}
+	return addr.Is4() || countDigits(s) >= 2
+}
+
+// Names lists the built-in detectors.
+func Names() []string {
+	names := make([]string, len(builtins))
+	for i, d := range builtins {
+		names[i] = d.name
+	}
+	return names
+}
+
+// Redactor replaces sensitive values according to its options. It is safe
+// for concurrent use; per-request state lives in a Session.
+type Redactor struct {
+	mode      Mode
+	rehydrate bool
+	detectors []detector
+	hashKey   []byte
+}
+
+// New validates opts and builds a Redactor.
+func New(opts Options) (*Redactor, error) {
+	switch opts.Mode {
+	case ModeMask, ModeHash, ModePlaceholder:
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q: use mask, hash or placeholder", opts.Mode)
+	}
+	if opts.Rehydrate && opts.Mode != ModePlaceholder {
+		return nil, fmt.Errorf("rehydration needs placeholder mode (got %q)", opts.Mode)
+	}
+
+	r := &Redactor{mode: opts.Mode, rehydrate: opts.Rehydrate, hashKey: make([]byte, 32)}
+	rand.Read(r.hashKey)
+
+	for _, d := range builtins {
+		if len(opts.Detectors) == 0 || slices.Contains(opts.Detectors, d.name) {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+	for _, name := range opts.Detectors {
+		if !slices.Contains(Names(), name) {
+			return nil, fmt.Errorf("unknown redaction detector %q: use %s", name, strings.Join(Names(), ", "))
+		}
+	}
+
+	names := make([]string, 0, len(opts.Patterns))
+	for name := range opts.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		re, err := regexp.Compile(opts.Patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: name, pattern: re})
+	}
+	return r, nil
+}
+
+// Session redacts the texts of one request. Equal values get the same
+// replacement throughout the session, so the model can still tell that two
+// messages mention the same address.
+type Session struct {
+	r            *Redactor
+	replacements map[string]string // value -> replacement
+	originals    map[string]string // placeholder -> value
+	counts       map[string]int    // placeholders issued per detector
+	found        map[string]int    // values redacted per detector
+}
+
+// NewSession starts a session.
+func (r *Redactor) NewSession() *Session {
+	return &Session{
+		r:            r,
+		replacements: make(map[string]string),
+		originals:    make(map[string]string),
+		counts:       make(map[string]int),
+		found:        make(map[string]int),
+	}
+}
+
+type span struct {
+	start, end int
+	detector   string
+}
+
+// Redact returns text with every detected value replaced.
+func (s *Session) Redact(text string) string {
+	var spans []span
+	for _, d := range s.r.detectors {
+		for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+			if d.valid != nil && !d.valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			overlaps := slices.ContainsFunc(spans, func(sp span) bool {
+				return loc[0] < sp.end && sp.start < loc[1]
+			})
+			if !overlaps {
+				spans = append(spans, span{loc[0], loc[1], d.name})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	at := 0
+	for _, sp := range spans {
+		b.WriteString(text[at:sp.start])
+		b.WriteString(s.replace(sp.detector, text[sp.start:sp.end]))
+		at = sp.end
+		s.found[sp.detector]++
+	}
+	b.WriteString(text[at:])
+	return b.String()
+}
+
+// Found counts the values redacted so far, per detector.
+func (s *Session) Found() map[string]int {
+	return s.found
+}
+
+func (s *Session) replace(detector, value string) string {
+	if r, ok := s.replacements[value]; ok {
+		return r
+	}
+	label := strings.ToUpper(detector)
+	var r string
+	switch s.r.mode {
+	case ModeMask:
+		r = "[" + label + " REDACTED]"
+	case ModeHash:
+		mac := hmac.New(sha256.New, s.r.hashKey)
+		mac.Write([]byte(value))
+		r = "[" + label + ":" + hex.EncodeToString(mac.Sum(nil)[:4]) + "]"
+	case ModePlaceholder:
+		s.counts[detector]++
+		r = fmt.Sprintf("[%s_%d]", label, s.counts[detector])
+		s.originals[r] = value
+	}
+	s.replacements[value] = r
+	return r
+}
+
+// Rehydrator returns a Rehydrator that restores this session's
+// placeholders, or nil when rehydration is off or nothing was replaced.
+func (s *Session) Rehydrator() *Rehydrator {
+	if !s.r.rehydrate || len(s.originals) == 0 {
+		return nil
+	}
+	pairs := make([]string, 0, 2*len(s.originals))
+	maxLen := 0
+	for placeholder, value := range s.originals {
+		pairs = append(pairs, placeholder, value)
+		maxLen = max(maxLen, len(placeholder))
+	}
+	return &Rehydrator{replacer: strings.NewReplacer(pairs...), maxLen: maxLen}
+}
+
+// Restore returns text with this session's placeholders replaced by the
+// values they stand for, or text unchanged when rehydration is off.
+func (s *Session) Restore(text string) string {
+	h := s.Rehydrator()
+	if h == nil {
+		return text
+	}
+	return h.replacer.Replace(text)
+}
+
+// Rehydrator restores placeholders in a streamed answer. A placeholder may
+// be split across chunks, so text that could be the start of one is held
+// back until the next chunk shows whether it is.
+type Rehydrator struct {
+	replacer *strings.Replacer
+	maxLen   int
+	pending  string
+}
+
+// Write takes the next chunk and returns the text that can be emitted.
+func (h *Rehydrator) Write(chunk string) string {
+	h.pending += chunk
+	cut := len(h.pending)
+	if i := strings.LastIndexByte(h.pending, '['); i >= 0 && len(h.pending)-i < h.maxLen && !strings.Contains(h.pending[i:], "]") {
+		cut = i
+	}
+	out := h.replacer.Replace(h.pending[:cut])
+	h.pending = h.pending[cut:]
+	return out
+}
+
+// Flush returns whatever is still held back.
+func (h *Rehydrator) Flush() string {
+	out := h.replacer.Replace(h.pending)
+	h.pending = ""
+	return out
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// luhn reports whether the digits of s pass the Luhn checksum used by
+// payment card numbers.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
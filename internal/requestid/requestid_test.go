@@ -0,0 +1,49 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil)))
+
+	logger.InfoContext(NewContext(context.Background(), "abc123"), "with id")
+	logger.InfoContext(context.Background(), "without id")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+
+	var first, second map[string]any
+	json.Unmarshal(lines[0], &first)
+	json.Unmarshal(lines[1], &second)
+
+	if first["request_id"] != "abc123" {
+		t.Errorf("Expected request_id 'abc123', got %v", first["request_id"])
+	}
+	if _, ok := second["request_id"]; ok {
+		t.Errorf("Expected no request_id without context, got %v", second["request_id"])
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := map[string]bool{
+		"":                        false,
+		"abc-123_DEF.456":         true,
+		"has space":               false,
+		"line\nbreak":             false,
+		string(make([]byte, 200)): false,
+		New():                     true,
+	}
+	for id, want := range tests {
+		if got := Valid(id); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+// Package templates is a chat.TemplateLibrary. Templates come from the
+// configuration, which is read on every call so reloads apply, or are
+// saved through the API, each save adding a version; those are kept in
+// memory and optionally persisted as one JSON file per template.
+package templates
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	"chat-service/internal/chat"
+)
+
+const (
+	// maxText bounds the text of a template and maxOutput what it renders
+	// to, in bytes.
+	maxText   = 64 << 10
+	maxOutput = 128 << 10
+	// maxListItems bounds list variables and maxRangeDepth how deeply
+	// range actions nest, which together bound the work of a render.
+	maxListItems  = 100
+	maxRangeDepth = 2
+)
+
+var (
+	validName     = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	validVariable = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,63}$`)
+	// wideVerb matches a printf verb with a computed or 4-digit width or
+	// precision, which could pad the output to any size.
+	wideVerb = regexp.MustCompile(`%[^a-zA-Z%]*(\*|\d{4})`)
+)
+
+// errOutputTooLong stops a render that exceeds maxOutput.
+var errOutputTooLong = fmt.Errorf("rendered text is longer than %d bytes", maxOutput)
+
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	// Replaces the builtin.
+	"printf": func(format string, args ...any) (string, error) {
+		if wideVerb.MatchString(format) {
+			return "", errors.New("printf widths and precisions must be under 1000")
+		}
+		return fmt.Sprintf(format, args...), nil
+	},
+}
+
+// Library is safe for concurrent use.
+type Library struct {
+	dir        string
+	configured func() []chat.Template
+
+	mu     sync.RWMutex
+	stored map[string][]chat.Template // by name, oldest version first
+	// last is the last version given out per name. It outlives a delete,
+	// so a version recorded in a message never names another text.
+	last map[string]int
+}
+
+// record is how a template is persisted. A deleted template keeps its
+// record, without versions.
+type record struct {
+	Name        string          `json:"name"`
+	LastVersion int             `json:"last_version"`
+	Versions    []chat.Template `json:"versions,omitempty"`
+}
+
+// Open returns a library of the configured templates and those saved in
+// dir, if set. configured may be nil.
+func Open(dir string, configured func() []chat.Template) (*Library, error) {
+	l := &Library{dir: dir, configured: configured, stored: make(map[string][]chat.Template), last: make(map[string]int)}
+	if dir == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create templates dir: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Name == "" {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, cmp.Or(err, errors.New("no name")))
+		}
+		l.last[rec.Name] = rec.LastVersion
+		if n := len(rec.Versions); n > 0 {
+			l.stored[rec.Name] = rec.Versions
+			l.last[rec.Name] = max(rec.LastVersion, rec.Versions[n-1].Version)
+		}
+	}
+	return l, nil
+}
+
+// config returns the configured template named name.
+func (l *Library) config(name string) (chat.Template, bool) {
+	if l.configured == nil {
+		return chat.Template{}, false
+	}
+	for _, t := range l.configured() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return chat.Template{}, false
+}
+
+// List returns the latest version of every template, by name. A configured
+// template hides a saved one of the same name.
+func (l *Library) List(ctx context.Context) ([]chat.Template, error) {
+	var list []chat.Template
+	if l.configured != nil {
+		list = append(list, l.configured()...)
+	}
+	l.mu.RLock()
+	for name, versions := range l.stored {
+		if _, ok := l.config(name); !ok {
+			list = append(list, versions[len(versions)-1])
+		}
+	}
+	l.mu.RUnlock()
+	slices.SortFunc(list, func(a, b chat.Template) int { return cmp.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+func (l *Library) Get(ctx context.Context, name string, version int) (chat.Template, error) {
+	if t, ok := l.config(name); ok {
+		if version != 0 && version != t.Version {
+			return chat.Template{}, fmt.Errorf("%w: %s version %d", chat.ErrTemplateNotFound, name, version)
+		}
+		return t, nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	versions := l.stored[name]
+	if len(versions) == 0 {
+		return chat.Template{}, fmt.Errorf("%w: %s", chat.ErrTemplateNotFound, name)
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, t := range versions {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+	return chat.Template{}, fmt.Errorf("%w: %s version %d", chat.ErrTemplateNotFound, name, version)
+}
+
+func (l *Library) Versions(ctx context.Context, name string) ([]chat.Template, error) {
+	if t, ok := l.config(name); ok {
+		return []chat.Template{t}, nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	versions := l.stored[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", chat.ErrTemplateNotFound, name)
+	}
+	newest := slices.Clone(versions)
+	slices.Reverse(newest)
+	return newest, nil
+}
+
+// Save checks t and stores it as the next version of its name. Versions
+// are never reused, even after a delete.
+func (l *Library) Save(ctx context.Context, t chat.Template) (chat.Template, error) {
+	if err := Check(t); err != nil {
+		return chat.Template{}, err
+	}
+	if _, ok := l.config(t.Name); ok {
+		return chat.Template{}, fmt.Errorf("%w: %s", chat.ErrTemplateReadOnly, t.Name)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t.Version = l.last[t.Name] + 1
+	versions := append(slices.Clip(l.stored[t.Name]), t)
+	if err := l.persist(record{Name: t.Name, LastVersion: t.Version, Versions: versions}); err != nil {
+		return chat.Template{}, err
+	}
+	l.stored[t.Name] = versions
+	l.last[t.Name] = t.Version
+	return t, nil
+}
+
+// Delete removes every version of a template but remembers the last
+// version number.
+func (l *Library) Delete(ctx context.Context, name string) error {
+	if _, ok := l.config(name); ok {
+		return fmt.Errorf("%w: %s", chat.ErrTemplateReadOnly, name)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.stored[name]; !ok {
+		return fmt.Errorf("%w: %s", chat.ErrTemplateNotFound, name)
+	}
+	if err := l.persist(record{Name: name, LastVersion: l.last[name]}); err != nil {
+		return err
+	}
+	delete(l.stored, name)
+	return nil
+}
+
+// Disown clears CreatedBy on the versions author saved. The versions stay,
+// as messages may record them.
+func (l *Library) Disown(ctx context.Context, author string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0)
+	for name, versions := range l.stored {
+		if !slices.ContainsFunc(versions, func(t chat.Template) bool { return t.CreatedBy == author }) {
+			continue
+		}
+		versions = slices.Clone(versions)
+		for i := range versions {
+			if versions[i].CreatedBy == author {
+				versions[i].CreatedBy = ""
+			}
+		}
+		if err := l.persist(record{Name: name, LastVersion: l.last[name], Versions: versions}); err != nil {
+			return nil, err
+		}
+		l.stored[name] = versions
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Render fills in a template version with vars. Unknown variables, missing
+// required ones and values of the wrong type are all reported in one
+// chat.ErrInvalidVariables error.
+func (l *Library) Render(ctx context.Context, name string, version int, vars map[string]any) (string, chat.TemplateUse, error) {
+	t, err := l.Get(ctx, name, version)
+	if err != nil {
+		return "", chat.TemplateUse{}, err
+	}
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	values, problems := resolve(t.Variables, vars)
+	if len(problems) > 0 {
+		return "", chat.TemplateUse{}, fmt.Errorf("%w: %s", chat.ErrInvalidVariables, strings.Join(problems, "; "))
+	}
+	text, err := execute(t, values)
+	if err != nil {
+		return "", chat.TemplateUse{}, fmt.Errorf("%w: %w", chat.ErrInvalidVariables, err)
+	}
+	return text, chat.TemplateUse{Name: t.Name, Version: t.Version, Variables: values}, nil
+}
+
+// Check reports whether t can be stored: its name, variables and text are
+// valid, it renders in bounded time, and it renders with its defaults.
+func Check(t chat.Template) error {
+	var problems []string
+	if !validName.MatchString(t.Name) {
+		problems = append(problems, fmt.Sprintf("name %q must be up to 64 letters, digits, _ or -", t.Name))
+	}
+	if strings.TrimSpace(t.Text) == "" {
+		problems = append(problems, "text is required")
+	} else if len(t.Text) > maxText {
+		problems = append(problems, fmt.Sprintf("text must be at most %d bytes", maxText))
+	}
+	seen := make(map[string]bool)
+	for _, v := range t.Variables {
+		switch {
+		case !validVariable.MatchString(v.Name):
+			problems = append(problems, fmt.Sprintf("variable name %q must be a letter or _ followed by letters, digits or _", v.Name))
+		case seen[v.Name]:
+			problems = append(problems, fmt.Sprintf("variable %q is declared twice", v.Name))
+		}
+		seen[v.Name] = true
+		if _, ok := zero(v.Type); !ok {
+			problems = append(problems, fmt.Sprintf("variable %q type must be string, number, integer, boolean or list (got %q)", v.Name, v.Type))
+		} else if v.Default != nil {
+			if _, err := convert(v.Type, v.Default); err != nil {
+				problems = append(problems, fmt.Sprintf("variable %q default %v", v.Name, err))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		// Catches syntax errors and references to undeclared variables
+		// outside of conditions.
+		values, _ := resolve(t.Variables, nil)
+		if _, err := execute(t, values); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", chat.ErrInvalidTemplate, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// resolve checks vars against the declared variables and fills in the rest
+// with defaults and zero values. Missing required variables are reported,
+// except when vars is nil, for Check.
+func resolve(declared []chat.Variable, vars map[string]any) (map[string]any, []string) {
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		if !slices.ContainsFunc(declared, func(v chat.Variable) bool { return v.Name == name }) {
+			problems = append(problems, fmt.Sprintf("unknown variable %q", name))
+		}
+	}
+	values := make(map[string]any, len(declared))
+	for _, v := range declared {
+		given, ok := vars[v.Name]
+		switch {
+		case ok && given != nil:
+			value, err := convert(v.Type, given)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("variable %q %v", v.Name, err))
+				continue
+			}
+			values[v.Name] = value
+		case v.Default != nil:
+			values[v.Name], _ = convert(v.Type, v.Default)
+		case v.Required && vars != nil:
+			problems = append(problems, fmt.Sprintf("missing required variable %q", v.Name))
+		default:
+			values[v.Name], _ = zero(v.Type)
+		}
+	}
+	return values, problems
+}
+
+func execute(t chat.Template, values map[string]any) (string, error) {
+	tmpl, err := template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(t.Text)
+	if err != nil {
+		return "", err
+	}
+	var lists []string
+	for _, v := range t.Variables {
+		if v.Type == "list" {
+			lists = append(lists, v.Name)
+		}
+	}
+	if err := bounded(tmpl.Tree.Root, lists, 0); err != nil {
+		return "", err
+	}
+	out := &limitedBuffer{max: maxOutput}
+	if err := tmpl.Execute(out, values); err != nil {
+		if errors.Is(err, errOutputTooLong) {
+			return "", errOutputTooLong
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// bounded checks that a template's loops end quickly: range only goes over
+// list variables, at most maxRangeDepth deep, and no template calls
+// another, which could recurse.
+func bounded(node parse.Node, lists []string, depth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := bounded(child, lists, depth); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("template and block actions are not allowed")
+	case *parse.IfNode:
+		return cmp.Or(bounded(n.List, lists, depth), bounded(n.ElseList, lists, depth))
+	case *parse.WithNode:
+		return cmp.Or(bounded(n.List, lists, depth), bounded(n.ElseList, lists, depth))
+	case *parse.RangeNode:
+		if depth == maxRangeDepth {
+			return fmt.Errorf("range actions may only nest %d deep", maxRangeDepth)
+		}
+		if !overList(n.Pipe, lists) {
+			return errors.New("range is only allowed over a list variable, like {{range .items}}")
+		}
+		return cmp.Or(bounded(n.List, lists, depth+1), bounded(n.ElseList, lists, depth))
+	}
+	return nil
+}
+
+// overList reports whether pipe is just a list variable, .name or $.name.
+func overList(pipe *parse.PipeNode, lists []string) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return len(arg.Ident) == 1 && slices.Contains(lists, arg.Ident[0])
+	case *parse.VariableNode:
+		return len(arg.Ident) == 2 && arg.Ident[0] == "$" && slices.Contains(lists, arg.Ident[1])
+	}
+	return false
+}
+
+// limitedBuffer is a bytes.Buffer that fails writes beyond max bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errOutputTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+// zero returns the zero value of a variable type, and false for an unknown
+// type.
+func zero(typ string) (any, bool) {
+	switch typ {
+	case "", "string":
+		return "", true
+	case "number":
+		return 0.0, true
+	case "integer":
+		return 0, true
+	case "boolean":
+		return false, true
+	case "list":
+		return []string{}, true
+	}
+	return nil, false
+}
+
+// convert returns v as a value of typ. Values come decoded from JSON or
+// YAML, so numbers may be any of the numeric kinds those produce.
+func convert(typ string, v any) (any, error) {
+	switch typ {
+	case "", "string":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "number":
+		if f, ok := number(v); ok {
+			return f, nil
+		}
+	case "integer":
+		if f, ok := number(v); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int(f), nil
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case "list":
+		switch l := v.(type) {
+		case []string:
+			if len(l) > maxListItems {
+				return nil, fmt.Errorf("must have at most %d items", maxListItems)
+			}
+			return l, nil
+		case []any:
+			if len(l) > maxListItems {
+				return nil, fmt.Errorf("must have at most %d items", maxListItems)
+			}
+			list := make([]string, len(l))
+			for i, item := range l {
+				s, ok := item.(string)
+				if !ok {
+					return nil, errors.New("must be a list of strings")
+				}
+				list[i] = s
+			}
+			return list, nil
+		}
+		return nil, errors.New("must be a list of strings")
+	}
+	return nil, fmt.Errorf("must be a%s %s", article(cmp.Or(typ, "string")), cmp.Or(typ, "string"))
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "n"
+	}
+	return ""
+}
+
+func (l *Library) path(name string) string {
+	return filepath.Join(l.dir, name+".json")
+}
+
+// persist writes a template's record, when a directory is set. The caller
+// holds the lock.
+func (l *Library) persist(rec record) error {
+	if l.dir == "" {
+		return nil
+	}
+	name := rec.Name
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode template: %w", err)
+	}
+	tmp, err := os.CreateTemp(l.dir, "."+name+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path(name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"chat-service/internal/chat"
+)
+
+var triage = chat.Template{
+	Name: "triage",
+	Text: `Triage ticket {{.ticket}} for the {{upper .team}} team.{{if .urgent}} It is urgent.{{end}}
+Labels: {{join .labels ", "}}. Budget: {{.hours}}h.`,
+	Variables: []chat.Variable{
+		{Name: "ticket", Required: true},
+		{Name: "team", Default: "ops"},
+		{Name: "urgent", Type: "boolean"},
+		{Name: "labels", Type: "list"},
+		{Name: "hours", Type: "integer", Default: 4},
+	},
+}
+
+func TestRender(t *testing.T) {
+	ctx := context.Background()
+	l, _ := Open("", nil)
+	if _, err := l.Save(ctx, triage); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	text, use, err := l.Render(ctx, "triage", 0, map[string]any{
+		"ticket": "OPS-7", "urgent": true, "labels": []any{"db", "prod"}, "hours": 2.0,
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	want := "Triage ticket OPS-7 for the OPS team. It is urgent.\nLabels: db, prod. Budget: 2h."
+	if text != want {
+		t.Errorf("Expected %q, got %q", want, text)
+	}
+	if use.Name != "triage" || use.Version != 1 || use.Variables["team"] != "ops" || use.Variables["hours"] != 2 {
+		t.Errorf("Expected the use recorded with defaults, got %+v", use)
+	}
+
+	_, _, err = l.Render(ctx, "triage", 0, map[string]any{"hours": 1.5, "priority": "high"})
+	if !errors.Is(err, chat.ErrInvalidVariables) {
+		t.Fatalf("Expected ErrInvalidVariables, got %v", err)
+	}
+	for _, want := range []string{`unknown variable "priority"`, `variable "hours" must be an integer`, `missing required variable "ticket"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+	if _, _, err := l.Render(ctx, "triage", 0, map[string]any{"ticket": "OPS-8", "labels": slices.Repeat([]any{"x"}, 101)}); !errors.Is(err, chat.ErrInvalidVariables) {
+		t.Errorf("Expected a list of more than %d items rejected, got %v", maxListItems, err)
+	}
+	if _, _, err := l.Render(ctx, "triage", 0, nil); !errors.Is(err, chat.ErrInvalidVariables) {
+		t.Errorf("Expected a missing required variable reported without variables, got %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	configured := []chat.Template{{Name: "greeting", Version: 3, Text: "Hello", Source: chat.TemplateFromConfig}}
+	l, err := Open(dir, func() []chat.Template { return configured })
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	l.Save(ctx, chat.Template{Name: "summary", Text: "Summarise briefly."})
+	second, err := l.Save(ctx, chat.Template{Name: "summary", Text: "Summarise in {{.words}} words.", Variables: []chat.Variable{{Name: "words", Type: "integer", Default: 50}}})
+	if err != nil || second.Version != 2 {
+		t.Fatalf("Expected version 2, got %+v, %v", second, err)
+	}
+	if _, err := l.Save(ctx, chat.Template{Name: "greeting", Text: "Hi"}); !errors.Is(err, chat.ErrTemplateReadOnly) {
+		t.Errorf("Expected configured templates to be read-only, got %v", err)
+	}
+
+	reopened, _ := Open(dir, func() []chat.Template { return configured })
+	if text, use, _ := reopened.Render(ctx, "summary", 1, nil); text != "Summarise briefly." || use.Version != 1 {
+		t.Errorf("Expected the first version kept, got %q %+v", text, use)
+	}
+	if text, _, _ := reopened.Render(ctx, "summary", 0, nil); text != "Summarise in 50 words." {
+		t.Errorf("Expected the latest version by default, got %q", text)
+	}
+	if versions, _ := reopened.Versions(ctx, "summary"); len(versions) != 2 || versions[0].Version != 2 {
+		t.Errorf("Expected versions newest first, got %+v", versions)
+	}
+	list, _ := reopened.List(ctx)
+	if len(list) != 2 || list[0].Name != "greeting" || list[1].Version != 2 {
+		t.Errorf("Expected the latest of each template by name, got %+v", list)
+	}
+	if _, err := reopened.Get(ctx, "greeting", 1); !errors.Is(err, chat.ErrTemplateNotFound) {
+		t.Errorf("Expected only the configured version, got %v", err)
+	}
+
+	reopened.Save(ctx, chat.Template{Name: "summary", Text: "Summarise.", CreatedBy: "alice"})
+	if names, err := reopened.Disown(ctx, "alice"); err != nil || len(names) != 1 || names[0] != "summary" {
+		t.Errorf("Expected alice's template disowned, got %v, %v", names, err)
+	}
+	if versions, _ := reopened.Versions(ctx, "summary"); len(versions) != 3 || versions[0].CreatedBy != "" {
+		t.Errorf("Expected the version kept without its author, got %+v", versions)
+	}
+
+	if err := reopened.Delete(ctx, "summary"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	again, _ := Open(dir, nil)
+	if _, err := again.Get(ctx, "summary", 1); !errors.Is(err, chat.ErrTemplateNotFound) {
+		t.Errorf("Expected the deleted template gone from disk, got %v", err)
+	}
+	if fourth, _ := again.Save(ctx, chat.Template{Name: "summary", Text: "Summarise."}); fourth.Version != 4 {
+		t.Errorf("Expected versions not reused after a delete, got %d", fourth.Version)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, tt := range []struct {
+		tmpl chat.Template
+		want string
+	}{
+		{chat.Template{Name: "a b", Text: "x"}, "name"},
+		{chat.Template{Name: "a"}, "text is required"},
+		{chat.Template{Name: "a", Text: "{{.x"}, "unclosed action"},
+		{chat.Template{Name: "a", Text: "{{.missing}}"}, `map has no entry for key "missing"`},
+		{chat.Template{Name: "a", Text: "x", Variables: []chat.Variable{{Name: "n", Type: "date"}}}, `type must be`},
+		{chat.Template{Name: "a", Text: "x", Variables: []chat.Variable{{Name: "n", Type: "integer", Default: "ten"}}}, `default must be an integer`},
+		{chat.Template{Name: "a", Text: "x", Variables: []chat.Variable{{Name: "n"}, {Name: "n"}}}, "declared twice"},
+		{chat.Template{Name: "a", Text: "{{range 1000000000}}{{end}}"}, "only allowed over a list variable"},
+		{chat.Template{Name: "a", Text: `{{define "x"}}{{template "x" .}}{{end}}{{template "x" .}}`}, "not allowed"},
+		{chat.Template{Name: "a", Text: `{{printf "%999999999d" 1}}`}, "printf widths"},
+		{chat.Template{Name: "a", Text: "{{range .l}}{{range $.l}}{{range $.l}}{{end}}{{end}}{{end}}", Variables: []chat.Variable{{Name: "l", Type: "list"}}}, "only nest 2 deep"},
+		{chat.Template{Name: "a", Text: "{{range .l}}{{range $.l}}{{$.s}}{{end}}{{end}}", Variables: []chat.Variable{
+			{Name: "l", Type: "list", Default: slices.Repeat([]any{"x"}, 100)},
+			{Name: "s", Default: strings.Repeat("x", 100)},
+		}}, "longer than"},
+	} {
+		err := Check(tt.tmpl)
+		if !errors.Is(err, chat.ErrInvalidTemplate) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected %q for %+v, got %v", tt.want, tt.tmpl, err)
+		}
+	}
+}
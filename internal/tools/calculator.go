@@ -0,0 +1,248 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"chat-service/internal/chat"
+)
+
+const (
+	maxExpression = 1000
+	// maxExponent keeps powers from producing numbers too large to print.
+	maxExponent = 1000
+	// maxDigits bounds operands and results, in decimal digits.
+	maxDigits = 10000
+)
+
+// Calculator evaluates arithmetic exactly, with rational numbers, so
+// 0.1 + 0.2 is 0.3 and 1/3 stays a fraction.
+func Calculator() chat.Tool {
+	return chat.Tool{
+		ToolDefinition: chat.ToolDefinition{
+			Name: "calculator",
+			Description: "Evaluate an arithmetic expression exactly. Supports + - * / % ^ and parentheses " +
+				"on integers and decimals. Use it for any arithmetic instead of computing in your head.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"expression":{"type":"string","description":"e.g. (1.5 + 2) * 3^2 / 7"}},` +
+				`"required":["expression"]}`),
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", err
+			}
+			v, err := Evaluate(in.Expression)
+			if err != nil {
+				return "", err
+			}
+			return formatRat(v), nil
+		},
+	}
+}
+
+// Evaluate parses and evaluates an arithmetic expression.
+func Evaluate(expr string) (*big.Rat, error) {
+	if len(expr) > maxExpression {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpression)
+	}
+	p := &parser{src: expr}
+	v, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.src[p.pos], p.pos+1)
+	}
+	return v, nil
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = unary { ("*" | "/" | "%") unary }
+//	unary  = ("-" | "+") unary | power
+//	power  = atom [ "^" unary ]
+//	atom   = number | "(" expr ")"
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes op if it is next.
+func (p *parser) accept(op byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expr() (*big.Rat, error) {
+	v, err := p.term()
+	for err == nil {
+		switch {
+		case p.accept('+'):
+			var r *big.Rat
+			if r, err = p.term(); err == nil {
+				v = new(big.Rat).Add(v, r)
+			}
+		case p.accept('-'):
+			var r *big.Rat
+			if r, err = p.term(); err == nil {
+				v = new(big.Rat).Sub(v, r)
+			}
+		default:
+			return v, checkSize(v)
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) term() (*big.Rat, error) {
+	v, err := p.unary()
+	for err == nil {
+		var op byte
+		switch {
+		case p.accept('*'):
+			op = '*'
+		case p.accept('/'):
+			op = '/'
+		case p.accept('%'):
+			op = '%'
+		default:
+			return v, nil
+		}
+		var r *big.Rat
+		if r, err = p.unary(); err != nil {
+			break
+		}
+		if op != '*' && r.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		switch op {
+		case '*':
+			v = new(big.Rat).Mul(v, r)
+		case '/':
+			v = new(big.Rat).Quo(v, r)
+		case '%':
+			if !v.IsInt() || !r.IsInt() {
+				return nil, errors.New("% needs integer operands")
+			}
+			v = new(big.Rat).SetInt(new(big.Int).Rem(v.Num(), r.Num()))
+		}
+		err = checkSize(v)
+	}
+	return nil, err
+}
+
+func (p *parser) unary() (*big.Rat, error) {
+	if p.accept('-') {
+		v, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).Neg(v), nil
+	}
+	if p.accept('+') {
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *parser) power() (*big.Rat, error) {
+	base, err := p.atom()
+	if err != nil || !p.accept('^') {
+		return base, err
+	}
+	exp, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if !exp.IsInt() {
+		return nil, errors.New("exponents must be integers")
+	}
+	n := exp.Num()
+	if n.CmpAbs(big.NewInt(maxExponent)) > 0 {
+		return nil, fmt.Errorf("exponents are limited to ±%d", maxExponent)
+	}
+	if base.Sign() == 0 && n.Sign() < 0 {
+		return nil, errors.New("division by zero")
+	}
+	abs := new(big.Int).Abs(n)
+	// The result has at most this many bits; check it before the work.
+	if bits := int64(base.Num().BitLen()+base.Denom().BitLen()) * abs.Int64(); bits > maxDigits*10/3 {
+		return nil, fmt.Errorf("numbers are limited to about %d digits", maxDigits)
+	}
+	v := new(big.Rat).SetFrac(
+		new(big.Int).Exp(base.Num(), abs, nil),
+		new(big.Int).Exp(base.Denom(), abs, nil),
+	)
+	if n.Sign() < 0 {
+		v.Inv(v)
+	}
+	return v, checkSize(v)
+}
+
+func (p *parser) atom() (*big.Rat, error) {
+	if p.accept('(') {
+		v, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		return v, nil
+	}
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.' || p.src[p.pos] == '_') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos == len(p.src) {
+			return nil, errors.New("unexpected end of expression")
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", p.src[p.pos], p.pos+1)
+	}
+	v, ok := new(big.Rat).SetString(strings.ReplaceAll(p.src[start:p.pos], "_", ""))
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+	}
+	return v, checkSize(v)
+}
+
+func checkSize(v *big.Rat) error {
+	// BitLen over-approximates digits by a factor of log2(10) ≈ 3.3.
+	if v.Num().BitLen()+v.Denom().BitLen() > maxDigits*10/3 {
+		return fmt.Errorf("numbers are limited to about %d digits", maxDigits)
+	}
+	return nil
+}
+
+// formatRat prints integers and terminating decimals exactly, and other
+// fractions as a fraction with a decimal approximation.
+func formatRat(v *big.Rat) string {
+	if v.IsInt() {
+		return v.Num().String()
+	}
+	if prec, exact := v.FloatPrec(); exact {
+		return v.FloatString(prec)
+	}
+	return fmt.Sprintf("%s (≈ %s)", v.RatString(), strings.TrimRight(v.FloatString(15), "0"))
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"0.1 + 0.2", "0.3"},
+		{"1/3", "1/3 (≈ 0.333333333333333)"},
+		{"1/3 * 3", "1"},
+		{"(1.5 + 2) * 3^2 / 7", "4.5"},
+		{"-2^2", "-4"},
+		{"2^3^2", "512"},
+		{"2^-2", "0.25"},
+		{"17 % 5 - -3", "5"},
+		{"1_000_000 * 1_000_000 * 1_000_000", "1000000000000000000"},
+	}
+	for _, tt := range tests {
+		v, err := Evaluate(tt.expr)
+		if err != nil {
+			t.Errorf("Evaluate(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := formatRat(v); got != tt.want {
+			t.Errorf("Evaluate(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"1 / (2 - 2)", "division by zero"},
+		{"2 ^ 0.5", "exponents must be integers"},
+		{"10 ^ 100000", "exponents are limited"},
+		{"(1 + 2", "missing )"},
+		{"1 +", "unexpected end"},
+		{"2 * x", `unexpected 'x'`},
+		{"1.5 % 2", "integer operands"},
+		{"9^999^2", "exponents are limited"},
+		{"(9^1000)^1000", "limited to about 10000 digits"},
+	}
+	for _, tt := range tests {
+		if _, err := Evaluate(tt.expr); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Evaluate(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestCalculatorTool(t *testing.T) {
+	got, err := Calculator().Handler(context.Background(), json.RawMessage(`{"expression":"12.5 * 4"}`))
+	if err != nil || got != "50" {
+		t.Errorf("Expected 50, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	// Embedded zone data, so conversions work in minimal containers.
+	_ "time/tzdata"
+
+	"chat-service/internal/chat"
+)
+
+// timeLayouts are accepted for the time to convert, most specific first.
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// Clock tells the current time in a time zone, or converts a given time
+// between zones. now supplies the current time; nil uses time.Now.
+func Clock(now func() time.Time) chat.Tool {
+	if now == nil {
+		now = time.Now
+	}
+	return chat.Tool{
+		ToolDefinition: chat.ToolDefinition{
+			Name: "clock",
+			Description: "Get the current date and time in a time zone, or convert a date and time " +
+				"from one time zone to another. Time zones are IANA names such as Europe/Paris; default UTC.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"timezone":{"type":"string","description":"zone to report the time in"},` +
+				`"time":{"type":"string","description":"time to convert instead of now, e.g. 2026-03-01 09:30 or RFC 3339"},` +
+				`"from":{"type":"string","description":"zone of time when it has no offset; default UTC"}}}`),
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				Timezone string `json:"timezone"`
+				Time     string `json:"time"`
+				From     string `json:"from"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", err
+			}
+			to, err := loadZone(in.Timezone)
+			if err != nil {
+				return "", err
+			}
+			t := now()
+			if in.Time != "" {
+				from, err := loadZone(in.From)
+				if err != nil {
+					return "", err
+				}
+				if t, err = parseTime(in.Time, from); err != nil {
+					return "", err
+				}
+			}
+			t = t.In(to)
+			out, _ := json.Marshal(map[string]string{
+				"time":       t.Format(time.RFC3339),
+				"timezone":   to.String(),
+				"weekday":    t.Weekday().String(),
+				"utc_offset": t.Format("-07:00"),
+			})
+			return string(out), nil
+		},
+	}
+}
+
+func loadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: use an IANA name such as America/New_York", name)
+	}
+	return loc, nil
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot read time %q: use e.g. 2026-03-01 09:30 or RFC 3339", s)
+}
@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	now := func() time.Time { return time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC) }
+	clock := Clock(now)
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{`{}`, `"time":"2026-07-01T12:00:00Z","timezone":"UTC"`},
+		{`{"timezone":"Asia/Tokyo"}`, `"time":"2026-07-01T21:00:00+09:00","timezone":"Asia/Tokyo","utc_offset":"+09:00","weekday":"Wednesday"`},
+		{`{"time":"2026-01-15 09:30","from":"America/New_York","timezone":"Europe/Paris"}`, `"time":"2026-01-15T15:30:00+01:00"`},
+		{`{"time":"2026-01-15T09:30:00Z","timezone":"America/New_York"}`, `"time":"2026-01-15T04:30:00-05:00"`},
+	}
+	for _, tt := range tests {
+		got, err := clock.Handler(context.Background(), json.RawMessage(tt.args))
+		if err != nil || !strings.Contains(got, tt.want) {
+			t.Errorf("clock(%s) = %s, %v; want it to contain %s", tt.args, got, err, tt.want)
+		}
+	}
+
+	for _, args := range []string{`{"timezone":"Mars/Olympus"}`, `{"time":"next tuesday"}`} {
+		if _, err := clock.Handler(context.Background(), json.RawMessage(args)); err == nil {
+			t.Errorf("Expected clock(%s) to fail", args)
+		}
+	}
+}
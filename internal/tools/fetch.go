@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"chat-service/internal/chat"
+	"chat-service/internal/htmltext"
+)
+
+const maxRedirects = 5
+
+// FetchOptions limits what the fetch tool may retrieve.
+type FetchOptions struct {
+	// Allowlist names the hosts that may be fetched: "example.com" matches
+	// that host on any port, "*.example.com" its subdomains, and
+	// "127.0.0.1:8080" one port. Redirects are checked too.
+	Allowlist []string
+	// MaxBytes bounds the response body read; the rest is cut off.
+	MaxBytes int64
+	// Timeout bounds the whole request, redirects included.
+	Timeout time.Duration
+	// Client performs requests; nil uses a new client.
+	Client *http.Client
+}
+
+// Fetch retrieves a web page or text document from an allowlisted host and
+// returns its text. HTML is reduced to readable text.
+func Fetch(opts FetchOptions) chat.Tool {
+	client := &http.Client{}
+	if opts.Client != nil {
+		c := *opts.Client
+		client = &c
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return checkURL(req.URL, opts.Allowlist)
+	}
+
+	return chat.Tool{
+		ToolDefinition: chat.ToolDefinition{
+			Name: "fetch",
+			Description: "Fetch a web page or text document over HTTP(S) and return its text. " +
+				"Only these hosts are allowed: " + strings.Join(opts.Allowlist, ", ") + ".",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"url":{"type":"string","description":"absolute http or https URL"}},` +
+				`"required":["url"]}`),
+		},
+		Timeout: opts.Timeout,
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var in struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return "", err
+			}
+			u, err := url.Parse(in.URL)
+			if err != nil {
+				return "", fmt.Errorf("invalid URL: %w", err)
+			}
+			if err := checkURL(u, opts.Allowlist); err != nil {
+				return "", err
+			}
+			return fetch(ctx, client, u, opts.MaxBytes)
+		},
+	}
+}
+
+// checkURL allows http(s) URLs without credentials to allowlisted hosts.
+func checkURL(u *url.URL, allowlist []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be fetched (got %q)", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("URLs with credentials cannot be fetched")
+	}
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	hostPort := net.JoinHostPort(host, port)
+	for _, entry := range allowlist {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return nil
+			}
+		case strings.Contains(entry, ":") && net.ParseIP(entry) == nil:
+			if entry == hostPort {
+				return nil
+			}
+		case entry == host:
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not on the fetch allowlist", u.Host)
+}
+
+func fetch(ctx context.Context, client *http.Client, u *url.URL, maxBytes int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "chat-service-fetch/1.0")
+	req.Header.Set("Accept", "text/html, text/plain, application/json;q=0.9, */*;q=0.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	textual := strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+	if mediaType != "" && !textual {
+		return "", fmt.Errorf("cannot read %s content", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	truncated := int64(len(body)) > maxBytes
+	if truncated {
+		body = body[:maxBytes]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "URL: %s\nStatus: %d\n", resp.Request.URL, resp.StatusCode)
+	text := string(body)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		title, content := htmltext.Extract(text)
+		if title != "" {
+			fmt.Fprintf(&sb, "Title: %s\n", title)
+		}
+		text = content
+	}
+	sb.WriteString("\n")
+	sb.WriteString(strings.TrimSpace(text))
+	if truncated {
+		fmt.Fprintf(&sb, "\n[truncated after %d bytes]", maxBytes)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>Release notes</title><style>p{}</style></head>
+<body><script>track()</script><h1>Version 2</h1><p>Faster <b>streaming</b>.</p>
+<ul><li>One</li><li>Two</li></ul></body></html>`))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 2000)))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.com/", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tool := Fetch(FetchOptions{Allowlist: []string{host}, MaxBytes: 1024, Timeout: 100 * time.Millisecond})
+	call := func(u string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), tool.Timeout)
+		defer cancel()
+		args, _ := json.Marshal(map[string]string{"url": u})
+		return tool.Handler(ctx, args)
+	}
+
+	got, err := call(server.URL + "/page")
+	want := "Status: 200\nTitle: Release notes\n\nVersion 2\nFaster streaming.\n- One\n- Two"
+	if err != nil || !strings.Contains(got, want) {
+		t.Errorf("Expected page text %q, got %q, %v", want, got, err)
+	}
+
+	got, err = call(server.URL + "/big")
+	if err != nil || !strings.HasSuffix(got, "\n"+strings.Repeat("a", 1024)+"\n[truncated after 1024 bytes]") {
+		t.Errorf("Expected the body cut at 1024 bytes, got %q, %v", got, err)
+	}
+
+	for path, wantErr := range map[string]string{
+		"/image": "cannot read image/png",
+		"/slow":  "deadline exceeded",
+		"/away":  "not on the fetch allowlist",
+	} {
+		if _, err := call(server.URL + path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: expected error %q, got %v", path, wantErr, err)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	allow := []string{"docs.example.com", "*.internal.test", "127.0.0.1:8080"}
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://docs.example.com/a", true},
+		{"http://docs.example.com:9000/a", true},
+		{"https://api.internal.test/", true},
+		{"https://evilinternal.test/", false},
+		{"http://127.0.0.1:8080/", true},
+		{"http://127.0.0.1:8081/", false},
+		{"https://example.com/", false},
+		{"ftp://docs.example.com/", false},
+		{"https://user:pw@docs.example.com/", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if err := checkURL(u, allow); (err == nil) != tt.allowed {
+			t.Errorf("checkURL(%s) = %v, want allowed %v", tt.url, err, tt.allowed)
+		}
+	}
+}
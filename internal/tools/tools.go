@@ -0,0 +1,41 @@
+// Package tools provides built-in tools for the model: exact arithmetic,
+// the current time and time zone conversion, and fetching allowlisted web
+// pages.
+package tools
+
+import (
+	"fmt"
+	"strings"
+
+	"chat-service/internal/chat"
+)
+
+// Names lists the built-in tools.
+func Names() []string {
+	return []string{"calculator", "clock", "fetch"}
+}
+
+// Register adds the named built-in tools to registry. The fetch tool is
+// limited by fetch.
+func Register(registry *chat.ToolRegistry, names []string, fetch FetchOptions) error {
+	for _, name := range names {
+		var t chat.Tool
+		switch name {
+		case "calculator":
+			t = Calculator()
+		case "clock":
+			t = Clock(nil)
+		case "fetch":
+			if len(fetch.Allowlist) == 0 {
+				return fmt.Errorf("the fetch tool needs an allowlist")
+			}
+			t = Fetch(fetch)
+		default:
+			return fmt.Errorf("unknown built-in tool %q: use %s", name, strings.Join(Names(), ", "))
+		}
+		if err := registry.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
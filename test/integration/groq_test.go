@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -28,7 +29,7 @@ func TestGroqIntegration(t *testing.T) {
 		{Role: chat.RoleUser, Content: "Say hello in one word."},
 	}
 
-	stream, err := client.StreamChat(messages)
+	stream, err := client.StreamChat(context.Background(), messages)
 	if err != nil {
 		t.Fatalf("Failed to call Groq API: %v", err)
 	}
@@ -37,7 +38,7 @@ func TestGroqIntegration(t *testing.T) {
 	done := make(chan bool)
 	go func() {
 		for chunk := range stream {
-			response += chunk
+			response += chunk.Content
 		}
 		done <- true
 	}()